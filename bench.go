@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// bench fetches a location over and over, a few requests at a time and no
+// faster than the given rate, then reports the latencies and how many of the
+// requests failed
+func bench(cfg *Config, tag string, requests int, concurrency int, rate float64) error {
+	var err error
+
+	if httpClient, err = newHTTPClient(cfg.WeatherAPI); err != nil {
+		return err
+	}
+
+	if len(cfg.Locations) < 1 || requests < 1 || concurrency < 1 {
+		return fmt.Errorf("Nothing to benchmark")
+	}
+
+	location := cfg.Locations[0]
+
+	if tag != "" {
+		found := false
+
+		for _, l := range cfg.Locations {
+			if l.Tag() == tag {
+				location, found = l, true
+				break
+			}
+		}
+
+		if !found {
+			return fmt.Errorf("Unknown location '%s'", tag)
+		}
+	}
+
+	// Only the main provider, the fallback would only muddle the numbers
+	p := providers[cfg.WeatherAPI.Provider](cfg.WeatherAPI)
+
+	// Hands out the go-ahead for each request at the rate allowed
+	start := make(chan bool)
+
+	go func() {
+		defer close(start)
+
+		var ticker Ticker
+
+		if rate > 0 {
+			ticker = clock.NewTicker(time.Duration(float64(time.Second) / rate))
+			defer ticker.Stop()
+		}
+
+		for i := 0; i < requests; i++ {
+			if ticker != nil && i > 0 {
+				<-ticker.C()
+			}
+
+			start <- true
+		}
+	}()
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	var latencies []time.Duration
+	failed := 0
+
+	began := clock.Now()
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for range start {
+				t := clock.Now()
+				_, err := p.Fetch(context.Background(), location)
+				latency := clock.Now().Sub(t)
+
+				mutex.Lock()
+				latencies = append(latencies, latency)
+
+				if err != nil {
+					failed++
+				}
+
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	elapsed := clock.Now().Sub(began)
+
+	sort.Slice(latencies, func(i int, j int) bool { return latencies[i] < latencies[j] })
+
+	percentile := func(p float64) time.Duration {
+		return latencies[int(p * float64(len(latencies) - 1))]
+	}
+
+	fmt.Printf("Fetched '%s' from %s %d times, %d at a time, in %v\n", location.Tag(), p.Name(), requests, concurrency, elapsed.Round(time.Millisecond))
+	fmt.Printf("Latency p50 %v, p90 %v, p99 %v, max %v\n", percentile(0.5), percentile(0.9), percentile(0.99), percentile(1))
+	fmt.Printf("Errors %d (%.1f%%)\n", failed, 100 * float64(failed) / float64(len(latencies)))
+
+	return nil
+}
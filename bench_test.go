@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestBench(t *testing.T) {
+	tests := []struct {
+		name string
+		tag string
+		requests int
+		concurrency int
+		rate float64
+		failing bool
+		wantErr string
+		want []string
+	}{
+		{"one at a time", "", 5, 1, 0, false, "", []string{"Fetched 'Lisbon' from fake 5 times, 1 at a time", "Errors 0 (0.0%)"}},
+		{"concurrent", "Porto", 20, 4, 0, false, "", []string{"Fetched 'Porto' from fake 20 times, 4 at a time", "Errors 0 (0.0%)"}},
+		{"rate limited", "", 3, 2, 1000, false, "", []string{"Fetched 'Lisbon' from fake 3 times, 2 at a time", "Errors 0 (0.0%)"}},
+		{"failing", "", 4, 2, 0, true, "", []string{"Errors 4 (100.0%)"}},
+		{"unknown location", "Faro", 5, 1, 0, false, "Unknown location 'Faro'", nil},
+		{"no requests", "", 0, 1, 0, false, "Nothing to benchmark", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := testConfig(t, "[weather_api]\nlocations = [ \"Lisbon\", \"Porto\" ]\n")
+
+			p := newFakeProvider()
+
+			if test.failing {
+				p.setFailing("Lisbon", fmt.Errorf("503 Service Unavailable"))
+			}
+
+			// Benchmarks whichever provider is configured
+			providers["fake"] = func(cfg WeatherAPIConfig) Provider { return p }
+			cfg.WeatherAPI.Provider = "fake"
+
+			previous := httpClient
+
+			t.Cleanup(func() {
+				delete(providers, "fake")
+				httpClient = previous
+			})
+
+			var err error
+			out := captureStdout(t, func() { err = bench(cfg, test.tag, test.requests, test.concurrency, test.rate) })
+
+			if test.wantErr == "" && err != nil || test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Fatalf("bench returned %v, want an error with '%s'", err, test.wantErr)
+			}
+
+			for _, want := range test.want {
+				if !strings.Contains(out, want) {
+					t.Errorf("Printed\n%s\nwant '%s' in it", out, want)
+				}
+			}
+
+			tag := test.tag
+
+			if tag == "" {
+				tag = "Lisbon"
+			}
+
+			if err == nil && p.fetchCount(tag) != test.requests {
+				t.Errorf("Fetched %d times, want %d", p.fetchCount(tag), test.requests)
+			}
+		})
+	}
+}
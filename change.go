@@ -0,0 +1,70 @@
+package main
+
+import (
+	"math"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+// Values of the watched fields as last written, and the number of points
+// skipped since, per location
+var lastWritten = map[string]map[string]float64{}
+var skipped = map[string]int{}
+
+// numericValue returns a field value as a float64, if it is numeric
+func numericValue(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int64:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	}
+
+	return 0, false
+}
+
+// significantChange reports whether a point should be written: when any of
+// the watched fields moved by more than its delta since the last write, or
+// when too many points in a row were skipped. The first point for a location
+// is always written. Points that aren't are counted as skipped, those that
+// are come with their watched values, to remember with changeWritten once
+// the write went through.
+func significantChange(cfg ChangeFilterConfig, location string, p *write.Point) (bool, map[string]float64) {
+	values := map[string]float64{}
+
+	for _, f := range p.FieldList() {
+		if _, watched := cfg.Deltas[f.Key]; watched {
+			if v, ok := numericValue(f.Value); ok {
+				values[f.Key] = v
+			}
+		}
+	}
+
+	previous, ok := lastWritten[location]
+	significant := !ok || skipped[location] >= cfg.MaxSkips
+
+	for key, value := range values {
+		old, ok := previous[key]
+
+		if !ok || math.Abs(value - old) > cfg.Deltas[key] {
+			significant = true
+		}
+	}
+
+	if !significant {
+		skipped[location]++
+		return false, nil
+	}
+
+	return true, values
+}
+
+// changeWritten makes a written point's watched values the ones later points
+// are compared with. A failed write leaves the previous ones, so the change
+// isn't lost.
+func changeWritten(location string, values map[string]float64) {
+	lastWritten[location] = values
+	skipped[location] = 0
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+func TestSignificantChange(t *testing.T) {
+	cfg := ChangeFilterConfig{Deltas: map[string]float64{"temperature": 0.2, "pressure": 1.0}, MaxSkips: 2}
+
+	type reading struct {
+		temperature, pressure float64
+	}
+
+	tests := []struct {
+		name string
+		readings []reading
+		want []bool
+	}{
+		{"first reading", []reading{{18.5, 1015}}, []bool{true}},
+		{"no change", []reading{{18.5, 1015}, {18.5, 1015}}, []bool{true, false}},
+		{"temperature moved", []reading{{18.5, 1015}, {18.8, 1015}}, []bool{true, true}},
+		{"pressure moved", []reading{{18.5, 1015}, {18.5, 1016.5}}, []bool{true, true}},
+		{"exactly the delta", []reading{{18.5, 1015}, {18.5, 1016}}, []bool{true, false}},
+		// Compared with the last written value, so a slow drift still shows
+		{"slow drift", []reading{{18.5, 1015}, {18.6, 1015}, {18.7, 1015}, {18.8, 1015}}, []bool{true, false, false, true}},
+		{"too many skipped", []reading{{18.5, 1015}, {18.5, 1015}, {18.5, 1015}, {18.5, 1015}, {18.5, 1015}}, []bool{true, false, false, true, false}},
+	}
+
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			location := fmt.Sprintf("Lisbon %d", i)
+
+			for j, r := range test.readings {
+				p := write.NewPointWithMeasurement("weather").AddField("temperature", r.temperature).AddField("pressure", r.pressure).AddField("humidity", int64(j))
+
+				got, watched := significantChange(cfg, location, p)
+
+				if got != test.want[j] {
+					t.Errorf("Reading %d (%v): significant %v, want %v", j + 1, r, got, test.want[j])
+				}
+
+				if got {
+					changeWritten(location, watched)
+				}
+			}
+		})
+	}
+}
+
+func TestNumericValue(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want float64
+		wantOK bool
+	}{
+		{18.5, 18.5, true},
+		{int64(-3), -3, true},
+		{uint64(70), 70, true},
+		{"18.5", 0, false},
+		{true, 0, false},
+	}
+
+	for _, test := range tests {
+		if got, ok := numericValue(test.value); got != test.want || ok != test.wantOK {
+			t.Errorf("numericValue(%#v) = %v, %v, want %v, %v", test.value, got, ok, test.want, test.wantOK)
+		}
+	}
+}
+
+func TestChangeFilterFailedWrite(t *testing.T) {
+	tests := []struct {
+		name string
+		temperatures []float32
+		// Whether the sink is down for each reading
+		down []bool
+		wantWritten int
+	}{
+		{"every write going through", []float32{18.5, 18.8, 18.8}, []bool{false, false, false}, 2},
+		// The failed change is compared against the last written reading
+		// again, not taken as written
+		{"change failing to write", []float32{18.5, 18.8, 18.8}, []bool{false, true, false}, 2},
+		{"first reading failing to write", []float32{18.5, 18.5}, []bool{true, false}, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, s := useFakes(t)
+			lastWritten = map[string]map[string]float64{}
+			skipped = map[string]int{}
+
+			cfg := testConfig(t, "[change_filter]\nenabled = true\n[change_filter.deltas]\ntemperature = 0.2\n")
+
+			for i, temperature := range test.temperatures {
+				if test.down[i] {
+					s.setFailing(fmt.Errorf("connection refused"))
+				} else {
+					s.setFailing(nil)
+				}
+
+				weather := testReading("Lisbon")
+				weather.Main.Temp = temperature
+
+				if err := writeWeather(context.Background(), cfg, weather, "Lisbon"); (err != nil) != test.down[i] {
+					t.Errorf("Reading %d: writing returned %v", i + 1, err)
+				}
+			}
+
+			if got := len(s.written("Lisbon")); got != test.wantWritten {
+				t.Errorf("Wrote %d readings, want %d", got, test.wantWritten)
+			}
+		})
+	}
+}
+
+func TestLoadConfigChangeFilter(t *testing.T) {
+	tests := []struct {
+		config string
+		wantErr bool
+	}{
+		{"enabled = true\n[change_filter.deltas]\ntemperature = 0.2\n", false},
+		{"enabled = true\n", true},
+		{"enabled = false\n", false},
+		{"enabled = true\nmax_skips = -1\n[change_filter.deltas]\ntemperature = 0.2\n", true},
+	}
+
+	for _, test := range tests {
+		_, err := loadTestConfig(t, "[sensor]\ninterval = 300\n[influxdb]\nmeasurement = \"weather\"\n[weather_api]\nappid = \"test\"\nlocations = [ \"Lisbon\" ]\n[change_filter]\n" + test.config)
+
+		if (err != nil) != test.wantErr {
+			t.Errorf("[change_filter] %q: loadConfig returned %v, want an error: %v", test.config, err, test.wantErr)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Client shared by all requests to the weather API
+var httpClient = http.DefaultClient
+
+// newHTTPClient builds the weather API client from the config. All requests
+// go to the same host, so the idle connection limit applies per host too.
+func newHTTPClient(cfg WeatherAPIConfig) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = cfg.MaxIdleConns
+	transport.MaxIdleConnsPerHost = cfg.MaxIdleConns
+	transport.IdleConnTimeout = time.Duration(cfg.IdleConnTimeout) * time.Second
+
+	if cfg.DNSCacheTTL > 0 {
+		// Same dialer settings as the default transport's
+		dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+		transport.DialContext = newDNSCache(time.Duration(cfg.DNSCacheTTL) * time.Second, dialer).DialContext
+	}
+
+	tlsConfig, err := newTLSConfig(cfg.TLS)
+
+	if err != nil {
+		return nil, err
+	}
+
+	transport.TLSClientConfig = tlsConfig
+
+	// Only meant for testing against a local mock with a self-signed cert
+	if cfg.InsecureSkipVerify {
+		log.Printf("WARNING: TLS certificate verification is disabled for the weather API, never do this in production!")
+		transport.TLSClientConfig.InsecureSkipVerify = true
+	}
+
+	// Every host the plan's endpoints are served from
+	hosts := map[string]bool{}
+
+	for endpoint := range endpoints {
+		hosts[apiHost(cfg.Plan, endpoint)] = true
+	}
+
+	client := &http.Client{
+		Transport: headerTransport{transport, hosts, cfg.Headers},
+		CheckRedirect: checkRedirect(cfg.MaxRedirects),
+	}
+
+	return client, nil
+}
+
+// checkRedirect follows up to max redirects, logging each of them since
+// they usually point at a misconfigured host or proxy. The query is left
+// out of the log, it carries the API key.
+func checkRedirect(max int) func(req *http.Request, via []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		from := via[len(via) - 1].URL
+		to := req.URL.Scheme + "://" + req.URL.Host + req.URL.Path
+
+		if len(via) > max {
+			return fmt.Errorf("Refusing to follow more than %d redirects, the last one to %s", max, to)
+		}
+
+		log.Printf("Following redirect from %s://%s%s to %s", from.Scheme, from.Host, from.Path, to)
+
+		return nil
+	}
+}
+
+// User-Agent sent unless the configured headers say otherwise
+const userAgent = "weather-sensor"
+
+// headerTransport adds the configured headers to requests to the weather
+// API hosts, e.g. for gateways that route or authenticate on them. They often
+// carry credentials, so requests to any other host, such as Open-Meteo or
+// wherever a redirect points, only get the User-Agent.
+type headerTransport struct {
+	base http.RoundTripper
+	hosts map[string]bool
+	headers map[string]string
+}
+
+func (t headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Requests mustn't be modified by round trippers
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", userAgent)
+
+	if t.hosts[req.URL.Host] {
+		for name, value := range t.headers {
+			req.Header.Set(name, value)
+		}
+	}
+
+	return t.base.RoundTrip(req)
+}
+
+// newTLSConfig loads the client certificate and the CA to trust, for proxies
+// that insist on them. Without either the system defaults apply.
+func newTLSConfig(cfg TLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+
+		if err != nil {
+			return nil, fmt.Errorf("Error loading the client certificate: %v", err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in %s", cfg.CAFile)
+		}
+
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, nil
+}
+
+// getJSON requests url and decodes the response into out, refusing bodies
+// larger than limit
+func getJSON(ctx context.Context, url string, limit int64, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+
+	if err != nil {
+		return err
+	}
+
+	span := trace.SpanFromContext(ctx)
+
+	resp, err := httpClient.Do(req)
+
+	if err != nil {
+		err = redactQuery(err)
+		span.RecordError(err)
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+
+	if resp.StatusCode / 100 == 2 {
+		// Read one byte past the limit so oversized bodies can be told apart
+		body, err := io.ReadAll(io.LimitReader(resp.Body, limit + 1))
+
+		if err != nil {
+			return err
+		}
+
+		span.SetAttributes(attribute.Int("http.response_content_length", len(body)))
+
+		if int64(len(body)) > limit {
+			return decodeError{fmt.Errorf("Response body exceeds the %d bytes limit", limit)}
+		}
+
+		if err := decodeJSON(body, out); err != nil {
+			return decodeError{err}
+		}
+
+		return nil
+	}
+
+	return statusError{resp.StatusCode}
+}
+
+// redactQuery leaves the query out of the URL of a failed request, it
+// carries the API key
+func redactQuery(err error) error {
+	var request *url.Error
+
+	if !errors.As(err, &request) {
+		return err
+	}
+
+	redacted := *request
+
+	if i := strings.Index(redacted.URL, "?"); i >= 0 {
+		redacted.URL = redacted.URL[:i]
+	}
+
+	return &redacted
+}
+
+// statusError is a response with a status other than 2xx
+type statusError struct {
+	StatusCode int
+}
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("Request failed with status: %d", e.StatusCode)
+}
+
+// decodeError is a successful response whose body couldn't be decoded
+type decodeError struct {
+	err error
+}
+
+func (e decodeError) Error() string {
+	return fmt.Sprintf("Error decoding the response: %v", e.err)
+}
+
+func (e decodeError) Unwrap() error {
+	return e.err
+}
+
+// fetchResult tells whether a fetch worked and otherwise where it failed:
+// reaching the API, in the API itself or decoding what it answered
+func fetchResult(err error) string {
+	var status statusError
+	var decode decodeError
+	var request *url.Error
+
+	switch {
+	case err == nil:
+		return "success"
+	case errors.As(err, &decode):
+		return "decode_error"
+	case errors.As(err, &status):
+		return "api_error"
+	case errors.As(err, &request):
+		return "http_error"
+	}
+
+	return "error"
+}
@@ -0,0 +1,506 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// hostsTransport sends requests for each host name to its test server
+type hostsTransport map[string]*httptest.Server
+
+func (t hostsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ts, ok := t[req.URL.Host]
+
+	if !ok {
+		return nil, fmt.Errorf("No test server for %s", req.URL.Host)
+	}
+
+	req = req.Clone(req.Context())
+	req.URL.Host = ts.Listener.Addr().String()
+
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// headerRecorder keeps the headers of the requests it answers
+type headerRecorder struct {
+	mutex sync.Mutex
+	headers []http.Header
+	handler http.HandlerFunc
+}
+
+func (h *headerRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mutex.Lock()
+	h.headers = append(h.headers, r.Header.Clone())
+	h.mutex.Unlock()
+
+	if h.handler != nil {
+		h.handler(w, r)
+	}
+}
+
+func TestHeaderTransport(t *testing.T) {
+	api := &headerRecorder{}
+	other := &headerRecorder{}
+
+	// The API sends one path off to another host
+	api.handler = func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/moved" {
+			http.Redirect(w, r, "http://elsewhere.example/data", http.StatusFound)
+		}
+	}
+
+	apiServer := httptest.NewServer(api)
+	defer apiServer.Close()
+	otherServer := httptest.NewServer(other)
+	defer otherServer.Close()
+
+	headers := map[string]string{"Authorization": "Bearer secret", "X-Api-Gateway-Key": "secret", "User-Agent": "custom"}
+
+	client := &http.Client{
+		Transport: headerTransport{hostsTransport{"api.openweathermap.org": apiServer, "elsewhere.example": otherServer}, map[string]bool{"api.openweathermap.org": true}, headers},
+		CheckRedirect: checkRedirect(3),
+	}
+
+	get := func(url string) {
+		t.Helper()
+
+		resp, err := client.Get(url)
+
+		if err != nil {
+			t.Fatalf("Error requesting %s: %v", url, err)
+		}
+
+		resp.Body.Close()
+	}
+
+	get("http://api.openweathermap.org/data/2.5/weather")
+	get("http://elsewhere.example/v1/forecast")
+	get("http://api.openweathermap.org/moved")
+
+	if len(api.headers) != 2 || len(other.headers) != 2 {
+		t.Fatalf("API got %d requests and the other host %d, want 2 each", len(api.headers), len(other.headers))
+	}
+
+	for i, h := range api.headers {
+		for name, value := range headers {
+			if got := h.Get(name); got != value {
+				t.Errorf("API request %d has %s '%s', want '%s'", i + 1, name, got, value)
+			}
+		}
+	}
+
+	for i, h := range other.headers {
+		for name := range headers {
+			if name == "User-Agent" {
+				continue
+			}
+
+			if got := h.Get(name); got != "" {
+				t.Errorf("Request %d to another host has %s '%s', want none", i + 1, name, got)
+			}
+		}
+
+		if got := h.Get("User-Agent"); got != userAgent {
+			t.Errorf("Request %d to another host has User-Agent '%s', want '%s'", i + 1, got, userAgent)
+		}
+	}
+}
+
+func TestCheckRedirect(t *testing.T) {
+	tests := []struct {
+		max int
+		// Redirects before the final answer
+		redirects int
+		wantErr bool
+	}{
+		{3, 0, false},
+		{3, 3, false},
+		{3, 4, true},
+		{0, 0, false},
+		{0, 1, true},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%d of %d", test.redirects, test.max), func(t *testing.T) {
+			hops := 0
+
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if hops < test.redirects {
+					hops++
+					http.Redirect(w, r, fmt.Sprintf("/hop/%d?appid=secret", hops), http.StatusFound)
+					return
+				}
+
+				fmt.Fprint(w, "{}")
+			}))
+			defer ts.Close()
+
+			client := &http.Client{CheckRedirect: checkRedirect(test.max)}
+			resp, err := client.Get(ts.URL + "/start?appid=secret")
+
+			if err == nil {
+				resp.Body.Close()
+			}
+
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Request returned %v, want an error: %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestGetJSONHidesQuery(t *testing.T) {
+	redirecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/x?appid=secret", http.StatusFound)
+	}))
+	defer redirecting.Close()
+
+	// Nothing listens there anymore
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	tests := []struct {
+		name string
+		url string
+	}{
+		{"too many redirects", redirecting.URL + "/data/2.5/weather?appid=secret&q=Lisbon"},
+		{"connection refused", down.URL + "/data/2.5/weather?appid=secret&q=Lisbon"},
+	}
+
+	previous := httpClient
+	httpClient = &http.Client{CheckRedirect: checkRedirect(2)}
+	defer func() { httpClient = previous }()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out WeatherResponse
+
+			err := getJSON(context.Background(), test.url, 1 << 20, &out)
+
+			if err == nil {
+				t.Fatalf("Request succeeded")
+			}
+
+			if strings.Contains(err.Error(), "appid") || strings.Contains(err.Error(), "secret") {
+				t.Errorf("Request error leaks the query: %v", err)
+			}
+
+			if fetchResult(err) != "http_error" {
+				t.Errorf("Request error %v isn't an HTTP error anymore", err)
+			}
+		})
+	}
+}
+
+func TestFetchResult(t *testing.T) {
+	tests := []struct {
+		err error
+		want string
+	}{
+		{nil, "success"},
+		{statusError{http.StatusServiceUnavailable}, "api_error"},
+		{fetchFailed{statusError{http.StatusUnauthorized}}, "api_error"},
+		{decodeError{errors.New("unexpected end of JSON input")}, "decode_error"},
+		{&url.Error{Op: "Get", URL: "https://api.openweathermap.org/", Err: context.DeadlineExceeded}, "http_error"},
+		{errors.New("something else"), "error"},
+	}
+
+	for _, test := range tests {
+		if got := fetchResult(test.err); got != test.want {
+			t.Errorf("fetchResult(%v) = %s, want %s", test.err, got, test.want)
+		}
+	}
+}
+
+func TestGetJSONBodyLimit(t *testing.T) {
+	const body = `{"name": "Lisbon"}`
+
+	tests := []struct {
+		name string
+		limit int64
+		wantErr bool
+	}{
+		{"under the limit", int64(len(body)) + 1, false},
+		{"at the limit", int64(len(body)), false},
+		{"over the limit", int64(len(body)) - 1, true},
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer ts.Close()
+
+	previous := httpClient
+	httpClient = ts.Client()
+	defer func() { httpClient = previous }()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out WeatherResponse
+
+			err := getJSON(context.Background(), ts.URL, test.limit, &out)
+
+			if (err != nil) != test.wantErr {
+				t.Fatalf("getJSON returned %v, want an error: %v", err, test.wantErr)
+			}
+
+			if test.wantErr && fetchResult(err) != "decode_error" {
+				t.Errorf("Oversized body gave %v, want a decoding error", err)
+			}
+
+			if !test.wantErr && out.Name != "Lisbon" {
+				t.Errorf("Decoded name '%s', want 'Lisbon'", out.Name)
+			}
+		})
+	}
+}
+
+func TestNewHTTPClientConnections(t *testing.T) {
+	tests := []struct {
+		name string
+		config string
+		wantIdle int
+		wantTimeout time.Duration
+	}{
+		{"defaults", "", 100, 90 * time.Second},
+		{"tuned", "[weather_api]\nmax_idle_conns = 4\nidle_conn_timeout = 30\n", 4, 30 * time.Second},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, err := newHTTPClient(testConfig(t, test.config).WeatherAPI)
+
+			if err != nil {
+				t.Fatalf("newHTTPClient returned %v", err)
+			}
+
+			transport := client.Transport.(headerTransport).base.(*http.Transport)
+
+			if transport.MaxIdleConns != test.wantIdle || transport.MaxIdleConnsPerHost != test.wantIdle {
+				t.Errorf("Keeps %d idle connections, %d per host, want %d", transport.MaxIdleConns, transport.MaxIdleConnsPerHost, test.wantIdle)
+			}
+
+			if transport.IdleConnTimeout != test.wantTimeout {
+				t.Errorf("Closes idle connections after %v, want %v", transport.IdleConnTimeout, test.wantTimeout)
+			}
+		})
+	}
+}
+
+func TestNewHTTPClientReusesConnections(t *testing.T) {
+	var mutex sync.Mutex
+	connections := 0
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	ts.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			mutex.Lock()
+			connections++
+			mutex.Unlock()
+		}
+	}
+	ts.Start()
+	defer ts.Close()
+
+	client, err := newHTTPClient(testConfig(t, "").WeatherAPI)
+
+	if err != nil {
+		t.Fatalf("newHTTPClient returned %v", err)
+	}
+
+	previous := httpClient
+	httpClient = client
+	defer func() { httpClient = previous }()
+
+	for i := 0; i < 3; i++ {
+		var out WeatherResponse
+
+		if err := getJSON(context.Background(), ts.URL, 1 << 20, &out); err != nil {
+			t.Fatalf("Request %d returned %v", i + 1, err)
+		}
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if connections != 1 {
+		t.Errorf("Opened %d connections for 3 requests, want 1", connections)
+	}
+}
+
+func TestNewHTTPClientInsecureSkipVerify(t *testing.T) {
+	// A local mock with a self-signed certificate
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		name string
+		config string
+		wantErr bool
+	}{
+		{"verified", "", true},
+		{"not verified", "[weather_api]\ninsecure_skip_verify = true\n", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, err := newHTTPClient(testConfig(t, test.config).WeatherAPI)
+
+			if err != nil {
+				t.Fatalf("newHTTPClient returned %v", err)
+			}
+
+			previous := httpClient
+			httpClient = client
+			defer func() { httpClient = previous }()
+
+			var out WeatherResponse
+
+			if err := getJSON(context.Background(), ts.URL, 1 << 20, &out); (err != nil) != test.wantErr {
+				t.Errorf("Requesting the mock returned %v, want an error: %v", err, test.wantErr)
+			}
+		})
+	}
+
+	// The default transport, used for everything else, still verifies
+	if http.DefaultTransport.(*http.Transport).TLSClientConfig != nil && http.DefaultTransport.(*http.Transport).TLSClientConfig.InsecureSkipVerify {
+		t.Errorf("Verification disabled for every client")
+	}
+}
+
+// writeClientCert writes a self-signed client certificate and its key to
+// dir, returning their paths
+func writeClientCert(t *testing.T, dir string) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{CommonName: "weather-sensor"},
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter: time.Now().Add(time.Hour),
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key")
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return certFile, keyFile
+}
+
+func TestNewHTTPClientTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeClientCert(t, dir)
+
+	// A proxy with a certificate of its own, optionally asking for ours
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	// The handshakes failing on purpose needn't be logged
+	ts.Config.ErrorLog = log.New(io.Discard, "", 0)
+	ts.StartTLS()
+	defer ts.Close()
+
+	caFile := filepath.Join(dir, "ca.pem")
+
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	notPEM := filepath.Join(dir, "empty.pem")
+
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		cfg TLSConfig
+		wantClientErr string
+		wantRequestErr bool
+	}{
+		{"certificate and CA", TLSConfig{CertFile: certFile, KeyFile: keyFile, CAFile: caFile}, "", false},
+		{"no certificate", TLSConfig{CAFile: caFile}, "", true},
+		{"CA not trusted", TLSConfig{CertFile: certFile, KeyFile: keyFile}, "", true},
+		{"key missing", TLSConfig{CertFile: certFile, CAFile: caFile}, "Error loading the client certificate", false},
+		{"no certificates in the CA file", TLSConfig{CAFile: notPEM}, "No certificates found", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, err := newHTTPClient(WeatherAPIConfig{TLS: test.cfg})
+
+			if test.wantClientErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantClientErr) {
+					t.Errorf("newHTTPClient returned %v, want an error with '%s'", err, test.wantClientErr)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("newHTTPClient returned %v", err)
+			}
+
+			previous := httpClient
+			httpClient = client
+			defer func() { httpClient = previous }()
+
+			var out WeatherResponse
+
+			if err := getJSON(context.Background(), ts.URL, 1 << 20, &out); (err != nil) != test.wantRequestErr {
+				t.Errorf("Requesting the proxy returned %v, want an error: %v", err, test.wantRequestErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock only moves when advanced, firing whatever timers and tickers
+// fall due along the way
+type fakeClock struct {
+	mutex sync.Mutex
+	now time.Time
+	timers []*fakeTimer
+	// Sleep moves the clock on by itself rather than wait for Advance, for
+	// code that sleeps on the test's own goroutine
+	selfAdvancing bool
+	slept []time.Duration
+}
+
+type fakeTimer struct {
+	at time.Time
+	// Zero for a one-off timer
+	period time.Duration
+	c chan time.Time
+	stopped bool
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	t := &fakeTimer{at: c.now.Add(d), c: make(chan time.Time, 1)}
+	c.timers = append(c.timers, t)
+
+	return t.c
+}
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.mutex.Lock()
+	c.slept = append(c.slept, d)
+	selfAdvancing := c.selfAdvancing
+	c.mutex.Unlock()
+
+	if selfAdvancing {
+		c.Advance(d)
+		return
+	}
+
+	<-c.After(d)
+}
+
+// useSelfAdvancingClock swaps in a fake clock whose sleeps return right
+// away for the length of the test
+func useSelfAdvancingClock(t *testing.T) *fakeClock {
+	c := newFakeClock()
+	c.selfAdvancing = true
+
+	previous := clock
+	clock = c
+	t.Cleanup(func() { clock = previous })
+
+	return c
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) Ticker {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	t := &fakeTimer{at: c.now.Add(d), period: d, c: make(chan time.Time)}
+	c.timers = append(c.timers, t)
+
+	return fakeTicker{c, t}
+}
+
+// Advance moves the clock forward. Ticks are handed over one at a time and
+// Advance only returns once each has been received, so by then the loop has
+// seen them.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	c.now = c.now.Add(d)
+	now := c.now
+
+	var ticks []chan time.Time
+	var pending []*fakeTimer
+
+	for _, t := range c.timers {
+		for !t.stopped && !t.at.After(now) {
+			if t.period == 0 {
+				t.c <- t.at
+				t.stopped = true
+				break
+			}
+
+			ticks = append(ticks, t.c)
+			t.at = t.at.Add(t.period)
+		}
+
+		if !t.stopped {
+			pending = append(pending, t)
+		}
+	}
+
+	c.timers = pending
+	c.mutex.Unlock()
+
+	for _, tick := range ticks {
+		tick <- now
+	}
+}
+
+// waitForTimers waits until n one-off timers are pending, e.g. for a
+// goroutine to start waiting on a timeout
+func (c *fakeClock) waitForTimers(t *testing.T, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for {
+		c.mutex.Lock()
+		pending := 0
+
+		for _, timer := range c.timers {
+			if timer.period == 0 {
+				pending++
+			}
+		}
+
+		c.mutex.Unlock()
+
+		if pending >= n {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("Gave up waiting for %d timers, %d pending", n, pending)
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+type fakeTicker struct {
+	clock *fakeClock
+	timer *fakeTimer
+}
+
+func (t fakeTicker) C() <-chan time.Time {
+	return t.timer.c
+}
+
+func (t fakeTicker) Reset(d time.Duration) {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+
+	t.timer.at = t.clock.now.Add(d)
+	t.timer.period = d
+}
+
+func (t fakeTicker) Stop() {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+
+	t.timer.stopped = true
+}
+
+// receiveTicks counts the ticks delivered while advancing the clock
+func receiveTicks(c *fakeClock, ticker Ticker, d time.Duration) int {
+	advanced := make(chan bool)
+
+	go func() {
+		c.Advance(d)
+		close(advanced)
+	}()
+
+	ticks := 0
+
+	for {
+		select {
+		case <-ticker.C():
+			ticks++
+		case <-advanced:
+			return ticks
+		}
+	}
+}
+
+func TestFakeClock(t *testing.T) {
+	c := newFakeClock()
+	start := c.Now()
+
+	after := c.After(10 * time.Second)
+	ticker := c.NewTicker(3 * time.Second)
+
+	if got := receiveTicks(c, ticker, 7 * time.Second); got != 2 {
+		t.Errorf("Got %d ticks after 7s of a 3s ticker, want 2", got)
+	}
+
+	select {
+	case <-after:
+		t.Errorf("10s timer fired after 7s")
+	default:
+	}
+
+	if got := receiveTicks(c, ticker, 3 * time.Second); got != 1 {
+		t.Errorf("Got %d ticks from 7s to 10s of a 3s ticker, want 1", got)
+	}
+
+	select {
+	case at := <-after:
+		if want := start.Add(10 * time.Second); !at.Equal(want) {
+			t.Errorf("Timer fired at %v, want %v", at, want)
+		}
+	default:
+		t.Errorf("10s timer didn't fire after 10s")
+	}
+
+	ticker.Reset(time.Minute)
+
+	if got := receiveTicks(c, ticker, 59 * time.Second); got != 0 {
+		t.Errorf("Got %d ticks before the reset interval was up, want 0", got)
+	}
+
+	ticker.Stop()
+
+	if got := receiveTicks(c, ticker, time.Hour); got != 0 {
+		t.Errorf("Got %d ticks from a stopped ticker, want 0", got)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+// collector gathers the points of every location in a cycle so they can
+// be written in a single request
+type collector struct {
+	mutex sync.Mutex
+	batches []batch
+}
+
+type collectorKey struct{}
+
+func withCollector(ctx context.Context) (context.Context, *collector) {
+	c := &collector{}
+
+	return context.WithValue(ctx, collectorKey{}, c), c
+}
+
+// collectorFrom is the cycle's collector, nil unless writes are coalesced
+func collectorFrom(ctx context.Context) *collector {
+	c, _ := ctx.Value(collectorKey{}).(*collector)
+
+	return c
+}
+
+func (c *collector) add(cfg SensorConfig, location string, points []*write.Point) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.batches = append(c.batches, batch{cfg: cfg, location: location, points: points})
+}
+
+// flush writes everything collected at once. If that fails, each location
+// is written on its own to find out which of them the sink refuses, only
+// to the sinks that failed.
+func (c *collector) flush(ctx context.Context, cfg *Config) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var points []*write.Point
+
+	for _, b := range c.batches {
+		points = append(points, b.points...)
+	}
+
+	if len(points) == 0 {
+		return nil
+	}
+
+	if writes != nil {
+		writes.enqueue(ctx, cfg.Sensor, fmt.Sprintf("%d locations", len(c.batches)), points)
+		return nil
+	}
+
+	// The fallback writes go to the same sink, even if it's replaced meanwhile
+	s, release := acquireSink()
+	defer release()
+
+	start := clock.Now()
+	err := s.Write(ctx, points)
+	recordWrite(start, len(points), err)
+
+	if err == nil {
+		checkSinks(cfg.Sensor, nil)
+		atomic.AddInt64(&stats.points, int64(len(points)))
+		return nil
+	}
+
+	logThrottled("Error writing the weather for %d locations together, writing them one by one: %v", len(c.batches), err)
+
+	// Sinks that took the points already aren't written to again
+	ctx = onlyFailedSinks(ctx, err)
+
+	var first error
+
+	for _, b := range c.batches {
+		start := clock.Now()
+		err := s.Write(ctx, b.points)
+		recordWrite(start, len(b.points), err)
+
+		if err != nil {
+			logThrottled("Error writing the weather for location '%s': %v", b.location, err)
+
+			if first == nil {
+				first = err
+			}
+
+			continue
+		}
+
+		atomic.AddInt64(&stats.points, int64(len(b.points)))
+	}
+
+	checkSinks(cfg.Sensor, first)
+
+	return first
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+func locationPoint(location string) *write.Point {
+	return write.NewPointWithMeasurement("weather").AddTag("location", location).AddField("temperature", 18.5)
+}
+
+func TestCollectorFlush(t *testing.T) {
+	tests := []struct {
+		name string
+		sinks []*memorySink
+		wantErr bool
+		// Points each sink ends up with, by location
+		want []map[string]int
+	}{
+		{
+			name: "every sink up",
+			sinks: []*memorySink{{}, {}},
+			want: []map[string]int{{"Lisbon": 1, "Porto": 1}, {"Lisbon": 1, "Porto": 1}},
+		},
+		{
+			name: "one sink down",
+			sinks: []*memorySink{{fail: fmt.Errorf("connection refused")}, {}},
+			wantErr: true,
+			want: []map[string]int{{"Lisbon": 0, "Porto": 0}, {"Lisbon": 1, "Porto": 1}},
+		},
+		{
+			name: "one sink refusing a location",
+			sinks: []*memorySink{{refuse: "Porto"}, {}},
+			wantErr: true,
+			want: []map[string]int{{"Lisbon": 1, "Porto": 0}, {"Lisbon": 1, "Porto": 1}},
+		},
+		{
+			name: "single sink refusing a location",
+			sinks: []*memorySink{{refuse: "Porto"}},
+			wantErr: true,
+			want: []map[string]int{{"Lisbon": 1, "Porto": 0}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var s Sink = test.sinks[0]
+
+			if len(test.sinks) > 1 {
+				m := &multiSink{}
+
+				for _, ms := range test.sinks {
+					m.sinks = append(m.sinks, ms)
+				}
+
+				s = m
+			}
+
+			previous := currentSink()
+			setSink(s)
+			defer setSink(previous)
+
+			ctx, c := withCollector(context.Background())
+
+			for _, location := range []string{"Lisbon", "Porto"} {
+				collectorFrom(ctx).add(SensorConfig{}, location, []*write.Point{locationPoint(location)})
+			}
+
+			err := c.flush(ctx, &Config{})
+
+			if (err != nil) != test.wantErr {
+				t.Errorf("flush returned %v, want an error: %v", err, test.wantErr)
+			}
+
+			for i, want := range test.want {
+				for location, n := range want {
+					if got := len(test.sinks[i].written(location)); got != n {
+						t.Errorf("Sink %d got %d points for '%s', want %d", i + 1, got, location, n)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/knadh/koanf"
+	"github.com/knadh/koanf/parsers/toml"
+	"github.com/knadh/koanf/providers/confmap"
+	"github.com/knadh/koanf/providers/rawbytes"
+)
+
+// printConfig writes the effective config, defaults included, as TOML
+func printConfig(cfg *Config) error {
+	out, err := toml.Parser().Marshal(configMap(reflect.ValueOf(cfg), "").(map[string]interface{}))
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(string(out))
+
+	return nil
+}
+
+// testSinks checks every configured sink can be reached, reporting each one
+// and failing if any of them can't
+func testSinks(cfg *Config) error {
+	s, err := newSink(cfg)
+
+	if err != nil {
+		return err
+	}
+
+	defer s.Close()
+
+	failed := 0
+
+	for _, s := range sinkList(s) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10 * time.Second)
+		err := s.Health(ctx)
+		cancel()
+
+		if err != nil {
+			failed++
+			fmt.Printf("%v: FAIL (%v)\n", s, err)
+		} else {
+			fmt.Printf("%v: PASS\n", s)
+		}
+	}
+
+	if failed > 0 {
+		return errors.New("Some sinks are unhealthy")
+	}
+
+	return nil
+}
+
+// Config keys that have been renamed, old name to new name. Add an entry
+// here whenever a key is renamed so migrate can carry old configs over.
+var renames = map[string]string{
+	// Kafka became a sink of its own, written next to InfluxDB
+	"influxdb.kafka.enabled": "kafka.enabled",
+	"influxdb.kafka.brokers": "kafka.brokers",
+	"influxdb.kafka.topic": "kafka.topic",
+}
+
+// migrateConfig upgrades the config at path in place, keeping the original
+// next to it with a .bak suffix. Renamed keys are moved to their new names,
+// everything else is kept as it is, so keys the file leaves out keep
+// following the defaults. The result is checked to load before anything is
+// written, and an existing backup is never overwritten. Comments and key
+// order are not preserved.
+func migrateConfig(path string) error {
+	original, err := os.ReadFile(path)
+
+	if err != nil {
+		return err
+	}
+
+	old := koanf.New(".")
+
+	if err := old.Load(rawbytes.Provider(original), toml.Parser()); err != nil {
+		return err
+	}
+
+	upgraded := map[string]interface{}{}
+	renamed := 0
+
+	keys := old.Keys()
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := old.Get(key)
+
+		if to, ok := renames[key]; ok {
+			if old.Exists(to) {
+				return fmt.Errorf("Both %s and its new name %s are set, keep only one", key, to)
+			}
+
+			fmt.Printf("Renamed %s to %s\n", key, to)
+			key = to
+			renamed++
+		}
+
+		upgraded[key] = value
+	}
+
+	if renamed == 0 {
+		fmt.Println("Nothing to migrate")
+		return nil
+	}
+
+	k := koanf.New(".")
+	if err := k.Load(confmap.Provider(upgraded, "."), nil); err != nil {
+		return err
+	}
+
+	out, err := k.Marshal(toml.Parser())
+
+	if err != nil {
+		return err
+	}
+
+	backup := path + ".bak"
+
+	if _, err := os.Stat(backup); err == nil {
+		return fmt.Errorf("%s already exists, move it out of the way to migrate again", backup)
+	}
+
+	// Make sure the result loads before touching the config
+	migrated := path + ".new"
+
+	if err := os.WriteFile(migrated, out, 0600); err != nil {
+		return err
+	}
+
+	defer os.Remove(migrated)
+
+	if _, err := loadConfig(migrated); err != nil {
+		return fmt.Errorf("Migrated config doesn't load, leaving %s as it is: %v", path, err)
+	}
+
+	f, err := os.OpenFile(backup, os.O_WRONLY | os.O_CREATE | os.O_EXCL, 0600)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(original)
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(migrated, path)
+}
+
+// replay runs a saved weather API response, read from path or from stdin if
+// path is empty or "-", through the same write path as fetched readings
+func replay(cfg *Config, path string, location string) error {
+	var in io.Reader = os.Stdin
+
+	if path != "" && path != "-" {
+		f, err := os.Open(path)
+
+		if err != nil {
+			return err
+		}
+
+		defer f.Close()
+		in = f
+	}
+
+	data, err := io.ReadAll(in)
+
+	if err != nil {
+		return err
+	}
+
+	var weather WeatherResponse
+
+	if err := decodeJSON(data, &weather); err != nil {
+		return err
+	}
+
+	if location == "" {
+		location = weather.Name
+	}
+
+	if !*dryRunFlag {
+		s, err := newSink(cfg)
+
+		if err == nil {
+			s, err = withWAL(s, cfg.Debug)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		defer s.Close()
+		setSink(s)
+	}
+
+	if err := writeWeather(context.Background(), cfg, weather, location); err != nil {
+		return err
+	}
+
+	log.Printf("Replayed reading for location '%s'", location)
+
+	return nil
+}
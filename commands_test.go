@@ -0,0 +1,341 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMigrateConfig(t *testing.T) {
+	const current = baseConfig
+	const old = `
+[sensor]
+interval = 300
+[weather_api]
+appid = "test"
+units = "metric"
+locations = [ "Lisbon" ]
+[influxdb]
+host = "http://influx:8086/"
+measurement = "weather"
+`
+
+	tests := []struct {
+		name string
+		renames map[string]string
+		config string
+		backup bool
+		wantErr string
+		// Whether the config is rewritten, and a backup made
+		wantMigrated bool
+	}{
+		{"renamed key", map[string]string{"influxdb.host": "influxdb.hostname"}, old, false, "", true},
+		{"nothing to migrate", map[string]string{"influxdb.host": "influxdb.hostname"}, current, false, "", false},
+		{"backup in the way", map[string]string{"influxdb.host": "influxdb.hostname"}, old, true, "already exists", false},
+		{"result doesn't load", map[string]string{"influxdb.host": "influxdb.hostnaem"}, old, false, "doesn't load", false},
+		{"old and new name set", map[string]string{"influxdb.host": "influxdb.hostname"}, old + "hostname = \"http://influx:8086/\"\n", false, "Both", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			previous := renames
+			renames = test.renames
+			defer func() { renames = previous }()
+
+			path := filepath.Join(t.TempDir(), "config.toml")
+
+			if err := os.WriteFile(path, []byte(test.config), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			if test.backup {
+				if err := os.WriteFile(path + ".bak", []byte("# the real backup\n"), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := migrateConfig(path)
+
+			if test.wantErr == "" && err != nil || test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Fatalf("migrateConfig returned %v, want an error with '%s'", err, test.wantErr)
+			}
+
+			migrated, _ := os.ReadFile(path)
+			backup, backupErr := os.ReadFile(path + ".bak")
+
+			if _, err := os.Stat(path + ".new"); err == nil {
+				t.Errorf("Left the migrated config behind")
+			}
+
+			if !test.wantMigrated {
+				if string(migrated) != test.config {
+					t.Errorf("Config changed to:\n%s", migrated)
+				}
+
+				if test.backup && string(backup) != "# the real backup\n" {
+					t.Errorf("Backup overwritten with:\n%s", backup)
+				}
+
+				if !test.backup && backupErr == nil {
+					t.Errorf("Made a backup without migrating")
+				}
+
+				return
+			}
+
+			if string(backup) != test.config {
+				t.Errorf("Backup is:\n%s\nwant the original:\n%s", backup, test.config)
+			}
+
+			cfg, err := loadConfig(path)
+
+			if err != nil {
+				t.Fatalf("Migrated config doesn't load: %v", err)
+			}
+
+			if cfg.InfluxDB.Hostname != "http://influx:8086/" {
+				t.Errorf("influxdb.hostname is '%s' after migrating", cfg.InfluxDB.Hostname)
+			}
+
+			// Defaults stay defaults rather than being frozen into the file
+			if strings.Contains(string(migrated), "when_full") || strings.Contains(string(migrated), "startup_retries") {
+				t.Errorf("Migrated config has defaults written out:\n%s", migrated)
+			}
+
+			// Running it again has nothing left to do and keeps the backup
+			if err := migrateConfig(path); err != nil {
+				t.Errorf("Migrating again returned %v", err)
+			}
+
+			if again, _ := os.ReadFile(path + ".bak"); string(again) != test.config {
+				t.Errorf("Migrating again replaced the backup with:\n%s", again)
+			}
+		})
+	}
+}
+
+func TestMigrateConfigRenames(t *testing.T) {
+	tests := []struct {
+		name string
+		config string
+		wantKafka KafkaConfig
+	}{
+		{
+			"Kafka under influxdb",
+			baseConfig + "[influxdb.kafka]\nenabled = true\nbrokers = [ \"kafka-a:9092\", \"kafka-b:9092\" ]\ntopic = \"readings\"\n",
+			KafkaConfig{Enabled: true, Brokers: []string{"kafka-a:9092", "kafka-b:9092"}, Topic: "readings"},
+		},
+		{
+			"Kafka partly set",
+			baseConfig + "[influxdb.kafka]\ntopic = \"readings\"\n",
+			KafkaConfig{Topic: "readings"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.toml")
+
+			if err := os.WriteFile(path, []byte(test.config), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			// The old layout doesn't load anymore
+			if _, err := loadConfig(path); err == nil {
+				t.Fatalf("Old config loads without migrating")
+			}
+
+			if err := migrateConfig(path); err != nil {
+				t.Fatalf("migrateConfig returned %v", err)
+			}
+
+			cfg, err := loadConfig(path)
+
+			if err != nil {
+				t.Fatalf("Migrated config doesn't load: %v", err)
+			}
+
+			if !reflect.DeepEqual(cfg.Kafka, test.wantKafka) {
+				t.Errorf("Kafka is %+v after migrating, want %+v", cfg.Kafka, test.wantKafka)
+			}
+
+			if migrated, _ := os.ReadFile(path); strings.Contains(string(migrated), "[influxdb.kafka]") {
+				t.Errorf("Migrated config still has [influxdb.kafka]:\n%s", migrated)
+			}
+		})
+	}
+}
+
+// captureStdout returns what f prints to stdout
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previous := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = previous }()
+
+	printed := make(chan string)
+
+	go func() {
+		out, _ := io.ReadAll(r)
+		printed <- string(out)
+	}()
+
+	f()
+	w.Close()
+
+	return <-printed
+}
+
+func TestPrintConfigRedactsSecrets(t *testing.T) {
+	tests := []struct {
+		name string
+		config string
+		secret string
+	}{
+		{"API key", "[weather_api]\nappid = \"secret-appid\"\n", "secret-appid"},
+		{"API headers", "[weather_api.headers]\nX-Api-Gateway-Key = \"secret-header\"\n", "secret-header"},
+		{"InfluxDB token", "[influxdb]\ntoken = \"secret-token\"\n", "secret-token"},
+		{"instance token", "[[influxdb.instances]]\nhostname = \"http://influx-a:8086/\"\ntoken = \"secret-instance\"\n", "secret-instance"},
+		{"metrics token", "[metrics.auth]\ntoken = \"secret-bearer\"\n", "secret-bearer"},
+		{"metrics password", "[metrics.auth]\nusername = \"admin\"\npassword = \"secret-password\"\n", "secret-password"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := testConfig(t, test.config)
+
+			var err error
+			out := captureStdout(t, func() { err = printConfig(cfg) })
+
+			if err != nil {
+				t.Fatalf("printConfig returned %v", err)
+			}
+
+			if strings.Contains(out, test.secret) {
+				t.Errorf("Printed the secret:\n%s", out)
+			}
+
+			if !strings.Contains(out, "REDACTED") {
+				t.Errorf("Printed no redacted value:\n%s", out)
+			}
+
+			// Everything else is shown as is
+			if !strings.Contains(out, "http://influx:8086/") {
+				t.Errorf("Printed no InfluxDB hostname:\n%s", out)
+			}
+		})
+	}
+}
+
+func TestTestSinks(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"name": "influxdb", "status": "pass"}`))
+	}))
+	defer up.Close()
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	instance := func(url string) string {
+		return "[[influxdb.instances]]\nhostname = \"" + url + "\"\n"
+	}
+
+	tests := []struct {
+		name string
+		config string
+		wantErr bool
+		wantLines []string
+	}{
+		{"one up", instance(up.URL), false, []string{"InfluxDB at " + up.URL + ": PASS"}},
+		{"one down", instance(down.URL), true, []string{"InfluxDB at " + down.URL + ": FAIL"}},
+		{"one of two down", instance(up.URL) + instance(down.URL), true, []string{"InfluxDB at " + up.URL + ": PASS", "InfluxDB at " + down.URL + ": FAIL"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := testConfig(t, test.config)
+
+			var err error
+			out := captureStdout(t, func() { err = testSinks(cfg) })
+
+			if (err != nil) != test.wantErr {
+				t.Errorf("testSinks returned %v, want an error: %v", err, test.wantErr)
+			}
+
+			lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+
+			if len(lines) != len(test.wantLines) {
+				t.Fatalf("Printed\n%s\nwant a line for each of the %d sinks", out, len(test.wantLines))
+			}
+
+			for i, want := range test.wantLines {
+				if !strings.HasPrefix(lines[i], want) {
+					t.Errorf("Line %d is '%s', want it to start with '%s'", i + 1, lines[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestReplay(t *testing.T) {
+	const saved = `{"name": "Lisboa", "dt": 1654084800, "sys": {"country": "PT"}, "main": {"temp": 18.5, "humidity": 70}}`
+
+	tests := []struct {
+		name string
+		payload string
+		location string
+		wantErr bool
+		want string
+	}{
+		{"location from the payload", saved, "", false, "weather,location=Lisboa,city=Lisboa,country=PT "},
+		{"location given", saved, "Lisbon", false, "weather,location=Lisbon,city=Lisboa,country=PT "},
+		{"not JSON", `<html>502 Bad Gateway</html>`, "", true, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := &influxRecorder{}
+			ts := httptest.NewServer(server)
+			defer ts.Close()
+
+			cfg := testConfig(t, "[influxdb]\nhostname = \"" + ts.URL + "\"\n")
+			// Put back the sink replay swaps in
+			useFakes(t)
+
+			path := filepath.Join(t.TempDir(), "response.json")
+
+			if err := os.WriteFile(path, []byte(test.payload), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := replay(cfg, path, test.location); (err != nil) != test.wantErr {
+				t.Fatalf("replay returned %v, want an error: %v", err, test.wantErr)
+			}
+
+			if test.wantErr {
+				if len(server.bodies) != 0 {
+					t.Errorf("Wrote %v for a payload that doesn't decode", server.bodies)
+				}
+
+				return
+			}
+
+			if len(server.bodies) != 1 || !strings.HasPrefix(server.bodies[0], test.want) {
+				t.Errorf("Wrote %v, want a line starting with '%s'", server.bodies, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,738 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/knadh/koanf"
+	"github.com/knadh/koanf/parsers/toml"
+	"github.com/knadh/koanf/providers/confmap"
+	"github.com/knadh/koanf/providers/file"
+	"github.com/mitchellh/mapstructure"
+	"github.com/zalando/go-keyring"
+)
+
+type SensorConfig struct {
+	Interval int `koanf:"interval"`
+	// Shortest interval allowed for the sensor and each location, lower ones
+	// are raised to it. 0 allows any.
+	MinInterval int `koanf:"min_interval"`
+	ErrorPolicy string `koanf:"error_policy"`
+	// Pause between the locations of a cycle
+	InterLocationDelay time.Duration `koanf:"inter_location_delay"`
+	// Times the unfetched locations of a cycle in which every location failed
+	// are fetched again
+	CycleRetries int `koanf:"cycle_retries"`
+	CycleRetryDelay time.Duration `koanf:"cycle_retry_delay"`
+	// Give up after this many unauthorized fetches or failed writes in a
+	// row, 0 never gives up
+	MaxAuthFailures int `koanf:"max_auth_failures"`
+	MaxSinkFailures int `koanf:"max_sink_failures"`
+}
+
+type LocationConfig struct {
+	Name string `koanf:"name"`
+	Alias string `koanf:"alias"`
+	// Alternatives to name and alias, the query can also be "lat,lon"
+	Query string `koanf:"query"`
+	// Postal code with its country, e.g. "10001,US"
+	Zip string `koanf:"zip"`
+	// IANA time zone name, e.g. "Europe/London"
+	TimeZone string `koanf:"timezone"`
+	Display string `koanf:"display"`
+	Latitude *float64 `koanf:"latitude"`
+	Longitude *float64 `koanf:"longitude"`
+	Interval int `koanf:"interval"`
+	Nearby int `koanf:"nearby"`
+}
+
+type GridConfig struct {
+	North float64 `koanf:"north"`
+	South float64 `koanf:"south"`
+	East float64 `koanf:"east"`
+	West float64 `koanf:"west"`
+	Step float64 `koanf:"step"`
+	Interval int `koanf:"interval"`
+}
+
+type WeatherAPIConfig struct {
+	Locations []string `koanf:"locations"`
+	Location []LocationConfig `koanf:"location"`
+	Grid []GridConfig `koanf:"grid"`
+	LocationsFile string `koanf:"locations_file"`
+	AppID string `koanf:"appid"`
+	Keyring KeyringConfig `koanf:"keyring"`
+	Units string `koanf:"units"`
+	Plan string `koanf:"plan"`
+	MaxBodyBytes int64 `koanf:"max_body_bytes"`
+	MaxIdleConns int `koanf:"max_idle_conns"`
+	IdleConnTimeout int `koanf:"idle_conn_timeout"`
+	// Seconds the last resolved addresses are reused for when DNS fails
+	DNSCacheTTL int `koanf:"dns_cache_ttl"`
+	InsecureSkipVerify bool `koanf:"insecure_skip_verify"`
+	TLS TLSConfig `koanf:"tls"`
+	// Redirects followed before a request fails, 0 follows none
+	MaxRedirects int `koanf:"max_redirects"`
+	// Sent with every request to the API host, overriding the User-Agent if
+	// given
+	Headers map[string]string `koanf:"headers"`
+	Provider string `koanf:"provider"`
+	// Seconds a request can take, unless its endpoint has its own timeout
+	Timeout int `koanf:"timeout"`
+	Timeouts map[string]int `koanf:"timeouts"`
+	// Provider to fall back to when the main one keeps failing
+	Fallback string `koanf:"fallback"`
+	// Failures in a row for a location before falling back
+	FallbackAfter int `koanf:"fallback_after"`
+}
+
+// timeout is how long a request to the given endpoint can take
+func (cfg WeatherAPIConfig) timeout(endpoint string) time.Duration {
+	if seconds, ok := cfg.Timeouts[endpoint]; ok {
+		return time.Duration(seconds) * time.Second
+	}
+
+	return time.Duration(cfg.Timeout) * time.Second
+}
+
+type KeyringConfig struct {
+	// Entry holding the API key in the system keyring, unused when empty
+	Service string `koanf:"service"`
+	User string `koanf:"user"`
+}
+
+type TLSConfig struct {
+	CertFile string `koanf:"cert_file"`
+	KeyFile string `koanf:"key_file"`
+	CAFile string `koanf:"ca_file"`
+}
+
+type UDPConfig struct {
+	Enabled bool `koanf:"enabled"`
+	Address string `koanf:"address"`
+}
+
+// Fields of the minimal profile, the others don't limit the fields
+var minimalFields = []string{"temperature", "humidity", "pressure"}
+
+// fields lists the fields to write, nil when all of them are
+func (cfg InfluxDBConfig) fields() []string {
+	if len(cfg.Fields) > 0 {
+		return cfg.Fields
+	}
+
+	if cfg.Profile == "minimal" {
+		return minimalFields
+	}
+
+	return nil
+}
+
+// applyProfile turns on every derived field for the full profile
+func (cfg *Config) applyProfile() {
+	if cfg.InfluxDB.Profile != "full" {
+		return
+	}
+
+	cfg.InfluxDB.IconURL = true
+	cfg.InfluxDB.IngestLag = true
+	cfg.Derived.AllUnits = true
+	cfg.Derived.WindSpeedEMA = true
+	cfg.Derived.WindBearingRadians = true
+
+	if cfg.Derived.ApparentTemperature == "" {
+		cfg.Derived.ApparentTemperature = "nws"
+	}
+
+	if len(cfg.Derived.PressureUnits) == 0 {
+		cfg.Derived.PressureUnits = []string{"inhg", "mmhg"}
+	}
+}
+
+type KafkaConfig struct {
+	Enabled bool `koanf:"enabled"`
+	Brokers []string `koanf:"brokers"`
+	Topic string `koanf:"topic"`
+}
+
+type InfluxInstanceConfig struct {
+	Hostname string `koanf:"hostname"`
+	Token string `koanf:"token"`
+	Org string `koanf:"org"`
+	Bucket string `koanf:"bucket"`
+	// Writes to the instance at once, 0 for no limit
+	MaxInFlight int `koanf:"max_in_flight"`
+
+	// Taken from influxdb.use_gzip, it applies to every instance
+	UseGZip bool `koanf:"-"`
+}
+
+type InfluxDBConfig struct {
+	Hostname string `koanf:"hostname"`
+	Token string `koanf:"token"`
+	Org string `koanf:"org"`
+	Bucket string `koanf:"bucket"`
+	// When set, points are written to each of these instead
+	Instances []InfluxInstanceConfig `koanf:"instances"`
+	Measurement string `koanf:"measurement"`
+	StartupRetries int `koanf:"startup_retries"`
+	RoundTime time.Duration `koanf:"round_time"`
+	OmitAbsentGust bool `koanf:"omit_absent_gust"`
+	OmitAbsentVisibility bool `koanf:"omit_absent_visibility"`
+	StationTag bool `koanf:"station_tag"`
+	IconURL bool `koanf:"icon_url"`
+	IngestLag bool `koanf:"ingest_lag"`
+	// Store every weather condition reported and how many there are
+	Conditions bool `koanf:"conditions"`
+	// Decimal places float fields are rounded to, unset to store them as is
+	FloatPrecision *int `koanf:"float_precision"`
+	// Compress write requests
+	UseGZip bool `koanf:"use_gzip"`
+	// Writes at once, 0 for no limit
+	MaxInFlight int `koanf:"max_in_flight"`
+	// Preset of fields to write, "minimal", "standard" or "full"
+	Profile string `koanf:"profile"`
+	// Only write these fields, whatever the profile
+	Fields []string `koanf:"fields"`
+	// Names to store fields under instead of their own
+	FieldMap map[string]string `koanf:"field_map"`
+	// Print line protocol instead of writing to InfluxDB at all
+	Stdout bool `koanf:"stdout"`
+	UDP UDPConfig `koanf:"udp"`
+}
+
+type EventsConfig struct {
+	Enabled bool `koanf:"enabled"`
+	Measurement string `koanf:"measurement"`
+	RainThreshold float32 `koanf:"rain_threshold"`
+}
+
+type DailyConfig struct {
+	Enabled bool `koanf:"enabled"`
+	Measurement string `koanf:"measurement"`
+	// Fields to keep the daily minimum, maximum and average of
+	Fields []string `koanf:"fields"`
+}
+
+type DailyForecastConfig struct {
+	Enabled bool `koanf:"enabled"`
+	Measurement string `koanf:"measurement"`
+}
+
+type HourlyForecastConfig struct {
+	Enabled bool `koanf:"enabled"`
+	Measurement string `koanf:"measurement"`
+	// Only every this many hours of the forecast are written
+	Every int `koanf:"every"`
+	// Hours of forecast written, over One Call's 48 needs the pro plan
+	Hours int `koanf:"hours"`
+}
+
+// fromPro tells whether the hourly forecast is longer than One Call's and
+// comes from the pro plan's 4 day forecast instead
+func (cfg HourlyForecastConfig) fromPro() bool {
+	return cfg.Enabled && cfg.Hours > 48
+}
+
+type ForecastConfig struct {
+	// How often each location's forecast is fetched, 0 for every cycle
+	Interval time.Duration `koanf:"interval"`
+	Daily DailyForecastConfig `koanf:"daily"`
+	Hourly HourlyForecastConfig `koanf:"hourly"`
+}
+
+func (cfg ForecastConfig) enabled() bool {
+	return cfg.Daily.Enabled || cfg.Hourly.Enabled
+}
+
+type ValidationConfig struct {
+	Enabled bool `koanf:"enabled"`
+	TemperatureMin float64 `koanf:"temperature_min"`
+	TemperatureMax float64 `koanf:"temperature_max"`
+	HumidityMin float64 `koanf:"humidity_min"`
+	HumidityMax float64 `koanf:"humidity_max"`
+	// What to do with temperatures that look like Kelvin when they shouldn't
+	Kelvin string `koanf:"kelvin"`
+}
+
+type WatchdogConfig struct {
+	// Longest a cycle can go unfinished, 0 disables the watchdog
+	TimeoutSeconds int `koanf:"timeout_seconds"`
+	Exit bool `koanf:"exit"`
+}
+
+type HeartbeatConfig struct {
+	Enabled bool `koanf:"enabled"`
+	Measurement string `koanf:"measurement"`
+	// Tag telling sensors apart, the hostname when empty
+	Instance string `koanf:"instance"`
+}
+
+type PipelineConfig struct {
+	// Batches of points queued for the writer, 0 to write inline
+	BufferSize int `koanf:"buffer_size"`
+	WhenFull string `koanf:"when_full"`
+	// Batches written at once, each sink still only takes its max_in_flight
+	Workers int `koanf:"workers"`
+	// Write the points of every location in a cycle together
+	Coalesce bool `koanf:"coalesce"`
+}
+
+type StalenessConfig struct {
+	// Readings observed longer ago than this are stale, 0 never considers them so
+	ThresholdSeconds int `koanf:"threshold_seconds"`
+	Skip bool `koanf:"skip"`
+}
+
+type ShutdownConfig struct {
+	TimeoutSeconds int `koanf:"timeout_seconds"`
+}
+
+type LogConfig struct {
+	// Repeats of a message within this long are only counted, 0 logs them all
+	ThrottleWindow time.Duration `koanf:"throttle_window"`
+}
+
+type AuthConfig struct {
+	// Bearer token, or basic auth credentials, or both
+	Token string `koanf:"token"`
+	Username string `koanf:"username"`
+	Password string `koanf:"password"`
+}
+
+type DebugConfig struct {
+	// Log of every point written and how that went, off when empty
+	WALPath string `koanf:"wal_path"`
+	WALMaxBytes int64 `koanf:"wal_max_bytes"`
+}
+
+type MetricsConfig struct {
+	Listen string `koanf:"listen"`
+	// Readings older than this are no longer served, 0 serves them forever
+	ReadingTTL time.Duration `koanf:"reading_ttl"`
+	Auth AuthConfig `koanf:"auth"`
+}
+
+type ChangeFilterConfig struct {
+	Enabled bool `koanf:"enabled"`
+	// Smallest change in each watched field worth writing
+	Deltas map[string]float64 `koanf:"deltas"`
+	// Points skipped in a row before one is written regardless
+	MaxSkips int `koanf:"max_skips"`
+}
+
+type StatsDConfig struct {
+	// UDP address of the StatsD server, off when empty
+	Address string `koanf:"address"`
+	Prefix string `koanf:"prefix"`
+	Tags map[string]string `koanf:"tags"`
+}
+
+type OTelConfig struct {
+	Endpoint string `koanf:"endpoint"`
+	Insecure bool `koanf:"insecure"`
+	ServiceName string `koanf:"service_name"`
+}
+
+type DerivedConfig struct {
+	WindSpeedEMA bool `koanf:"wind_speed_ema"`
+	EMAAlpha float64 `koanf:"ema_alpha"`
+	ApparentTemperature string `koanf:"apparent_temperature"`
+	Tags []string `koanf:"tags"`
+	AllUnits bool `koanf:"all_units"`
+	WindBearingRadians bool `koanf:"wind_bearing_radians"`
+	// Units to also store the pressure in, next to hPa
+	PressureUnits []string `koanf:"pressure_units"`
+}
+
+type Config struct {
+	Sensor SensorConfig `koanf:"sensor"`
+	WeatherAPI WeatherAPIConfig `koanf:"weather_api"`
+	InfluxDB InfluxDBConfig `koanf:"influxdb"`
+	Events EventsConfig `koanf:"events"`
+	Daily DailyConfig `koanf:"daily"`
+	Forecast ForecastConfig `koanf:"forecast"`
+	Validation ValidationConfig `koanf:"validation"`
+	Staleness StalenessConfig `koanf:"staleness"`
+	Shutdown ShutdownConfig `koanf:"shutdown"`
+	Pipeline PipelineConfig `koanf:"pipeline"`
+	Watchdog WatchdogConfig `koanf:"watchdog"`
+	Heartbeat HeartbeatConfig `koanf:"heartbeat"`
+	Metrics MetricsConfig `koanf:"metrics"`
+	Log LogConfig `koanf:"log"`
+	Debug DebugConfig `koanf:"debug"`
+	Derived DerivedConfig `koanf:"derived"`
+	OTel OTelConfig `koanf:"otel"`
+	StatsD StatsDConfig `koanf:"statsd"`
+	ChangeFilter ChangeFilterConfig `koanf:"change_filter"`
+	Kafka KafkaConfig `koanf:"kafka"`
+
+	// Every location to fetch, gathered from all the location settings
+	Locations []Location `koanf:"-"`
+}
+
+var defaults = map[string]interface{}{
+	"events.measurement": "weather_events",
+	"events.rain_threshold": 0.0,
+	"daily.measurement": "weather_daily",
+	"daily.fields": []string{"temperature", "humidity", "pressure", "wind_speed"},
+	"forecast.daily.measurement": "weather_forecast_daily",
+	"forecast.hourly.measurement": "weather_forecast_hourly",
+	"forecast.hourly.every": 1,
+	"forecast.hourly.hours": 48,
+	"weather_api.max_body_bytes": 4 << 20,
+	"weather_api.plan": "free",
+	"weather_api.keyring.user": "appid",
+	"weather_api.provider": "openweathermap",
+	"weather_api.timeout": 30,
+	"weather_api.fallback_after": 3,
+	"weather_api.max_idle_conns": 100,
+	"weather_api.max_redirects": 3,
+	"weather_api.idle_conn_timeout": 90,
+	"shutdown.timeout_seconds": 10,
+	"debug.wal_max_bytes": 10 << 20,
+	"pipeline.when_full": "block",
+	"pipeline.workers": 1,
+	"sensor.error_policy": "continue",
+	"sensor.min_interval": 60,
+	"sensor.cycle_retry_delay": "30s",
+	"forecast.interval": "3h",
+	"influxdb.profile": "standard",
+	"heartbeat.measurement": "sensor_heartbeat",
+	"influxdb.startup_retries": 10,
+	"derived.ema_alpha": 0.3,
+	"otel.service_name": "weather-sensor",
+	"statsd.prefix": "weather_sensor.",
+	"change_filter.max_skips": 12,
+	"validation.temperature_min": -90.0,
+	"validation.temperature_max": 60.0,
+	"validation.humidity_min": 0.0,
+	"validation.humidity_max": 100.0,
+	"validation.kelvin": "warn",
+}
+
+// loadConfig reads the config file on top of the defaults into a Config.
+// Unknown keys are an error, so typos don't go unnoticed.
+func loadConfig(path string) (*Config, error) {
+	var cfg Config
+
+	k := koanf.New(".")
+	if err := k.Load(confmap.Provider(defaults, "."), nil); err != nil {
+		return nil, err
+	}
+
+	if err := k.Load(file.Provider(path), toml.Parser()); err != nil {
+		return nil, err
+	}
+
+	err := k.UnmarshalWithConf("", &cfg, koanf.UnmarshalConf{
+		DecoderConfig: &mapstructure.DecoderConfig{
+			DecodeHook: mapstructure.StringToTimeDurationHookFunc(),
+			ErrorUnused: true,
+			WeaklyTypedInput: true,
+			Result: &cfg,
+		},
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if kr := cfg.WeatherAPI.Keyring; kr.Service != "" {
+		// Without a keyring to ask, e.g. on a headless box, carry on with
+		// whatever key the file has
+		if appid, err := keyring.Get(kr.Service, kr.User); err != nil {
+			log.Printf("Error reading the API key from the keyring, using the one in %s: %v", path, err)
+		} else {
+			cfg.WeatherAPI.AppID = appid
+		}
+	}
+
+	// An interval that makes no sense is an error rather than raised to the
+	// floor like one that's merely too short
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	// A typo in the interval shouldn't burn through the API quota
+	if floor := cfg.Sensor.MinInterval; floor > 0 && cfg.Sensor.Interval < floor {
+		log.Printf("WARNING: sensor.interval of %d seconds is below sensor.min_interval, using %d", cfg.Sensor.Interval, floor)
+		cfg.Sensor.Interval = floor
+	}
+
+	cfg.applyProfile()
+
+	cfg.Locations, err = loadLocations(cfg.WeatherAPI)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if floor := time.Duration(cfg.Sensor.MinInterval) * time.Second; floor > 0 {
+		for i, location := range cfg.Locations {
+			if location.Interval > 0 && location.Interval < floor {
+				log.Printf("WARNING: Interval of %v for location '%s' is below sensor.min_interval, using %v", location.Interval, location.Tag(), floor)
+				cfg.Locations[i].Interval = floor
+			}
+		}
+	}
+
+	// Open-Meteo has no geocoding of its own, even as the fallback every
+	// location has to be fetchable from it
+	if cfg.WeatherAPI.Provider == "open-meteo" || cfg.WeatherAPI.Fallback == "open-meteo" {
+		for _, location := range cfg.Locations {
+			if !location.HasCoordinates && location.Zip == "" {
+				return nil, fmt.Errorf("Location '%s' needs coordinates to be fetched from Open-Meteo", location.Tag())
+			}
+
+			if location.Nearby > 0 && cfg.WeatherAPI.Provider == "open-meteo" {
+				return nil, fmt.Errorf("Location '%s' can't list nearby stations from Open-Meteo", location.Tag())
+			}
+		}
+	}
+
+	// One Call only takes coordinates
+	if cfg.Forecast.enabled() {
+		if cfg.WeatherAPI.Provider != "openweathermap" {
+			return nil, fmt.Errorf("Forecasts can only be fetched from OpenWeatherMap")
+		}
+
+		for _, location := range cfg.Locations {
+			if !location.HasCoordinates && location.Zip == "" {
+				return nil, fmt.Errorf("Location '%s' needs coordinates to fetch its forecast", location.Tag())
+			}
+		}
+	}
+
+	return &cfg, nil
+}
+
+func (cfg *Config) validate() error {
+	if _, ok := apiHosts[cfg.WeatherAPI.Plan]; !ok {
+		return fmt.Errorf("Unknown weather_api.plan '%s'", cfg.WeatherAPI.Plan)
+	}
+
+	if cfg.Sensor.Interval <= 0 {
+		return fmt.Errorf("Invalid sensor.interval %d", cfg.Sensor.Interval)
+	}
+
+	if cfg.Sensor.MinInterval < 0 {
+		return fmt.Errorf("Invalid sensor.min_interval %d", cfg.Sensor.MinInterval)
+	}
+
+	if p := cfg.InfluxDB.Profile; p != "minimal" && p != "standard" && p != "full" {
+		return fmt.Errorf("Unknown influxdb.profile '%s'", p)
+	}
+
+	if cfg.Sensor.CycleRetries < 0 || cfg.Sensor.CycleRetries > 0 && cfg.Sensor.CycleRetryDelay < time.Second {
+		return fmt.Errorf("Invalid sensor.cycle_retries %d with a sensor.cycle_retry_delay of %v", cfg.Sensor.CycleRetries, cfg.Sensor.CycleRetryDelay)
+	}
+
+	if policy := cfg.Sensor.ErrorPolicy; policy != "continue" && policy != "abort" {
+		return fmt.Errorf("Unknown sensor.error_policy '%s'", policy)
+	}
+
+	if a := cfg.Derived.EMAAlpha; a <= 0 || a > 1 {
+		return fmt.Errorf("Invalid derived.ema_alpha %v, it must be above 0 and at most 1", a)
+	}
+
+	if at := cfg.Derived.ApparentTemperature; at != "" && at != "australian" && at != "nws" {
+		return fmt.Errorf("Unknown derived.apparent_temperature '%s'", at)
+	}
+
+	if k := cfg.Validation.Kelvin; k != "off" && k != "warn" && k != "correct" {
+		return fmt.Errorf("Unknown validation.kelvin '%s'", k)
+	}
+
+	for _, tag := range cfg.Derived.Tags {
+		if _, ok := derivedTags[tag]; !ok {
+			return fmt.Errorf("Unknown derived.tags entry '%s'", tag)
+		}
+	}
+
+	for _, unit := range cfg.Derived.PressureUnits {
+		if _, ok := hPaIn[unit]; !ok {
+			return fmt.Errorf("Unknown derived.pressure_units entry '%s'", unit)
+		}
+	}
+
+	for endpoint, seconds := range cfg.WeatherAPI.Timeouts {
+		if _, ok := endpoints[endpoint]; !ok || seconds <= 0 {
+			return fmt.Errorf("Invalid weather_api.timeouts entry '%s'", endpoint)
+		}
+	}
+
+	if err := checkMeasurement(cfg.InfluxDB.Measurement); err != nil {
+		return err
+	}
+
+	if cfg.Forecast.Interval < 0 {
+		return fmt.Errorf("Invalid forecast.interval '%v'", cfg.Forecast.Interval)
+	}
+
+	if cfg.Forecast.Hourly.Every < 1 {
+		return fmt.Errorf("Invalid forecast.hourly.every %d", cfg.Forecast.Hourly.Every)
+	}
+
+	if h := cfg.Forecast.Hourly.Hours; h < 1 || h > 96 {
+		return fmt.Errorf("Invalid forecast.hourly.hours %d", h)
+	}
+
+	// Features only paid plans have
+	if cfg.Forecast.Hourly.fromPro() && cfg.WeatherAPI.Plan != "pro" {
+		return fmt.Errorf("forecast.hourly.hours %d needs weather_api.plan \"pro\", the free plan forecasts 48 hours", cfg.Forecast.Hourly.Hours)
+	}
+
+	// Without any watched field only every max_skips+1th reading would be
+	// written
+	if cfg.ChangeFilter.Enabled && len(cfg.ChangeFilter.Deltas) == 0 {
+		return fmt.Errorf("change_filter needs deltas for the fields to watch")
+	}
+
+	if cfg.ChangeFilter.MaxSkips < 0 {
+		return fmt.Errorf("Invalid change_filter.max_skips %d", cfg.ChangeFilter.MaxSkips)
+	}
+
+	if k := cfg.Kafka; k.Enabled && (len(k.Brokers) == 0 || k.Topic == "") {
+		return fmt.Errorf("kafka needs brokers and a topic")
+	}
+
+	if cfg.WeatherAPI.MaxRedirects < 0 {
+		return fmt.Errorf("Invalid weather_api.max_redirects %d", cfg.WeatherAPI.MaxRedirects)
+	}
+
+	if cfg.WeatherAPI.Timeout <= 0 {
+		return fmt.Errorf("Invalid weather_api.timeout %d", cfg.WeatherAPI.Timeout)
+	}
+
+	if _, ok := providers[cfg.WeatherAPI.Provider]; !ok {
+		return fmt.Errorf("Unknown weather_api.provider '%s'", cfg.WeatherAPI.Provider)
+	}
+
+	if fb := cfg.WeatherAPI.Fallback; fb != "" {
+		if _, ok := providers[fb]; !ok || fb == cfg.WeatherAPI.Provider {
+			return fmt.Errorf("Invalid weather_api.fallback '%s'", fb)
+		}
+	}
+
+	// Checked against the interval it's raised to, if it is
+	interval := cfg.Sensor.Interval
+
+	if interval < cfg.Sensor.MinInterval {
+		interval = cfg.Sensor.MinInterval
+	}
+
+	if wd := cfg.Watchdog.TimeoutSeconds; wd < 0 || (wd > 0 && wd <= interval) {
+		return fmt.Errorf("Invalid watchdog.timeout_seconds %d, it must be longer than the interval", wd)
+	}
+
+	if cfg.Pipeline.BufferSize < 0 {
+		return fmt.Errorf("Invalid pipeline.buffer_size %d", cfg.Pipeline.BufferSize)
+	}
+
+	if wf := cfg.Pipeline.WhenFull; wf != "block" && wf != "drop_oldest" {
+		return fmt.Errorf("Unknown pipeline.when_full '%s'", wf)
+	}
+
+	if cfg.Pipeline.Workers < 1 {
+		return fmt.Errorf("Invalid pipeline.workers %d", cfg.Pipeline.Workers)
+	}
+
+	if p := cfg.InfluxDB.FloatPrecision; p != nil && *p < 0 {
+		return fmt.Errorf("Invalid influxdb.float_precision %d", *p)
+	}
+
+	if cfg.InfluxDB.MaxInFlight < 0 {
+		return fmt.Errorf("Invalid influxdb.max_in_flight %d", cfg.InfluxDB.MaxInFlight)
+	}
+
+	for _, instance := range cfg.InfluxDB.Instances {
+		if instance.MaxInFlight < 0 {
+			return fmt.Errorf("Invalid max_in_flight %d for InfluxDB at %s", instance.MaxInFlight, instance.Hostname)
+		}
+	}
+
+	if auth := cfg.Metrics.Auth; auth.Password != "" && auth.Username == "" {
+		return fmt.Errorf("metrics.auth.password is set without metrics.auth.username")
+	}
+
+	if cfg.Debug.WALPath != "" && cfg.Debug.WALMaxBytes <= 0 {
+		return fmt.Errorf("Invalid debug.wal_max_bytes %d", cfg.Debug.WALMaxBytes)
+	}
+
+	if cfg.Log.ThrottleWindow < 0 {
+		return fmt.Errorf("Invalid log.throttle_window '%v'", cfg.Log.ThrottleWindow)
+	}
+
+	if cfg.InfluxDB.RoundTime < 0 {
+		return fmt.Errorf("Invalid influxdb.round_time '%v'", cfg.InfluxDB.RoundTime)
+	}
+
+	return nil
+}
+
+// Keys whose values are redacted when the config is shown
+var secrets = map[string]bool{
+	"weather_api.appid": true,
+	"weather_api.headers": true,
+	"influxdb.token": true,
+	"influxdb.instances.token": true,
+	"metrics.auth.token": true,
+	"metrics.auth.password": true,
+}
+
+// configMap turns a config struct into nested maps keyed like the config
+// file, redacting secrets and leaving out unset optional values
+func configMap(v reflect.Value, prefix string) interface{} {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+
+		return configMap(v.Elem(), prefix)
+	case reflect.Struct:
+		m := map[string]interface{}{}
+
+		for i := 0; i < v.NumField(); i++ {
+			key := v.Type().Field(i).Tag.Get("koanf")
+
+			if key == "" || key == "-" {
+				continue
+			}
+
+			path := strings.TrimPrefix(prefix + "." + key, ".")
+
+			if value := configMap(v.Field(i), path); value != nil {
+				m[key] = value
+			}
+		}
+
+		return m
+	case reflect.Slice:
+		var s []interface{}
+
+		for i := 0; i < v.Len(); i++ {
+			s = append(s, configMap(v.Index(i), prefix))
+		}
+
+		if len(s) == 0 {
+			return nil
+		}
+
+		return s
+	}
+
+	if d, ok := v.Interface().(time.Duration); ok {
+		return d.String()
+	}
+
+	if secrets[prefix] && !v.IsZero() {
+		return "REDACTED"
+	}
+
+	return v.Interface()
+}
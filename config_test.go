@@ -0,0 +1,278 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zalando/go-keyring"
+)
+
+// loadTestConfig loads a config file with exactly the given contents
+func loadTestConfig(t *testing.T, contents string) (*Config, error) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.toml")
+
+	if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return loadConfig(path)
+}
+
+func TestLoadConfigOpenMeteo(t *testing.T) {
+	const named = "[[weather_api.location]]\nname = \"Lisbon\"\n"
+	const located = "[[weather_api.location]]\nname = \"Lisbon\"\nlatitude = 38.7\nlongitude = -9.1\n"
+	const nearby = "[[weather_api.location]]\nname = \"Lisbon\"\nlatitude = 38.7\nlongitude = -9.1\nnearby = 3\n"
+
+	tests := []struct {
+		name string
+		weatherAPI string
+		locations string
+		wantErr string
+	}{
+		{"provider with coordinates", "provider = \"open-meteo\"", located, ""},
+		{"provider without coordinates", "provider = \"open-meteo\"", named, "needs coordinates"},
+		{"provider with nearby stations", "provider = \"open-meteo\"", nearby, "nearby stations"},
+		{"fallback with coordinates", "fallback = \"open-meteo\"", located, ""},
+		{"fallback without coordinates", "fallback = \"open-meteo\"", named, "needs coordinates"},
+		{"fallback with nearby stations", "fallback = \"open-meteo\"", nearby, ""},
+		{"no Open-Meteo", "", named, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := loadTestConfig(t, "[sensor]\ninterval = 300\n[influxdb]\nmeasurement = \"weather\"\n[weather_api]\nappid = \"test\"\n" + test.weatherAPI + "\n" + test.locations)
+
+			if test.wantErr == "" && err != nil || test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Errorf("loadConfig returned %v, want an error with '%s'", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadConfigInterval(t *testing.T) {
+	tests := []struct {
+		name string
+		sensor string
+		wantErr string
+		wantInterval int
+	}{
+		{"above the floor", "interval = 300", "", 300},
+		{"below the floor", "interval = 10", "", 60},
+		{"no floor", "interval = 10\nmin_interval = 0", "", 10},
+		{"zero", "interval = 0", "Invalid sensor.interval", 0},
+		{"negative", "interval = -300", "Invalid sensor.interval", 0},
+		{"negative floor", "interval = 300\nmin_interval = -1", "Invalid sensor.min_interval", 0},
+		{"watchdog shorter than the raised interval", "interval = 10\n[watchdog]\ntimeout_seconds = 30", "watchdog.timeout_seconds", 0},
+		{"unknown error policy", "interval = 300\nerror_policy = \"retry\"", "Unknown sensor.error_policy", 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, err := loadTestConfig(t, "[influxdb]\nmeasurement = \"weather\"\n[weather_api]\nappid = \"test\"\nlocations = [ \"Lisbon\" ]\n[sensor]\n" + test.sensor + "\n")
+
+			if test.wantErr == "" && err != nil || test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Fatalf("loadConfig returned %v, want an error with '%s'", err, test.wantErr)
+			}
+
+			if err == nil && cfg.Sensor.Interval != test.wantInterval {
+				t.Errorf("sensor.interval is %d, want %d", cfg.Sensor.Interval, test.wantInterval)
+			}
+		})
+	}
+}
+
+func TestLoadConfigKeyring(t *testing.T) {
+	keyring.MockInit()
+
+	if err := keyring.Set("weather-sensor", "appid", "from-keyring"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		keyring string
+		want string
+	}{
+		{"no keyring", "", "from-file"},
+		{"key in the keyring", "[weather_api.keyring]\nservice = \"weather-sensor\"\n", "from-keyring"},
+		{"other user", "[weather_api.keyring]\nservice = \"weather-sensor\"\nuser = \"someone\"\n", "from-file"},
+		{"no such service", "[weather_api.keyring]\nservice = \"other\"\n", "from-file"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, err := loadTestConfig(t, "[sensor]\ninterval = 300\n[influxdb]\nmeasurement = \"weather\"\n[weather_api]\nappid = \"from-file\"\nlocations = [ \"Lisbon\" ]\n" + test.keyring)
+
+			if err != nil {
+				t.Fatalf("loadConfig returned %v", err)
+			}
+
+			if cfg.WeatherAPI.AppID != test.want {
+				t.Errorf("API key is '%s', want '%s'", cfg.WeatherAPI.AppID, test.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigEMAAlpha(t *testing.T) {
+	tests := []struct {
+		alpha string
+		wantErr bool
+	}{
+		{"0.3", false},
+		{"1.0", false},
+		{"0.0", true},
+		{"-0.3", true},
+		{"1.5", true},
+	}
+
+	for _, test := range tests {
+		_, err := loadTestConfig(t, "[sensor]\ninterval = 300\n[influxdb]\nmeasurement = \"weather\"\n[weather_api]\nappid = \"test\"\nlocations = [ \"Lisbon\" ]\n[derived]\nema_alpha = " + test.alpha + "\n")
+
+		if (err != nil) != test.wantErr {
+			t.Errorf("derived.ema_alpha %s: loadConfig returned %v, want an error: %v", test.alpha, err, test.wantErr)
+		}
+	}
+}
+
+func TestLoadConfigPlan(t *testing.T) {
+	tests := []struct {
+		name string
+		plan string
+		forecast string
+		wantErr string
+	}{
+		{"free", "free", "", ""},
+		{"pro", "pro", "", ""},
+		{"unknown plan", "enterprise", "", "Unknown weather_api.plan"},
+		{"free plan hourly forecast", "free", "[forecast.hourly]\nenabled = true\nhours = 48\n", ""},
+		{"free plan 4 day hourly forecast", "free", "[forecast.hourly]\nenabled = true\nhours = 96\n", "needs weather_api.plan \"pro\""},
+		{"free plan 4 day hourly forecast disabled", "free", "[forecast.hourly]\nhours = 96\n", ""},
+		{"pro plan 4 day hourly forecast", "pro", "[forecast.hourly]\nenabled = true\nhours = 96\n", ""},
+		{"too long a forecast", "pro", "[forecast.hourly]\nenabled = true\nhours = 120\n", "Invalid forecast.hourly.hours"},
+		{"no forecast hours", "free", "[forecast.hourly]\nenabled = true\nhours = 0\n", "Invalid forecast.hourly.hours"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := loadTestConfig(t, "[sensor]\ninterval = 300\n[influxdb]\nmeasurement = \"weather\"\n[weather_api]\nappid = \"test\"\nplan = \"" + test.plan + "\"\n[[weather_api.location]]\nname = \"Lisbon\"\nlatitude = 38.7\nlongitude = -9.1\n" + test.forecast)
+
+			if test.wantErr == "" && err != nil || test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Errorf("loadConfig returned %v, want an error with '%s'", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadConfigKafka(t *testing.T) {
+	tests := []struct {
+		kafka string
+		wantErr bool
+	}{
+		{"enabled = true\nbrokers = [ \"kafka:9092\" ]\ntopic = \"weather\"\n", false},
+		{"enabled = true\ntopic = \"weather\"\n", true},
+		{"enabled = true\nbrokers = [ \"kafka:9092\" ]\n", true},
+		{"enabled = false\n", false},
+	}
+
+	for _, test := range tests {
+		_, err := loadTestConfig(t, "[sensor]\ninterval = 300\n[influxdb]\nmeasurement = \"weather\"\n[weather_api]\nappid = \"test\"\nlocations = [ \"Lisbon\" ]\n[kafka]\n" + test.kafka)
+
+		if (err != nil) != test.wantErr {
+			t.Errorf("[kafka] %q: loadConfig returned %v, want an error: %v", test.kafka, err, test.wantErr)
+		}
+	}
+}
+
+func TestLoadConfigExample(t *testing.T) {
+	cfg, err := loadConfig("config.toml.example")
+
+	if err != nil {
+		t.Fatalf("loadConfig returned %v", err)
+	}
+
+	tests := []struct {
+		key string
+		got interface{}
+		want interface{}
+	}{
+		{"sensor.interval", cfg.Sensor.Interval, 300},
+		{"sensor.cycle_retry_delay", cfg.Sensor.CycleRetryDelay, 30 * time.Second},
+		{"sensor.error_policy", cfg.Sensor.ErrorPolicy, "continue"},
+		{"weather_api.units", cfg.WeatherAPI.Units, "metric"},
+		{"weather_api.max_body_bytes", cfg.WeatherAPI.MaxBodyBytes, int64(4194304)},
+		{"weather_api.locations", len(cfg.Locations), 3},
+		{"influxdb.hostname", cfg.InfluxDB.Hostname, "http://influx:8086/"},
+		{"influxdb.bucket", cfg.InfluxDB.Bucket, "default"},
+		{"kafka.brokers", strings.Join(cfg.Kafka.Brokers, ","), "kafka:9092"},
+		{"daily.fields", strings.Join(cfg.Daily.Fields, ","), "temperature,humidity,pressure,wind_speed"},
+		{"forecast.interval", cfg.Forecast.Interval, 3 * time.Hour},
+		{"forecast.hourly.hours", cfg.Forecast.Hourly.Hours, 48},
+		{"validation.temperature_max", cfg.Validation.TemperatureMax, 60.0},
+	}
+
+	for _, test := range tests {
+		if test.got != test.want {
+			t.Errorf("%s is %v, want %v", test.key, test.got, test.want)
+		}
+	}
+}
+
+func TestLoadConfigUnknownKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		config string
+	}{
+		{"misspelt key", "[sensor]\nintervall = 300\n"},
+		{"misspelt section", "[sensors]\ninterval = 300\n"},
+		{"key in the wrong section", "[sensor]\nbucket = \"default\"\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// A typo fails loudly rather than leaving the setting at its default
+			_, err := loadTestConfig(t, "[influxdb]\nmeasurement = \"weather\"\n[weather_api]\nappid = \"test\"\nlocations = [ \"Lisbon\" ]\n" + test.config)
+
+			if err == nil || !strings.Contains(err.Error(), "invalid keys") {
+				t.Errorf("loadConfig returned %v, want an error about invalid keys", err)
+			}
+		})
+	}
+}
+
+func TestWeatherAPITimeout(t *testing.T) {
+	tests := []struct {
+		name string
+		timeouts string
+		endpoint string
+		want time.Duration
+		wantErr string
+	}{
+		{"default", "", "current", 30 * time.Second, ""},
+		{"endpoint of its own", "find = 60\n", "find", 60 * time.Second, ""},
+		{"other endpoint", "find = 60\n", "onecall", 30 * time.Second, ""},
+		{"unknown endpoint", "weather = 60\n", "", 0, "Invalid weather_api.timeouts entry 'weather'"},
+		{"zero", "find = 0\n", "", 0, "Invalid weather_api.timeouts entry 'find'"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, err := loadTestConfig(t, "[sensor]\ninterval = 300\n[influxdb]\nmeasurement = \"weather\"\n[weather_api]\nappid = \"test\"\nlocations = [ \"Lisbon\" ]\n[weather_api.timeouts]\n" + test.timeouts)
+
+			if test.wantErr == "" && err != nil || test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Fatalf("loadConfig returned %v, want an error with '%s'", err, test.wantErr)
+			}
+
+			if err == nil {
+				if got := cfg.WeatherAPI.timeout(test.endpoint); got != test.want {
+					t.Errorf("Requests to %s time out after %v, want %v", test.endpoint, got, test.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+// Running aggregates of a location's fields over one local day
+type aggregate struct {
+	day time.Time
+	min map[string]float64
+	max map[string]float64
+	sum map[string]float64
+	count map[string]int
+}
+
+// Aggregates of the current day per location. They only live in memory, so
+// a restart mid-day loses what was gathered until then.
+var days = map[string]*aggregate{}
+
+func newAggregate(day time.Time) *aggregate {
+	return &aggregate{
+		day: day,
+		min: map[string]float64{},
+		max: map[string]float64{},
+		sum: map[string]float64{},
+		count: map[string]int{},
+	}
+}
+
+// midnight is the start of the local day a time falls on
+func midnight(local time.Time) time.Time {
+	year, month, day := local.Date()
+
+	return time.Date(year, month, day, 0, 0, 0, 0, local.Location())
+}
+
+// dailySummary folds a reading's fields into its location's aggregate. The
+// first reading of a new local day closes the previous one, whose summary
+// point is returned, stamped with its midnight.
+func dailySummary(cfg DailyConfig, location string, p *write.Point, local time.Time) *write.Point {
+	var summary *write.Point
+
+	day := midnight(local)
+	a, ok := days[location]
+
+	if ok && !a.day.Equal(day) && len(a.count) > 0 {
+		summary = a.point(cfg.Measurement, location)
+	}
+
+	if !ok || !a.day.Equal(day) {
+		a = newAggregate(day)
+		days[location] = a
+	}
+
+	for _, f := range p.FieldList() {
+		v, ok := numericValue(f.Value)
+
+		if !ok || !contains(cfg.Fields, f.Key) {
+			continue
+		}
+
+		if a.count[f.Key] == 0 || v < a.min[f.Key] {
+			a.min[f.Key] = v
+		}
+
+		if a.count[f.Key] == 0 || v > a.max[f.Key] {
+			a.max[f.Key] = v
+		}
+
+		a.sum[f.Key] += v
+		a.count[f.Key]++
+	}
+
+	return summary
+}
+
+func (a *aggregate) point(measurement string, location string) *write.Point {
+	p := influxdb2.NewPointWithMeasurement(measurement).
+		AddTag("location", tagValue(location)).
+		SetTime(a.day)
+
+	for field, count := range a.count {
+		p.AddField(field + "_min", a.min[field]).
+			AddField(field + "_max", a.max[field]).
+			AddField(field + "_avg", a.sum[field] / float64(count))
+	}
+
+	p.SortFields()
+
+	return p
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+func TestDailySummary(t *testing.T) {
+	cfg := DailyConfig{Measurement: "weather_daily", Fields: []string{"temperature", "humidity"}}
+	lisbon := time.FixedZone("WEST", 3600)
+
+	readings := []struct {
+		at time.Time
+		temperature float64
+	}{
+		{time.Date(2022, 6, 1, 6, 0, 0, 0, lisbon), 14},
+		{time.Date(2022, 6, 1, 15, 0, 0, 0, lisbon), 26},
+		{time.Date(2022, 6, 1, 23, 30, 0, 0, lisbon), 17},
+		// Still June 1st in UTC, but the next day in Lisbon
+		{time.Date(2022, 6, 2, 0, 30, 0, 0, lisbon), 16},
+	}
+
+	var summaries []*write.Point
+
+	for _, r := range readings {
+		p := write.NewPointWithMeasurement("weather").
+			AddField("temperature", r.temperature).
+			AddField("humidity", int64(70)).
+			AddField("wind_speed", 3.5).
+			AddField("description", "clear sky")
+
+		if summary := dailySummary(cfg, "Lisbon daily", p, r.at); summary != nil {
+			summaries = append(summaries, summary)
+		}
+	}
+
+	if len(summaries) != 1 {
+		t.Fatalf("Got %d summaries, want 1 once the next day starts", len(summaries))
+	}
+
+	summary := summaries[0]
+
+	if want := time.Date(2022, 6, 1, 0, 0, 0, 0, lisbon); !summary.Time().Equal(want) {
+		t.Errorf("Summary is stamped %v, want the local midnight %v", summary.Time(), want)
+	}
+
+	want := map[string]interface{}{
+		"temperature_min": 14.0,
+		"temperature_max": 26.0,
+		"temperature_avg": 19.0,
+		"humidity_min": 70.0,
+		"humidity_max": 70.0,
+		"humidity_avg": 70.0,
+	}
+
+	if got := fieldKeys(summary); len(got) != len(want) {
+		t.Errorf("Summary has fields %v, want %d", got, len(want))
+	}
+
+	for key, value := range want {
+		if got, _ := fieldValue(summary, key); got != value {
+			t.Errorf("Summary has %s %v, want %v", key, got, value)
+		}
+	}
+}
+
+func TestDailySummaryNothingAggregated(t *testing.T) {
+	cfg := DailyConfig{Measurement: "weather_daily", Fields: []string{"temperature"}}
+	p := write.NewPointWithMeasurement("weather").AddField("humidity", int64(70))
+
+	dailySummary(cfg, "Lisbon empty", p, time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC))
+
+	// A day without any of the fields has nothing to summarise
+	if summary := dailySummary(cfg, "Lisbon empty", p, time.Date(2022, 6, 2, 12, 0, 0, 0, time.UTC)); summary != nil {
+		t.Errorf("Got a summary with fields %v for a day without any", fieldKeys(summary))
+	}
+}
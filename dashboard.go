@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// sampleFields lists the numeric fields readings are written with under
+// the current config, the way weatherPoints builds them
+func sampleFields(cfg *Config) []string {
+	sample := WeatherResponse{
+		Name: "sample",
+		Weather: []WeatherSpec{{Main: "Clear", Description: "clear sky", Icon: "01d"}},
+		Main: MainSpec{HasSeaLevel: true, HasGroundLevel: true},
+		Wind: WindSpec{HasGust: true},
+		Timestamp: int(clock.Now().Unix()),
+		HasVisibility: true,
+	}
+
+	p := weatherPoints(cfg, sample, "sample")[0]
+
+	if fields := cfg.InfluxDB.fields(); fields != nil {
+		p = keepFields(p, fields)
+	}
+
+	if len(cfg.InfluxDB.FieldMap) > 0 {
+		renameFields(p, cfg.InfluxDB.FieldMap)
+	}
+
+	var fields []string
+
+	for _, f := range p.FieldList() {
+		if _, ok := numericValue(f.Value); ok {
+			fields = append(fields, f.Key)
+		}
+	}
+
+	return fields
+}
+
+// measurementFilter is the Flux filter matching the configured measurement,
+// every measurement the template can fill in to if it is one
+func measurementFilter(cfg *Config) string {
+	template := cfg.InfluxDB.Measurement
+
+	if !strings.Contains(template, "{") {
+		return fmt.Sprintf("r._measurement == %q", template)
+	}
+
+	parts := measurementVar.Split(template, -1)
+
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+
+	return fmt.Sprintf("r._measurement =~ /^%s$/", strings.Join(parts, ".+"))
+}
+
+// dashboard writes a Grafana dashboard with a panel per field, querying the
+// configured bucket through a Flux data source and with a variable to pick
+// the locations shown
+func dashboard(cfg *Config) error {
+	fields := sampleFields(cfg)
+
+	var locations []string
+
+	for _, location := range cfg.Locations {
+		// Commas separate the values of custom variables
+		locations = append(locations, strings.ReplaceAll(location.Tag(), ",", `\,`))
+	}
+
+	var panels []interface{}
+
+	for i, field := range fields {
+		query := fmt.Sprintf(`from(bucket: %q)
+  |> range(start: v.timeRangeStart, stop: v.timeRangeStop)
+  |> filter(fn: (r) => %s and r._field == %q)
+  |> filter(fn: (r) => contains(value: r.location, set: ${location:json}))
+  |> aggregateWindow(every: v.windowPeriod, fn: mean, createEmpty: false)
+  |> keep(columns: ["_time", "_value", "location"])`, cfg.InfluxDB.Bucket, measurementFilter(cfg), field)
+
+		panels = append(panels, map[string]interface{}{
+			"id": i + 1,
+			"type": "timeseries",
+			"title": field,
+			"datasource": "${datasource}",
+			"gridPos": map[string]int{"x": i % 2 * 12, "y": i / 2 * 8, "w": 12, "h": 8},
+			"targets": []interface{}{
+				map[string]interface{}{"refId": "A", "query": query},
+			},
+		})
+	}
+
+	board := map[string]interface{}{
+		"title": "Weather",
+		"uid": "weather-sensor",
+		"schemaVersion": 30,
+		"time": map[string]string{"from": "now-24h", "to": "now"},
+		"templating": map[string]interface{}{
+			"list": []interface{}{
+				map[string]interface{}{
+					"name": "datasource",
+					"type": "datasource",
+					"query": "influxdb",
+				},
+				map[string]interface{}{
+					"name": "location",
+					"type": "custom",
+					"query": strings.Join(locations, ","),
+					"multi": true,
+					"includeAll": true,
+					"current": map[string]interface{}{"text": "All", "value": "$__all"},
+				},
+			},
+		},
+		"panels": panels,
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(board)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestMeasurementFilter(t *testing.T) {
+	tests := []struct {
+		measurement string
+		want string
+	}{
+		{"weather", `r._measurement == "weather"`},
+		{"weather_{country}", `r._measurement =~ /^weather_.+$/`},
+		{"{country}.{location}", `r._measurement =~ /^.+\..+$/`},
+	}
+
+	for _, test := range tests {
+		cfg := testConfig(t, "")
+		cfg.InfluxDB.Measurement = test.measurement
+
+		if got := measurementFilter(cfg); got != test.want {
+			t.Errorf("Filter for '%s' is %s, want %s", test.measurement, got, test.want)
+		}
+	}
+}
+
+func TestSampleFields(t *testing.T) {
+	tests := []struct {
+		name string
+		config string
+		want []string
+	}{
+		{"fields picked", "[influxdb]\nfields = [ \"temperature\", \"humidity\", \"condition\" ]\n", []string{"humidity", "temperature"}},
+		{"fields renamed", "[influxdb]\nfields = [ \"temperature\", \"humidity\" ]\n[influxdb.field_map]\ntemperature = \"temp_c\"\n", []string{"humidity", "temp_c"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useSelfAdvancingClock(t)
+
+			got := sampleFields(testConfig(t, test.config))
+			sort.Strings(got)
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Got fields %v, want %v", got, test.want)
+			}
+		})
+	}
+}
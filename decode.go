@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// decodeJSON decodes data into out like json.Unmarshal, except that values
+// of the wrong type, which the API sends now and then, don't throw away the
+// whole response. Numbers sent as strings are taken as numbers and the other
+// way around, numeric values that aren't numbers at all are left out.
+func decodeJSON(data []byte, out interface{}) error {
+	err := json.Unmarshal(data, out)
+
+	var typeErr *json.UnmarshalTypeError
+
+	if !errors.As(err, &typeErr) {
+		return err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var tree interface{}
+
+	if decoder.Decode(&tree) != nil {
+		return err
+	}
+
+	target := reflect.ValueOf(out).Elem()
+	tree, _ = coerce(tree, target.Type(), "")
+
+	fixed, merr := json.Marshal(tree)
+
+	if merr != nil {
+		return err
+	}
+
+	// Whatever the first attempt managed to decode is decoded again
+	target.Set(reflect.Zero(target.Type()))
+
+	return json.Unmarshal(fixed, out)
+}
+
+// coerce walks a decoded JSON value alongside the type it's meant for,
+// fixing values of the wrong type. It returns false for values to drop.
+func coerce(v interface{}, t reflect.Type, path string) (interface{}, bool) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for key, item := range value {
+			var itemType reflect.Type
+
+			switch t.Kind() {
+			case reflect.Struct:
+				itemType = jsonFieldType(t, key)
+			case reflect.Map:
+				itemType = t.Elem()
+			}
+
+			if itemType == nil {
+				continue
+			}
+
+			if fixed, ok := coerce(item, itemType, strings.TrimPrefix(path + "." + key, ".")); ok {
+				value[key] = fixed
+			} else {
+				delete(value, key)
+			}
+		}
+	case []interface{}:
+		if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+			for i, item := range value {
+				value[i], _ = coerce(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))
+			}
+		}
+	case string:
+		numeric := t.Kind() >= reflect.Int && t.Kind() <= reflect.Float64
+
+		// Precipitation can also be a bare number
+		if !numeric && t.Kind() != reflect.Struct {
+			break
+		}
+
+		if _, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+			log.Printf("Decoding %q of field '%s' as a number", value, path)
+			return json.Number(strings.TrimSpace(value)), true
+		}
+
+		if numeric {
+			log.Printf("Leaving out field '%s', %q isn't a number", path, value)
+			return nil, false
+		}
+	case json.Number:
+		if t.Kind() == reflect.String {
+			log.Printf("Decoding %s of field '%s' as a string", value, path)
+			return value.String(), true
+		}
+	}
+
+	return v, true
+}
+
+// jsonFieldType is the type of the struct field a JSON key decodes into, nil
+// if there is none
+func jsonFieldType(t reflect.Type, key string) reflect.Type {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+
+		if name == "-" || f.PkgPath != "" {
+			continue
+		}
+
+		if name == key || (name == "" && strings.EqualFold(f.Name, key)) {
+			return f.Type
+		}
+	}
+
+	return nil
+}
+
+// present tells which of the given keys a JSON object has, so absent values
+// can be told apart from zeros
+func present(data []byte, keys ...string) (map[string]bool, error) {
+	var fields map[string]json.RawMessage
+
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	found := map[string]bool{}
+
+	for _, key := range keys {
+		_, found[key] = fields[key]
+	}
+
+	return found, nil
+}
+
+func (m *MainSpec) UnmarshalJSON(data []byte) error {
+	type plain MainSpec
+
+	if err := json.Unmarshal(data, (*plain)(m)); err != nil {
+		return err
+	}
+
+	found, err := present(data, "sea_level", "grnd_level")
+
+	if err != nil {
+		return err
+	}
+
+	m.HasSeaLevel, m.HasGroundLevel = found["sea_level"], found["grnd_level"]
+
+	return nil
+}
+
+func (w *WindSpec) UnmarshalJSON(data []byte) error {
+	type plain WindSpec
+
+	if err := json.Unmarshal(data, (*plain)(w)); err != nil {
+		return err
+	}
+
+	found, err := present(data, "gust")
+
+	if err != nil {
+		return err
+	}
+
+	w.HasGust = found["gust"]
+
+	return nil
+}
+
+func (r *WeatherResponse) UnmarshalJSON(data []byte) error {
+	type plain WeatherResponse
+
+	if err := json.Unmarshal(data, (*plain)(r)); err != nil {
+		return err
+	}
+
+	found, err := present(data, "visibility")
+
+	if err != nil {
+		return err
+	}
+
+	r.HasVisibility = found["visibility"]
+
+	return nil
+}
+
+// precipitation decodes either the usual object with 1h and 3h amounts or a
+// bare number, taken as the last hour's
+func precipitation(data []byte) (lastHour float32, last3Hours float32, err error) {
+	var amount float32
+
+	if err := json.Unmarshal(data, &amount); err == nil {
+		return amount, 0, nil
+	}
+
+	var amounts struct {
+		LastHour float32 `json:"1h"`
+		Last3Hours float32 `json:"3h"`
+	}
+
+	err = json.Unmarshal(data, &amounts)
+
+	return amounts.LastHour, amounts.Last3Hours, err
+}
+
+func (r *RainSpec) UnmarshalJSON(data []byte) (err error) {
+	r.LastHour, r.Last3Hours, err = precipitation(data)
+	return err
+}
+
+func (s *SnowSpec) UnmarshalJSON(data []byte) (err error) {
+	s.LastHour, s.Last3Hours, err = precipitation(data)
+	return err
+}
+
+// Code is a response code, which some endpoints send as a number and others
+// as a string
+type Code int
+
+func (c *Code) UnmarshalJSON(data []byte) error {
+	var s string
+
+	if err := json.Unmarshal(data, &s); err != nil {
+		return json.Unmarshal(data, (*int)(c))
+	}
+
+	if s == "" {
+		*c = 0
+		return nil
+	}
+
+	n, err := strconv.Atoi(s)
+
+	if err != nil {
+		return fmt.Errorf("Invalid response code '%s'", s)
+	}
+
+	*c = Code(n)
+
+	return nil
+}
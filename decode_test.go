@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+)
+
+// decoded is the part of a response the decode tests look at
+type decoded struct {
+	temp float32
+	humidity float32
+	visibility int
+	hasVisibility bool
+	name string
+	rain float32
+	cod Code
+}
+
+func TestDecodeJSON(t *testing.T) {
+	valid := decoded{temp: 18.5, humidity: 70, visibility: 10000, hasVisibility: true, name: "Lisbon", cod: 200}
+
+	tests := []struct {
+		name string
+		json string
+		want decoded
+		wantErr bool
+	}{
+		{"well typed", `{"main": {"temp": 18.5, "humidity": 70}, "visibility": 10000, "name": "Lisbon", "cod": 200}`, valid, false},
+		{"number as a string", `{"main": {"temp": "18.5", "humidity": 70}, "visibility": "10000", "name": "Lisbon", "cod": 200}`, valid, false},
+		{"padded number as a string", `{"main": {"temp": " 18.5 ", "humidity": 70}, "visibility": 10000, "name": "Lisbon", "cod": 200}`, valid, false},
+		{"not a number", `{"main": {"temp": 18.5, "humidity": "n/a"}, "visibility": 10000, "name": "Lisbon", "cod": 200}`, decoded{temp: 18.5, visibility: 10000, hasVisibility: true, name: "Lisbon", cod: 200}, false},
+		{"visibility not a number", `{"main": {"temp": 18.5, "humidity": 70}, "visibility": "far", "name": "Lisbon", "cod": 200}`, decoded{temp: 18.5, humidity: 70, name: "Lisbon", cod: 200}, false},
+		{"string as a number", `{"main": {"temp": 18.5, "humidity": 70}, "visibility": 10000, "name": 2267057, "cod": 200}`, decoded{temp: 18.5, humidity: 70, visibility: 10000, hasVisibility: true, name: "2267057", cod: 200}, false},
+		{"code as a string", `{"main": {"temp": 18.5, "humidity": 70}, "visibility": 10000, "name": "Lisbon", "cod": "200"}`, valid, false},
+		{"rain object", `{"rain": {"1h": 0.5}}`, decoded{rain: 0.5}, false},
+		{"rain number", `{"rain": 0.5}`, decoded{rain: 0.5}, false},
+		{"rain number as a string", `{"rain": "0.5"}`, decoded{rain: 0.5}, false},
+		{"amount as a string", `{"rain": {"1h": "0.5"}}`, decoded{rain: 0.5}, false},
+		{"not JSON", `{"main": `, decoded{}, true},
+		{"not an object", `[1, 2]`, decoded{}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var weather WeatherResponse
+
+			err := decodeJSON([]byte(test.json), &weather)
+
+			if (err != nil) != test.wantErr {
+				t.Fatalf("decodeJSON returned %v, want an error: %v", err, test.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			got := decoded{weather.Main.Temp, weather.Main.Humidity, weather.Visibility, weather.HasVisibility, weather.Name, weather.Rain.LastHour, weather.Cod}
+
+			if got != test.want {
+				t.Errorf("Decoded %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDecodeJSONList(t *testing.T) {
+	var weather WeatherResponse
+
+	if err := decodeJSON([]byte(`{"weather": [{"id": "500", "main": "Rain"}, {"id": "drizzle", "main": "Drizzle"}]}`), &weather); err != nil {
+		t.Fatalf("decodeJSON returned %v", err)
+	}
+
+	if len(weather.Weather) != 2 || weather.Weather[0].Id != 500 || weather.Weather[1].Main != "Drizzle" {
+		t.Errorf("Decoded conditions %+v", weather.Weather)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"math"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+// Pressure units a hPa is converted to
+var hPaIn = map[string]float64{
+	"inhg": 0.0295299830714,
+	"mmhg": 0.750061683,
+}
+
+// Exponential moving averages per location and field. They only live in
+// memory, so they start over whenever the sensor restarts.
+var averages = map[string]map[string]float64{}
+
+// ema folds a new value into the moving average of a location's field and
+// returns the updated average. The first value seeds the average.
+func ema(location string, field string, value float64, alpha float64) float64 {
+	fields, ok := averages[location]
+
+	if !ok {
+		fields = map[string]float64{}
+		averages[location] = fields
+	}
+
+	average, ok := fields[field]
+
+	if !ok {
+		average = value
+	} else {
+		average = alpha * value + (1 - alpha) * average
+	}
+
+	fields[field] = average
+
+	return average
+}
+
+// toCelsius converts a temperature in the configured units into Celsius
+func toCelsius(t float64, units string) float64 {
+	switch units {
+	case "imperial":
+		return (t - 32) * 5 / 9
+	case "metric":
+		return t
+	default:
+		return t - 273.15
+	}
+}
+
+// toMetersPerSecond converts a wind speed in the configured units, which is
+// miles per hour for imperial and meters per second otherwise
+func toMetersPerSecond(speed float64, units string) float64 {
+	if units == "imperial" {
+		return speed * 0.44704
+	}
+
+	return speed
+}
+
+// australianApparentTemperature is the Bureau of Meteorology's apparent
+// temperature (Steadman, 1994) without the radiation term, from the air
+// temperature in Celsius, relative humidity in percent and wind speed in
+// meters per second
+func australianApparentTemperature(t float64, rh float64, ws float64) float64 {
+	// Water vapour pressure in hPa
+	e := rh / 100 * 6.105 * math.Exp(17.27 * t / (237.7 + t))
+
+	return t + 0.33 * e - 0.70 * ws - 4.00
+}
+
+// nwsApparentTemperature follows the US National Weather Service: the heat
+// index (Rothfusz regression with the NWS adjustments) from 80°F, the wind
+// chill at 50°F and below with winds of at least 3 mph, and the air
+// temperature in between. Takes and returns Fahrenheit, wind speed in mph.
+func nwsApparentTemperature(t float64, rh float64, ws float64) float64 {
+	if t <= 50 && ws >= 3 {
+		v := math.Pow(ws, 0.16)
+
+		return 35.74 + 0.6215 * t - 35.75 * v + 0.4275 * t * v
+	}
+
+	// The simple formula is used unless it averages out at 80°F or above
+	hi := 0.5 * (t + 61 + (t - 68) * 1.2 + rh * 0.094)
+
+	if (hi + t) / 2 < 80 {
+		if t < 80 {
+			return t
+		}
+
+		return hi
+	}
+
+	hi = -42.379 + 2.04901523 * t + 10.14333127 * rh - 0.22475541 * t * rh -
+		0.00683783 * t * t - 0.05481717 * rh * rh + 0.00122874 * t * t * rh +
+		0.00085282 * t * rh * rh - 0.00000199 * t * t * rh * rh
+
+	if rh < 13 && t >= 80 && t <= 112 {
+		hi -= (13 - rh) / 4 * math.Sqrt((17 - math.Abs(t - 95)) / 17)
+	} else if rh > 85 && t >= 80 && t <= 87 {
+		hi += (rh - 85) / 10 * (87 - t) / 5
+	}
+
+	return hi
+}
+
+// apparentTemperature computes the apparent temperature with the configured
+// formula, in the configured units
+func apparentTemperature(formula string, units string, weather WeatherResponse) float64 {
+	t := toCelsius(float64(weather.Main.Temp), units)
+	rh := float64(weather.Main.Humidity)
+	ws := toMetersPerSecond(float64(weather.Wind.Speed), units)
+
+	if formula == "nws" {
+		f := nwsApparentTemperature(t * 9 / 5 + 32, rh, ws / 0.44704)
+
+		return toUnits((f - 32) * 5 / 9, units)
+	}
+
+	return toUnits(australianApparentTemperature(t, rh, ws), units)
+}
+
+// roundFields rounds every float field of a point to the given number of
+// decimal places. Halves go to the even neighbour so rounding doesn't bias
+// the stored values upwards.
+func roundFields(p *write.Point, decimals int) {
+	scale := math.Pow(10, float64(decimals))
+
+	for _, f := range p.FieldList() {
+		if v, ok := f.Value.(float64); ok {
+			f.Value = math.RoundToEven(v * scale) / scale
+		}
+	}
+}
+
+// keepFields copies a point with only the fields in the list
+func keepFields(p *write.Point, fields []string) *write.Point {
+	kept := influxdb2.NewPointWithMeasurement(p.Name()).SetTime(p.Time())
+
+	for _, t := range p.TagList() {
+		kept.AddTag(t.Key, t.Value)
+	}
+
+	for _, f := range p.FieldList() {
+		if contains(fields, f.Key) {
+			kept.AddField(f.Key, f.Value)
+		}
+	}
+
+	return kept
+}
+
+// renameFields gives the fields of a point the names they are mapped to,
+// leaving unmapped ones as they are
+func renameFields(p *write.Point, names map[string]string) {
+	for _, f := range p.FieldList() {
+		if name, ok := names[f.Key]; ok {
+			f.Key = name
+		}
+	}
+
+	p.SortFields()
+}
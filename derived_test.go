@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+func TestNWSApparentTemperature(t *testing.T) {
+	tests := []struct {
+		name string
+		t, rh, ws float64
+		want float64
+	}{
+		// Values from the NWS heat index and wind chill charts
+		{"heat index", 90, 70, 5, 106},
+		{"simple heat index", 82, 40, 0, 81},
+		{"wind chill", 30, 50, 10, 21},
+		{"cold without wind", 30, 50, 2, 30},
+		{"in between", 60, 50, 10, 60},
+	}
+
+	for _, test := range tests {
+		if got := nwsApparentTemperature(test.t, test.rh, test.ws); math.Abs(got - test.want) > 0.5 {
+			t.Errorf("%s: %v°F at %v%% with %v mph feels like %.2f°F, want %v°F", test.name, test.t, test.rh, test.ws, got, test.want)
+		}
+	}
+}
+
+func TestAustralianApparentTemperature(t *testing.T) {
+	tests := []struct {
+		t, rh, ws float64
+		want float64
+	}{
+		{25, 50, 2, 24.8},
+		{10, 80, 8, 3.6},
+		{35, 20, 0, 34.7},
+	}
+
+	for _, test := range tests {
+		if got := australianApparentTemperature(test.t, test.rh, test.ws); math.Abs(got - test.want) > 0.1 {
+			t.Errorf("%v°C at %v%% with %v m/s feels like %.2f°C, want %v°C", test.t, test.rh, test.ws, got, test.want)
+		}
+	}
+}
+
+func TestApparentTemperatureUnits(t *testing.T) {
+	// The same conditions in every unit system
+	tests := []struct {
+		units string
+		temp, speed float32
+		// Apparent temperature with the NWS formula, in the same units
+		want float64
+	}{
+		{"metric", 32, 4.4704, 40.41},
+		{"imperial", 89.6, 10, 104.74},
+		{"standard", 305.15, 4.4704, 313.56},
+	}
+
+	for _, test := range tests {
+		var weather WeatherResponse
+		weather.Main.Temp = test.temp
+		weather.Main.Humidity = 70
+		weather.Wind.Speed = test.speed
+
+		if got := apparentTemperature("nws", test.units, weather); math.Abs(got - test.want) > 0.05 {
+			t.Errorf("Apparent temperature in %s units is %.2f, want %v", test.units, got, test.want)
+		}
+	}
+}
+
+func TestEMA(t *testing.T) {
+	tests := []struct {
+		alpha float64
+		values []float64
+		want []float64
+	}{
+		{0.5, []float64{4, 8, 2, 2}, []float64{4, 6, 4, 3}},
+		{0.3, []float64{10, 0, 0}, []float64{10, 7, 4.9}},
+		{1, []float64{4, 8, 2}, []float64{4, 8, 2}},
+	}
+
+	for i, test := range tests {
+		location := fmt.Sprintf("Lisbon %d", i)
+
+		for j, value := range test.values {
+			if got := ema(location, "wind_speed", value, test.alpha); math.Abs(got - test.want[j]) > 1e-9 {
+				t.Errorf("Alpha %v: average after %v is %v, want %v", test.alpha, test.values[:j + 1], got, test.want[j])
+			}
+		}
+	}
+
+	// Every location and field has an average of its own
+	if got := ema("Lisbon 0", "temperature", 20, 0.5); got != 20 {
+		t.Errorf("First temperature averages to %v, want 20", got)
+	}
+}
+
+func TestRoundFields(t *testing.T) {
+	tests := []struct {
+		decimals int
+		value interface{}
+		want interface{}
+	}{
+		{1, 18.46, 18.5},
+		{1, 18.44, 18.4},
+		{0, 18.5, 18.0},
+		{0, 19.5, 20.0},
+		{2, 1015.126, 1015.13},
+		{1, int64(70), int64(70)},
+		{1, "light rain", "light rain"},
+	}
+
+	for _, test := range tests {
+		p := write.NewPointWithMeasurement("weather").AddField("value", test.value)
+		roundFields(p, test.decimals)
+
+		if got, _ := fieldValue(p, "value"); got != test.want {
+			t.Errorf("%v rounded to %d decimals is %v, want %v", test.value, test.decimals, got, test.want)
+		}
+	}
+}
+
+func TestPressureUnits(t *testing.T) {
+	tests := []struct {
+		name string
+		units string
+		groundLevel bool
+		want map[string]float64
+		wantErr string
+	}{
+		{"none", "[]", false, map[string]float64{}, ""},
+		{"inHg", "[ \"inhg\" ]", false, map[string]float64{"pressure_inhg": 29.97}, ""},
+		{"both", "[ \"inhg\", \"mmhg\" ]", false, map[string]float64{"pressure_inhg": 29.97, "pressure_mmhg": 761.31}, ""},
+		// Converted from the pressure in the location like the hPa field
+		{"ground level", "[ \"mmhg\" ]", true, map[string]float64{"pressure_mmhg": 751.56}, ""},
+		{"unknown unit", "[ \"psi\" ]", false, nil, "Unknown derived.pressure_units entry 'psi'"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, err := loadTestConfig(t, baseConfig + "[derived]\npressure_units = " + test.units + "\n")
+
+			if test.wantErr == "" && err != nil || test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Fatalf("loadConfig returned %v, want an error with '%s'", err, test.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			weather := testReading("Lisbon")
+			weather.Main.GroundLevel = 1002
+			weather.Main.HasGroundLevel = test.groundLevel
+
+			p := weatherPoints(cfg, weather, "Lisbon")[0]
+
+			for _, key := range []string{"pressure_inhg", "pressure_mmhg"} {
+				got, ok := fieldValue(p, key)
+				want, wanted := test.want[key]
+
+				if ok != wanted || ok && math.Abs(got.(float64) - want) > 0.01 {
+					t.Errorf("Stored %s %v, want %v", key, got, want)
+				}
+			}
+		})
+	}
+}
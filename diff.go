@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+// Field values of the last reading seen for each location
+var lastValues = map[string]map[string]interface{}{}
+
+// diffPoint compares a point's fields with the previous reading for the same
+// location, returning a compact summary of what changed, and remembers the
+// point's fields for the next comparison
+func diffPoint(location string, p *write.Point) string {
+	values := map[string]interface{}{}
+
+	for _, f := range p.FieldList() {
+		values[f.Key] = f.Value
+	}
+
+	previous, ok := lastValues[location]
+	lastValues[location] = values
+
+	if !ok {
+		return "no previous reading"
+	}
+
+	var changes []string
+
+	for key, value := range values {
+		old, ok := previous[key]
+
+		if !ok {
+			changes = append(changes, fmt.Sprintf("%s new", key))
+			continue
+		}
+
+		// A field that changed type since the last reading, e.g. an int
+		// written as a float, is shown as before and after instead
+		switch v := value.(type) {
+		case float64:
+			if o, ok := old.(float64); ok {
+				if delta := v - o; delta != 0 {
+					changes = append(changes, fmt.Sprintf("%s %+.2f", key, delta))
+				}
+				continue
+			}
+		case int64:
+			if o, ok := old.(int64); ok {
+				if delta := v - o; delta != 0 {
+					changes = append(changes, fmt.Sprintf("%s %+d", key, delta))
+				}
+				continue
+			}
+		}
+
+		if value != old {
+			changes = append(changes, fmt.Sprintf("%s %v -> %v", key, old, value))
+		}
+	}
+
+	if len(changes) == 0 {
+		return "no changes"
+	}
+
+	sort.Strings(changes)
+
+	return strings.Join(changes, ", ")
+}
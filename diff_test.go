@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+func TestDiffPoint(t *testing.T) {
+	reading := func(fields map[string]interface{}) *write.Point {
+		return write.NewPoint("weather", nil, fields, clock.Now())
+	}
+
+	tests := []struct {
+		name string
+		points []map[string]interface{}
+		want string
+	}{
+		{"first reading", []map[string]interface{}{{"temperature": 18.5}}, "no previous reading"},
+		{"same reading", []map[string]interface{}{{"temperature": 18.5}, {"temperature": 18.5}}, "no changes"},
+		{"numbers changing", []map[string]interface{}{{"temperature": 18.5, "humidity": int64(70)}, {"temperature": 17.25, "humidity": int64(72)}}, "humidity +2, temperature -1.25"},
+		{"new field", []map[string]interface{}{{"temperature": 18.5}, {"temperature": 18.5, "rain": 0.5}}, "rain new"},
+		{"other values", []map[string]interface{}{{"condition": "Clouds", "raining": false}, {"condition": "Rain", "raining": true}}, "condition Clouds -> Rain, raining false -> true"},
+		{"type changing", []map[string]interface{}{{"humidity": int64(70), "visibility": 10000.0}, {"humidity": 71.5, "visibility": "10000"}}, "humidity 70 -> 71.5, visibility 10000 -> 10000"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lastValues = map[string]map[string]interface{}{}
+
+			var got string
+
+			for _, fields := range test.points {
+				got = diffPoint("Lisbon", reading(fields))
+			}
+
+			if got != test.want {
+				t.Errorf("Got '%s', want '%s'", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDiffPointPerLocation(t *testing.T) {
+	lastValues = map[string]map[string]interface{}{}
+
+	diffPoint("Lisbon", write.NewPointWithMeasurement("weather").AddField("temperature", 18.5))
+
+	if got := diffPoint("Porto", write.NewPointWithMeasurement("weather").AddField("temperature", 15.0)); got != "no previous reading" {
+		t.Errorf("Porto compared with Lisbon's reading: '%s'", got)
+	}
+}
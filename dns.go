@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// Addresses a host last resolved to, and when
+type resolved struct {
+	addrs []string
+	at time.Time
+}
+
+// dnsCache remembers the addresses hosts resolved to, so that connecting
+// can carry on during a brief DNS outage. Addresses are only reused once a
+// lookup fails, and only for up to the TTL, after which they are likely
+// enough to be stale to be worth failing over.
+type dnsCache struct {
+	ttl time.Duration
+	resolver *net.Resolver
+	dialer *net.Dialer
+
+	mutex sync.Mutex
+	hosts map[string]resolved
+}
+
+func newDNSCache(ttl time.Duration, dialer *net.Dialer) *dnsCache {
+	return &dnsCache{
+		ttl: ttl,
+		resolver: net.DefaultResolver,
+		dialer: dialer,
+		hosts: map[string]resolved{},
+	}
+}
+
+// lookup resolves a host, falling back on the addresses it last resolved to
+// if that fails
+func (c *dnsCache) lookup(ctx context.Context, host string) ([]string, error) {
+	addrs, err := c.resolver.LookupHost(ctx, host)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if err == nil {
+		c.hosts[host] = resolved{addrs, clock.Now()}
+		return addrs, nil
+	}
+
+	if cached, ok := c.hosts[host]; ok && clock.Now().Sub(cached.at) < c.ttl {
+		log.Printf("Error resolving %s, using the addresses it resolved to %v ago: %v", host, clock.Now().Sub(cached.at).Round(time.Second), err)
+		return cached.addrs, nil
+	}
+
+	return nil, err
+}
+
+// DialContext connects to the first of the host's addresses that accepts
+func (c *dnsCache) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+
+	if err != nil {
+		return nil, err
+	}
+
+	addrs, err := c.lookup(ctx, host)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, addr := range addrs {
+		var conn net.Conn
+
+		if conn, err = c.dialer.DialContext(ctx, network, net.JoinHostPort(addr, port)); err == nil {
+			return conn, nil
+		}
+	}
+
+	return nil, err
+}
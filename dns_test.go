@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// downResolver fails every lookup that needs a DNS server
+var downResolver = &net.Resolver{
+	PreferGo: true,
+	Dial: func(ctx context.Context, network string, address string) (net.Conn, error) {
+		return nil, errors.New("network is unreachable")
+	},
+}
+
+func TestDNSCacheLookup(t *testing.T) {
+	tests := []struct {
+		name string
+		// How long ago the host last resolved, never if negative
+		resolvedAgo time.Duration
+		wantErr bool
+	}{
+		{"recently resolved", time.Minute, false},
+		{"resolved too long ago", 20 * time.Minute, true},
+		{"never resolved", -1, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := useSelfAdvancingClock(t)
+			cache := newDNSCache(10 * time.Minute, &net.Dialer{})
+			cache.resolver = downResolver
+
+			if test.resolvedAgo >= 0 {
+				cache.hosts["api.openweathermap.example"] = resolved{[]string{"192.0.2.1"}, c.Now().Add(-test.resolvedAgo)}
+			}
+
+			addrs, err := cache.lookup(context.Background(), "api.openweathermap.example")
+
+			if (err != nil) != test.wantErr {
+				t.Fatalf("lookup returned %v, %v, want an error: %v", addrs, err, test.wantErr)
+			}
+
+			if err == nil && (len(addrs) != 1 || addrs[0] != "192.0.2.1") {
+				t.Errorf("lookup returned %v, want the cached 192.0.2.1", addrs)
+			}
+		})
+	}
+}
+
+func TestDNSCacheRemembers(t *testing.T) {
+	useSelfAdvancingClock(t)
+	cache := newDNSCache(10 * time.Minute, &net.Dialer{})
+
+	if _, err := cache.lookup(context.Background(), "127.0.0.1"); err != nil {
+		t.Fatalf("lookup returned %v", err)
+	}
+
+	if _, ok := cache.hosts["127.0.0.1"]; !ok {
+		t.Errorf("Didn't remember what the host resolved to")
+	}
+}
+
+func TestDNSCacheDialDuringOutage(t *testing.T) {
+	c := useSelfAdvancingClock(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer listener.Close()
+
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	cache := newDNSCache(10 * time.Minute, &net.Dialer{})
+	cache.resolver = downResolver
+
+	// The first address no longer accepts, the second one does
+	cache.hosts["api.openweathermap.example"] = resolved{[]string{"127.0.0.2", "127.0.0.1"}, c.Now()}
+
+	conn, err := cache.DialContext(context.Background(), "tcp", net.JoinHostPort("api.openweathermap.example", port))
+
+	if err != nil {
+		t.Fatalf("DialContext returned %v", err)
+	}
+
+	conn.Close()
+}
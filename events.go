@@ -0,0 +1,38 @@
+package main
+
+import (
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+// Last known rain state per location, used to detect transitions
+var raining = map[string]bool{}
+
+// rainEvent returns the rain transition for a location, if any, given the
+// latest 1h precipitation. Values at or below the threshold count as dry so
+// that tiny fluctuations around zero don't produce spurious events.
+func rainEvent(location string, rain float32, threshold float32) string {
+	now := rain > threshold
+	was, known := raining[location]
+
+	raining[location] = now
+
+	if !known || now == was {
+		return ""
+	}
+
+	if now {
+		return "rain_onset"
+	}
+
+	return "rain_cessation"
+}
+
+func eventPoint(cfg EventsConfig, event string, weather WeatherResponse, location string) *write.Point {
+	return influxdb2.NewPointWithMeasurement(cfg.Measurement).
+		AddTag("location", tagValue(location)).
+		AddTag("city", tagValue(weather.Name)).
+		AddTag("country", tagValue(weather.Sys.Country)).
+		AddField("event", event).
+		AddField("rain_1h", weather.Rain.LastHour)
+}
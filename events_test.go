@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRainEvent(t *testing.T) {
+	tests := []struct {
+		name string
+		threshold float32
+		rain []float32
+		want []string
+	}{
+		{"first reading", 0, []float32{1.5}, []string{""}},
+		{"onset and cessation", 0, []float32{0, 0.4, 1.2, 0}, []string{"", "rain_onset", "", "rain_cessation"}},
+		{"staying dry", 0, []float32{0, 0, 0}, []string{"", "", ""}},
+		{"drizzle under the threshold", 0.2, []float32{0, 0.1, 0.2, 0.3, 0.2}, []string{"", "", "", "rain_onset", "rain_cessation"}},
+	}
+
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			location := fmt.Sprintf("Lisbon %d", i)
+
+			for j, rain := range test.rain {
+				if got := rainEvent(location, rain, test.threshold); got != test.want[j] {
+					t.Errorf("Reading %d with %vmm: event '%s', want '%s'", j + 1, rain, got, test.want[j])
+				}
+			}
+		})
+	}
+}
+
+func TestEventPoint(t *testing.T) {
+	weather := testReading("Lisbon")
+	weather.Rain.LastHour = 1.5
+
+	p := eventPoint(EventsConfig{Measurement: "weather_events"}, "rain_onset", weather, "lisbon-home")
+
+	if p.Name() != "weather_events" {
+		t.Errorf("Event written to '%s', want weather_events", p.Name())
+	}
+
+	if event, _ := fieldValue(p, "event"); event != "rain_onset" {
+		t.Errorf("Event is '%v', want rain_onset", event)
+	}
+
+	if rain, _ := fieldValue(p, "rain_1h"); rain != 1.5 {
+		t.Errorf("Event has rain_1h %v, want 1.5", rain)
+	}
+
+	for _, tag := range p.TagList() {
+		if tag.Key == "location" && tag.Value != "lisbon-home" {
+			t.Errorf("Event tagged with location '%s', want lisbon-home", tag.Value)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"sync/atomic"
+)
+
+// Exit codes, so that a supervisor can react to each fatal condition
+// differently, e.g. not restarting on a bad config
+const (
+	// Anything else, including a forced shutdown or a stuck loop
+	exitFailure = 1
+	// The config can't be loaded or doesn't make sense
+	exitConfig = 2
+	// The weather API keeps rejecting the API key
+	exitAuth = 3
+	// The sinks can't be written to, at startup or for too long after
+	exitSinks = 4
+)
+
+// Fetches rejected as unauthorized, and writes failed, in a row
+var authFailures int64
+var sinkFailures int64
+
+// Exit code to shut down with once the sensor gives up, handled by the main
+// loop like a signal
+var giveUp = make(chan int, 1)
+
+func stop(code int) {
+	select {
+	case giveUp <- code:
+	default:
+	}
+}
+
+// fatal logs the message and exits with the given code, without running the
+// cleanups. Only meant for before the sensor starts running.
+func fatal(code int, format string, args ...interface{}) {
+	log.Printf(format, args...)
+	os.Exit(code)
+}
+
+// checkAuth gives up once the weather API has rejected the API key for the
+// configured number of fetches in a row. Any successful fetch starts the
+// count over, other errors don't say either way.
+func checkAuth(cfg SensorConfig, err error) {
+	var serr statusError
+
+	if err == nil {
+		atomic.StoreInt64(&authFailures, 0)
+		return
+	}
+
+	if !errors.As(err, &serr) || serr.StatusCode != http.StatusUnauthorized {
+		return
+	}
+
+	if n := atomic.AddInt64(&authFailures, 1); cfg.MaxAuthFailures > 0 && n >= int64(cfg.MaxAuthFailures) {
+		log.Printf("The weather API rejected the API key %d times in a row, giving up", n)
+		stop(exitAuth)
+	}
+}
+
+// checkSinks gives up once writes have failed the configured number of times
+// in a row. A write that reached some of the sinks didn't fail, one sink
+// being down is no reason to stop writing to the others.
+func checkSinks(cfg SensorConfig, err error) {
+	var serr sinksFailed
+
+	if err == nil || errors.As(err, &serr) && serr.partial() {
+		atomic.StoreInt64(&sinkFailures, 0)
+		return
+	}
+
+	if n := atomic.AddInt64(&sinkFailures, 1); cfg.MaxSinkFailures > 0 && n >= int64(cfg.MaxSinkFailures) {
+		log.Printf("Writes failed %d times in a row, giving up", n)
+		stop(exitSinks)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+// gaveUp returns the exit code the sensor gave up with, if it did
+func gaveUp() (int, bool) {
+	select {
+	case code := <-giveUp:
+		return code, true
+	default:
+		return 0, false
+	}
+}
+
+func TestCheckSinks(t *testing.T) {
+	down := fmt.Errorf("connection refused")
+	oneDown := sinksFailed{[]error{down, nil}}
+	allDown := sinksFailed{[]error{down, down}}
+
+	tests := []struct {
+		name string
+		errs []error
+		wantGiveUp bool
+	}{
+		{"every write failing", []error{down, down, down}, true},
+		{"a write going through", []error{down, down, nil, down, down}, false},
+		{"every instance down", []error{allDown, allDown, allDown}, true},
+		{"one of two instances down", []error{oneDown, oneDown, oneDown, oneDown}, false},
+		{"the other instance coming back", []error{allDown, allDown, oneDown, allDown, allDown}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sinkFailures = 0
+			gaveUp()
+
+			for _, err := range test.errs {
+				checkSinks(SensorConfig{MaxSinkFailures: 3}, err)
+			}
+
+			code, ok := gaveUp()
+
+			if ok != test.wantGiveUp {
+				t.Fatalf("Gave up: %v, want %v", ok, test.wantGiveUp)
+			}
+
+			if ok && code != exitSinks {
+				t.Errorf("Gave up with code %d, want %d", code, exitSinks)
+			}
+		})
+	}
+}
+
+func TestCheckAuth(t *testing.T) {
+	unauthorized := statusError{StatusCode: http.StatusUnauthorized}
+	unavailable := statusError{StatusCode: http.StatusServiceUnavailable}
+
+	tests := []struct {
+		name string
+		errs []error
+		wantGiveUp bool
+	}{
+		{"key rejected", []error{unauthorized, unauthorized}, true},
+		{"other errors in between", []error{unauthorized, unavailable, unauthorized}, true},
+		{"a fetch going through", []error{unauthorized, nil, unauthorized}, false},
+		{"wrapped", []error{fetchFailed{unauthorized}, fmt.Errorf("fetching: %w", unauthorized)}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			authFailures = 0
+			gaveUp()
+
+			for _, err := range test.errs {
+				checkAuth(SensorConfig{MaxAuthFailures: 2}, err)
+			}
+
+			code, ok := gaveUp()
+
+			if ok != test.wantGiveUp {
+				t.Fatalf("Gave up: %v, want %v", ok, test.wantGiveUp)
+			}
+
+			if ok && code != exitAuth {
+				t.Errorf("Gave up with code %d, want %d", code, exitAuth)
+			}
+		})
+	}
+}
+
+func TestMultiSinkPartialFailure(t *testing.T) {
+	down := &memorySink{fail: fmt.Errorf("connection refused")}
+	up := &memorySink{}
+	m := &multiSink{[]Sink{down, up}}
+
+	err := m.Write(context.Background(), []*write.Point{write.NewPointWithMeasurement("weather").AddField("temperature", 18.5)})
+
+	var serr sinksFailed
+
+	if !errors.As(err, &serr) {
+		t.Fatalf("Write returned %v, want the sinks that failed", err)
+	}
+
+	if !serr.partial() {
+		t.Errorf("Write to one of two sinks isn't partial")
+	}
+
+	if len(up.points) != 1 {
+		t.Errorf("Healthy sink got %d points, want 1", len(up.points))
+	}
+}
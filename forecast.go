@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type TempSpec struct {
+	Day float32 `json:"day"`
+	Min float32 `json:"min"`
+	Max float32 `json:"max"`
+	Night float32 `json:"night"`
+	Evening float32 `json:"eve"`
+	Morning float32 `json:"morn"`
+}
+
+// One day of the One Call daily forecast
+type DailyForecastSpec struct {
+	Timestamp int `json:"dt"`
+	Summary string `json:"summary"`
+	Temp TempSpec `json:"temp"`
+	Pressure float32 `json:"pressure"`
+	Humidity float32 `json:"humidity"`
+	WindSpeed float32 `json:"wind_speed"`
+	WindDegree float32 `json:"wind_deg"`
+	WindGust float32 `json:"wind_gust"`
+	Clouds int `json:"clouds"`
+	UVIndex float32 `json:"uvi"`
+	// Probability of precipitation, from 0 to 1
+	Pop float32 `json:"pop"`
+	// Millimeters over the day, left out when there is none
+	Rain float32 `json:"rain"`
+	Snow float32 `json:"snow"`
+	Weather []WeatherSpec `json:"weather"`
+}
+
+// One hour of the One Call hourly forecast
+type HourlyForecastSpec struct {
+	Timestamp int `json:"dt"`
+	Temp float32 `json:"temp"`
+	FeelsLike float32 `json:"feels_like"`
+	Pressure float32 `json:"pressure"`
+	Humidity float32 `json:"humidity"`
+	WindSpeed float32 `json:"wind_speed"`
+	WindDegree float32 `json:"wind_deg"`
+	WindGust float32 `json:"wind_gust"`
+	Clouds int `json:"clouds"`
+	Visibility int `json:"visibility"`
+	Pop float32 `json:"pop"`
+	Rain RainSpec `json:"rain"`
+	Snow SnowSpec `json:"snow"`
+	Weather []WeatherSpec `json:"weather"`
+}
+
+// Response of the One Call endpoint, only with the parts that are asked for
+type OneCallResponse struct {
+	Latitude float32 `json:"lat"`
+	Longitude float32 `json:"lon"`
+	Timezone string `json:"timezone"`
+	TimezoneOffset int `json:"timezone_offset"`
+	Hourly []HourlyForecastSpec `json:"hourly"`
+	Daily []DailyForecastSpec `json:"daily"`
+}
+
+// Response of the pro plan's hourly forecast, which is laid out like current
+// weather readings
+type hourlyForecastResponse struct {
+	List []struct {
+		Timestamp int `json:"dt"`
+		Main MainSpec `json:"main"`
+		Wind WindSpec `json:"wind"`
+		Clouds CloudSpec `json:"clouds"`
+		Visibility int `json:"visibility"`
+		Pop float32 `json:"pop"`
+		Rain RainSpec `json:"rain"`
+		Snow SnowSpec `json:"snow"`
+		Weather []WeatherSpec `json:"weather"`
+	} `json:"list"`
+}
+
+// fetchForecast gets a location's forecast from the One Call endpoint, and
+// the hourly forecast from the pro plan's if it's for longer than One Call's
+func fetchForecast(ctx context.Context, cfg *Config, location Location) (OneCallResponse, error) {
+	var res OneCallResponse
+
+	ctx, span := tracer.Start(ctx, "fetch_forecast", trace.WithAttributes(attribute.String("location", location.Tag())))
+	defer span.End()
+
+	hourly := cfg.Forecast.Hourly
+
+	if cfg.Forecast.Daily.Enabled || hourly.Enabled && !hourly.fromPro() {
+		exclude := []string{"current", "minutely", "alerts"}
+
+		if !hourly.Enabled || hourly.fromPro() {
+			exclude = append(exclude, "hourly")
+		}
+
+		if !cfg.Forecast.Daily.Enabled {
+			exclude = append(exclude, "daily")
+		}
+
+		params := queryParams(cfg.WeatherAPI, location)
+		params.Add("exclude", strings.Join(exclude, ","))
+
+		if err := apiGet(ctx, cfg.WeatherAPI, "onecall", params, &res); err != nil {
+			return res, err
+		}
+	}
+
+	if hourly.fromPro() {
+		var pro hourlyForecastResponse
+
+		params := queryParams(cfg.WeatherAPI, location)
+		params.Add("cnt", strconv.Itoa(hourly.Hours))
+
+		if err := apiGet(ctx, cfg.WeatherAPI, "hourly", params, &pro); err != nil {
+			return res, err
+		}
+
+		for _, hour := range pro.List {
+			res.Hourly = append(res.Hourly, HourlyForecastSpec{
+				Timestamp: hour.Timestamp,
+				Temp: hour.Main.Temp,
+				FeelsLike: hour.Main.FeelsLike,
+				Pressure: hour.Main.Pressure,
+				Humidity: hour.Main.Humidity,
+				WindSpeed: hour.Wind.Speed,
+				WindDegree: hour.Wind.Degree,
+				WindGust: hour.Wind.Gust,
+				Clouds: hour.Clouds.All,
+				Visibility: hour.Visibility,
+				Pop: hour.Pop,
+				Rain: hour.Rain,
+				Snow: hour.Snow,
+				Weather: hour.Weather,
+			})
+		}
+	}
+
+	return res, nil
+}
+
+// dailyForecastPoints builds a point per forecast day, stamped with the
+// time the forecast is for
+func dailyForecastPoints(cfg DailyForecastConfig, forecast OneCallResponse, location string) []*write.Point {
+	var points []*write.Point
+
+	for _, day := range forecast.Daily {
+		p := influxdb2.NewPointWithMeasurement(cfg.Measurement).
+			AddTag("location", tagValue(location)).
+			AddField("temperature", day.Temp.Day).
+			AddField("temperature_min", day.Temp.Min).
+			AddField("temperature_max", day.Temp.Max).
+			AddField("temperature_night", day.Temp.Night).
+			AddField("humidity", day.Humidity).
+			AddField("pressure", day.Pressure).
+			AddField("wind_speed", day.WindSpeed).
+			AddField("wind_bearing", day.WindDegree).
+			AddField("wind_gusts", day.WindGust).
+			AddField("clouds", day.Clouds).
+			AddField("uv_index", day.UVIndex).
+			AddField("precipitation_probability", day.Pop).
+			AddField("rain", day.Rain).
+			AddField("snow", day.Snow).
+			SetTime(time.Unix(int64(day.Timestamp), 0))
+
+		if day.Summary != "" {
+			p.AddField("summary", day.Summary)
+		}
+
+		if len(day.Weather) > 0 {
+			p.AddField("description", day.Weather[0].Description)
+		}
+
+		points = append(points, p)
+	}
+
+	return points
+}
+
+// hourlyForecastPoints builds a point for every Nth forecast hour, starting
+// with the first one, up to the configured hours
+func hourlyForecastPoints(cfg HourlyForecastConfig, forecast OneCallResponse, location string) []*write.Point {
+	var points []*write.Point
+
+	for i := 0; i < len(forecast.Hourly) && i < cfg.Hours; i += cfg.Every {
+		hour := forecast.Hourly[i]
+
+		p := influxdb2.NewPointWithMeasurement(cfg.Measurement).
+			AddTag("location", tagValue(location)).
+			AddField("temperature", hour.Temp).
+			AddField("feels_like", hour.FeelsLike).
+			AddField("humidity", hour.Humidity).
+			AddField("pressure", hour.Pressure).
+			AddField("wind_speed", hour.WindSpeed).
+			AddField("wind_bearing", hour.WindDegree).
+			AddField("wind_gusts", hour.WindGust).
+			AddField("clouds", hour.Clouds).
+			AddField("visibility", hour.Visibility).
+			AddField("precipitation_probability", hour.Pop).
+			AddField("rain_1h", hour.Rain.LastHour).
+			AddField("snow_1h", hour.Snow.LastHour).
+			SetTime(time.Unix(int64(hour.Timestamp), 0))
+
+		if len(hour.Weather) > 0 {
+			p.AddField("description", hour.Weather[0].Description)
+		}
+
+		points = append(points, p)
+	}
+
+	return points
+}
+
+// Last time each location's forecast was fetched
+var forecasted = map[string]time.Time{}
+
+// forecastDue tells whether a location's forecast is due to be fetched
+// again, counting this as its fetch if it is
+func forecastDue(cfg ForecastConfig, location Location) bool {
+	if last, ok := forecasted[location.Tag()]; ok && clock.Now().Sub(last) < cfg.Interval {
+		return false
+	}
+
+	forecasted[location.Tag()] = clock.Now()
+
+	return true
+}
+
+// writeForecast fetches and writes a location's forecast
+func writeForecast(ctx context.Context, cfg *Config, location Location) error {
+	forecast, err := fetchForecast(ctx, cfg, location)
+
+	if err != nil {
+		return err
+	}
+
+	ctx, span := tracer.Start(ctx, "write_forecast", trace.WithAttributes(attribute.String("location", location.Tag())))
+	defer span.End()
+
+	var points []*write.Point
+
+	if cfg.Forecast.Daily.Enabled {
+		points = append(points, dailyForecastPoints(cfg.Forecast.Daily, forecast, location.Tag())...)
+	}
+
+	if cfg.Forecast.Hourly.Enabled {
+		points = append(points, hourlyForecastPoints(cfg.Forecast.Hourly, forecast, location.Tag())...)
+	}
+
+	if len(points) == 0 {
+		return nil
+	}
+
+	if cfg.InfluxDB.FloatPrecision != nil {
+		for _, p := range points {
+			roundFields(p, *cfg.InfluxDB.FloatPrecision)
+		}
+	}
+
+	return writePoints(ctx, cfg, location.Tag(), points)
+}
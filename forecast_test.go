@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestForecastDue(t *testing.T) {
+	tests := []struct {
+		interval time.Duration
+		// Time since the start of each call
+		at []time.Duration
+		want []bool
+	}{
+		{3 * time.Hour, []time.Duration{0, time.Hour, 3 * time.Hour, 4 * time.Hour, 6 * time.Hour}, []bool{true, false, true, false, true}},
+		{0, []time.Duration{0, 5 * time.Minute, 10 * time.Minute}, []bool{true, true, true}},
+	}
+
+	for _, test := range tests {
+		c := useSelfAdvancingClock(t)
+		start := c.Now()
+		forecasted = map[string]time.Time{}
+
+		for i, at := range test.at {
+			c.Advance(start.Add(at).Sub(c.Now()))
+
+			if got := forecastDue(ForecastConfig{Interval: test.interval}, Location{Name: "Lisbon"}); got != test.want[i] {
+				t.Errorf("Interval %v: forecast due after %v: %v, want %v", test.interval, at, got, test.want[i])
+			}
+		}
+	}
+}
+
+// oneCall answers One Call requests with a forecast, or an error status
+type oneCall struct {
+	mutex sync.Mutex
+	status int
+	calls int
+}
+
+func (o *oneCall) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.calls++
+
+	if o.status != http.StatusOK {
+		w.WriteHeader(o.status)
+		return
+	}
+
+	fmt.Fprint(w, `{"lat": 38.7, "lon": -9.1, "daily": [{"dt": 1654084800, "temp": {"day": 24.1, "min": 15.2, "max": 26.3}, "humidity": 50}, {"dt": 1654171200, "temp": {"day": 22.4, "min": 14.9, "max": 24}, "humidity": 55}]}`)
+}
+
+func TestProcessLocationForecast(t *testing.T) {
+	tests := []struct {
+		name string
+		status int
+		wantPoints int
+	}{
+		{"forecast written", http.StatusOK, 1 + 2},
+		{"forecast failing", http.StatusUnauthorized, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := useSelfAdvancingClock(t)
+			api := &oneCall{status: test.status}
+			useAPI(t, api)
+			_, s := useFakes(t)
+			forecasted = map[string]time.Time{}
+
+			cfg := testConfig(t, "[weather_api]\nlocations = []\n[[weather_api.location]]\nquery = \"38.7,-9.1\"\nalias = \"Lisbon\"\n[forecast.daily]\nenabled = true\n")
+
+			// A cycle every 5 minutes for an hour
+			for i := 0; i < 12; i++ {
+				if err := processLocation(context.Background(), cfg, cfg.Locations[len(cfg.Locations) - 1]); err != nil {
+					t.Errorf("Cycle %d: processing the location returned %v, want no error", i + 1, err)
+				}
+
+				c.Advance(5 * time.Minute)
+			}
+
+			if api.calls != 1 {
+				t.Errorf("Fetched the forecast %d times in an hour, want 1", api.calls)
+			}
+
+			if got := len(s.written("Lisbon")); got != 12 - 1 + test.wantPoints {
+				t.Errorf("Wrote %d points, want %d", got, 12 - 1 + test.wantPoints)
+			}
+		})
+	}
+}
+
+func TestHourlyForecastPoints(t *testing.T) {
+	var forecast OneCallResponse
+
+	for i := 0; i < 48; i++ {
+		forecast.Hourly = append(forecast.Hourly, HourlyForecastSpec{Timestamp: 1654084800 + i * 3600, Temp: float32(i)})
+	}
+
+	for _, test := range []struct{ every, want int }{{1, 48}, {3, 16}, {5, 10}, {48, 1}} {
+		points := hourlyForecastPoints(HourlyForecastConfig{Measurement: "weather_forecast_hourly", Every: test.every, Hours: 48}, forecast, "Lisbon")
+
+		if len(points) != test.want {
+			t.Errorf("Every %d hours: got %d points, want %d", test.every, len(points), test.want)
+			continue
+		}
+
+		if got := points[1 % len(points)].Time(); len(points) > 1 && !got.Equal(time.Unix(1654084800 + int64(test.every) * 3600, 0)) {
+			t.Errorf("Every %d hours: second point is for %v", test.every, got)
+		}
+	}
+}
+
+func TestHourlyForecastPointsHours(t *testing.T) {
+	var forecast OneCallResponse
+
+	for i := 0; i < 48; i++ {
+		forecast.Hourly = append(forecast.Hourly, HourlyForecastSpec{Timestamp: 1654084800 + i * 3600})
+	}
+
+	for _, test := range []struct{ hours, every, want int }{{48, 1, 48}, {24, 1, 24}, {24, 5, 5}, {96, 1, 48}} {
+		if got := len(hourlyForecastPoints(HourlyForecastConfig{Measurement: "weather_forecast_hourly", Every: test.every, Hours: test.hours}, forecast, "Lisbon")); got != test.want {
+			t.Errorf("%d hours every %d: got %d points, want %d", test.hours, test.every, got, test.want)
+		}
+	}
+}
+
+// forecastAPI answers One Call and pro hourly forecast requests, keeping the
+// paths and queries asked for
+type forecastAPI struct {
+	mutex sync.Mutex
+	requests []*url.URL
+}
+
+func (f *forecastAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	f.requests = append(f.requests, r.URL)
+	f.mutex.Unlock()
+
+	if r.URL.Path == endpoints["hourly"] {
+		fmt.Fprint(w, `{"cnt": 2, "list": [{"dt": 1654084800, "main": {"temp": 24.1, "feels_like": 24.5, "pressure": 1015, "humidity": 50}, "wind": {"speed": 3.5, "deg": 270, "gust": 6}, "clouds": {"all": 20}, "visibility": 10000, "pop": 0.2, "rain": {"1h": 0.3}}, {"dt": 1654088400, "main": {"temp": 23.2}}]}`)
+		return
+	}
+
+	// Only the parts that aren't excluded
+	parts := map[string]string{"hourly": `[{"dt": 1654084800, "temp": 20}]`, "daily": `[{"dt": 1654084800, "temp": {"day": 24.1}}]`}
+
+	for _, part := range strings.Split(r.URL.Query().Get("exclude"), ",") {
+		delete(parts, part)
+	}
+
+	body := `{"lat": 38.7, "lon": -9.1`
+
+	for part, value := range parts {
+		body += fmt.Sprintf(`, "%s": %s`, part, value)
+	}
+
+	fmt.Fprint(w, body + "}")
+}
+
+func TestFetchForecastPlans(t *testing.T) {
+	tests := []struct {
+		name string
+		forecast string
+		wantPaths []string
+		// Exclusions asked of One Call
+		wantExclude string
+		wantHourly int
+		wantDaily int
+	}{
+		{"One Call hourly", "[forecast.hourly]\nenabled = true\n", []string{endpoints["onecall"]}, "current,minutely,alerts,daily", 1, 0},
+		{"pro hourly", "[forecast.hourly]\nenabled = true\nhours = 96\n", []string{endpoints["hourly"]}, "", 2, 0},
+		{"pro hourly and daily", "[forecast.hourly]\nenabled = true\nhours = 96\n[forecast.daily]\nenabled = true\n", []string{endpoints["onecall"], endpoints["hourly"]}, "current,minutely,alerts,hourly", 2, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			api := &forecastAPI{}
+			useAPI(t, api)
+
+			cfg := testConfig(t, "[weather_api]\nplan = \"pro\"\nlocations = []\n[[weather_api.location]]\nquery = \"38.7,-9.1\"\nalias = \"Lisbon\"\n" + test.forecast)
+
+			res, err := fetchForecast(context.Background(), cfg, cfg.Locations[len(cfg.Locations) - 1])
+
+			if err != nil {
+				t.Fatalf("fetchForecast returned %v", err)
+			}
+
+			var paths []string
+
+			for _, u := range api.requests {
+				paths = append(paths, u.Path)
+
+				if u.Path == endpoints["onecall"] && u.Query().Get("exclude") != test.wantExclude {
+					t.Errorf("Excluded %s from One Call, want %s", u.Query().Get("exclude"), test.wantExclude)
+				}
+
+				if u.Path == endpoints["hourly"] && u.Query().Get("cnt") != "96" {
+					t.Errorf("Asked for %s hours, want 96", u.Query().Get("cnt"))
+				}
+			}
+
+			if strings.Join(paths, " ") != strings.Join(test.wantPaths, " ") {
+				t.Errorf("Requested %v, want %v", paths, test.wantPaths)
+			}
+
+			if len(res.Hourly) != test.wantHourly || len(res.Daily) != test.wantDaily {
+				t.Fatalf("Got %d hours and %d days, want %d and %d", len(res.Hourly), len(res.Daily), test.wantHourly, test.wantDaily)
+			}
+
+			if test.wantHourly == 2 {
+				want := HourlyForecastSpec{Timestamp: 1654084800, Temp: 24.1, FeelsLike: 24.5, Pressure: 1015, Humidity: 50, WindSpeed: 3.5, WindDegree: 270, WindGust: 6, Clouds: 20, Visibility: 10000, Pop: 0.2, Rain: RainSpec{LastHour: 0.3}}
+
+				if !reflect.DeepEqual(res.Hourly[0], want) {
+					t.Errorf("First hour is %+v, want %+v", res.Hourly[0], want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// Unit suffixes of the temperature and wind speed metrics for each of the
+// API's unit systems
+var temperatureUnits = map[string]string{
+	"standard": "kelvin",
+	"metric": "celsius",
+	"imperial": "fahrenheit",
+}
+
+var speedUnits = map[string]string{
+	"standard": "meters_per_second",
+	"metric": "meters_per_second",
+	"imperial": "miles_per_hour",
+}
+
+// Gauges of the latest readings, created as they're first needed since
+// their names depend on the configured units
+var gauges = map[string]*prometheus.GaugeVec{}
+var gaugesMutex sync.Mutex
+
+// When the gauges of each location were last set
+var exported = map[string]time.Time{}
+
+func gauge(name string, help string) *prometheus.GaugeVec {
+	gaugesMutex.Lock()
+	defer gaugesMutex.Unlock()
+
+	g, ok := gauges[name]
+
+	if !ok {
+		g = promauto.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: help}, []string{"location"})
+		gauges[name] = g
+	}
+
+	return g
+}
+
+// unitsOrDefault is the unit system the API answers in, which is standard
+// when no units are asked for
+func unitsOrDefault(units string) string {
+	if _, ok := temperatureUnits[units]; ok {
+		return units
+	}
+
+	return "standard"
+}
+
+// exportWeather sets the gauges of a location's latest reading
+func exportWeather(units string, location string, weather WeatherResponse) {
+	units = unitsOrDefault(units)
+
+	t := temperatureUnits[units]
+	s := speedUnits[units]
+
+	gauge("weather_temperature_" + t, "Air temperature in " + t + ".").WithLabelValues(location).Set(float64(weather.Main.Temp))
+	gauge("weather_feels_like_" + t, "Perceived temperature in " + t + ".").WithLabelValues(location).Set(float64(weather.Main.FeelsLike))
+	gauge("weather_humidity_percent", "Relative humidity in percent.").WithLabelValues(location).Set(float64(weather.Main.Humidity))
+	gauge("weather_pressure_hectopascals", "Atmospheric pressure in the location in hectopascals.").WithLabelValues(location).Set(float64(weather.Main.localPressure()))
+	gauge("weather_wind_speed_" + s, "Wind speed in " + s + ".").WithLabelValues(location).Set(float64(weather.Wind.Speed))
+	gauge("weather_wind_bearing_degrees", "Direction the wind blows from in degrees.").WithLabelValues(location).Set(float64(weather.Wind.Degree))
+	gauge("weather_clouds_percent", "Cloud cover in percent.").WithLabelValues(location).Set(float64(weather.Clouds.All))
+	gauge("weather_visibility_meters", "Visibility in meters.").WithLabelValues(location).Set(float64(weather.Visibility))
+	gauge("weather_rain_1h_millimeters", "Rain in the last hour in millimeters.").WithLabelValues(location).Set(float64(weather.Rain.LastHour))
+	gauge("weather_snow_1h_millimeters", "Snow in the last hour in millimeters.").WithLabelValues(location).Set(float64(weather.Snow.LastHour))
+
+	gaugesMutex.Lock()
+	exported[location] = clock.Now()
+	gaugesMutex.Unlock()
+}
+
+// evictReadings drops the gauges of locations that have had no reading for
+// longer than the TTL, rather than go on serving their last one as current
+func evictReadings(ttl time.Duration) {
+	gaugesMutex.Lock()
+	defer gaugesMutex.Unlock()
+
+	for location, at := range exported {
+		if clock.Now().Sub(at) <= ttl {
+			continue
+		}
+
+		for _, g := range gauges {
+			g.DeleteLabelValues(location)
+		}
+
+		readingAge.DeleteLabelValues(location)
+		delete(exported, location)
+
+		log.Printf("Last reading for location '%s' is over %v old, no longer serving it", location, ttl)
+	}
+}
+
+// locationMetrics serves the latest readings of a single location, the one
+// named in the path after /metrics/, for scraping each location as a target
+// of its own
+func locationMetrics(w http.ResponseWriter, r *http.Request) {
+	location := strings.TrimPrefix(r.URL.Path, "/metrics/")
+
+	families, err := prometheus.DefaultGatherer.Gather()
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var matched []*dto.MetricFamily
+
+	for _, family := range families {
+		var metrics []*dto.Metric
+
+		for _, m := range family.Metric {
+			for _, label := range m.Label {
+				if label.GetName() == "location" && label.GetValue() == location {
+					metrics = append(metrics, m)
+				}
+			}
+		}
+
+		if len(metrics) > 0 {
+			family.Metric = metrics
+			matched = append(matched, family)
+		}
+	}
+
+	if len(matched) == 0 {
+		http.Error(w, fmt.Sprintf("No readings for location '%s'", location), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", string(expfmt.FmtText))
+
+	for _, family := range matched {
+		if _, err := expfmt.MetricFamilyToText(w, family); err != nil {
+			log.Printf("Error serving metrics for location '%s': %v", location, err)
+			return
+		}
+	}
+}
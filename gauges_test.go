@@ -0,0 +1,191 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// dropGauges forgets the readings exported for a location
+func dropGauges(location string) {
+	gaugesMutex.Lock()
+	defer gaugesMutex.Unlock()
+
+	for _, g := range gauges {
+		g.DeleteLabelValues(location)
+	}
+
+	delete(exported, location)
+}
+
+func TestExportWeatherPressure(t *testing.T) {
+	cfg := testConfig(t, "")
+
+	tests := []struct {
+		name string
+		groundLevel bool
+		want float64
+	}{
+		{"ground level reported", true, 1002},
+		{"only sea level", false, 1015},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			weather := testReading("Lisbon")
+			weather.Main.GroundLevel = 1002
+			weather.Main.HasGroundLevel = test.groundLevel
+
+			exportWeather("metric", "Lisbon", weather)
+
+			got := testutil.ToFloat64(gauge("weather_pressure_hectopascals", "").WithLabelValues("Lisbon"))
+
+			if got != test.want {
+				t.Errorf("Pressure gauge is %v, want %v", got, test.want)
+			}
+
+			// The same pressure as written to InfluxDB
+			if field, _ := fieldValue(weatherPoints(cfg, weather, "Lisbon")[0], "pressure"); field != got {
+				t.Errorf("Pressure gauge is %v, but %v is written", got, field)
+			}
+		})
+	}
+}
+
+func TestExportWeatherUnits(t *testing.T) {
+	tests := []struct {
+		units string
+		temperature string
+		speed string
+	}{
+		{"standard", "kelvin", "meters_per_second"},
+		{"metric", "celsius", "meters_per_second"},
+		{"imperial", "fahrenheit", "miles_per_hour"},
+		// The API answers in standard units when none are asked for
+		{"", "kelvin", "meters_per_second"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.units, func(t *testing.T) {
+			// A location of its own, so only the gauges of these units have it
+			location := "Lisbon " + test.units
+			exportWeather(test.units, location, testReading("Lisbon"))
+
+			defer dropGauges(location)
+
+			families, err := prometheus.DefaultGatherer.Gather()
+
+			if err != nil {
+				t.Fatalf("Gathering the metrics returned %v", err)
+			}
+
+			got := map[string]string{}
+
+			for _, family := range families {
+				for _, m := range family.Metric {
+					for _, label := range m.Label {
+						if label.GetName() == "location" && label.GetValue() == location {
+							got[family.GetName()] = family.GetHelp()
+						}
+					}
+				}
+			}
+
+			want := map[string]string{
+				"weather_temperature_" + test.temperature: test.temperature,
+				"weather_feels_like_" + test.temperature: test.temperature,
+				"weather_wind_speed_" + test.speed: test.speed,
+			}
+
+			for name, unit := range want {
+				if help, ok := got[name]; !ok {
+					t.Errorf("No %s gauge for units '%s'", name, test.units)
+				} else if !strings.HasSuffix(help, " in " + unit + ".") {
+					t.Errorf("Help of %s is '%s', want it in %s", name, help, unit)
+				}
+			}
+
+			for name := range got {
+				suffixed := strings.HasPrefix(name, "weather_temperature_") || strings.HasPrefix(name, "weather_feels_like_") || strings.HasPrefix(name, "weather_wind_speed_")
+
+				if _, ok := want[name]; suffixed && !ok {
+					t.Errorf("Exported %s for units '%s'", name, test.units)
+				}
+			}
+		})
+	}
+}
+
+func TestLocationMetrics(t *testing.T) {
+	for _, location := range []string{"lisbon-home", "porto-office"} {
+		exportWeather("metric", location, testReading("Lisbon"))
+		defer dropGauges(location)
+	}
+
+	tests := []struct {
+		name string
+		path string
+		wantStatus int
+		want string
+		notWant string
+	}{
+		{"location", "/metrics/lisbon-home", http.StatusOK, `weather_temperature_celsius{location="lisbon-home"} 18.5`, `location="porto-office"`},
+		{"other location", "/metrics/porto-office", http.StatusOK, `weather_temperature_celsius{location="porto-office"} 18.5`, `location="lisbon-home"`},
+		{"unknown location", "/metrics/faro", http.StatusNotFound, "No readings for location 'faro'", "weather_temperature"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			locationMetrics(w, httptest.NewRequest(http.MethodGet, test.path, nil))
+
+			if w.Code != test.wantStatus {
+				t.Errorf("Answered %d, want %d", w.Code, test.wantStatus)
+			}
+
+			if body := w.Body.String(); !strings.Contains(body, test.want) || strings.Contains(body, test.notWant) {
+				t.Errorf("Served\n%s\nwant '%s' and not '%s' in it", body, test.want, test.notWant)
+			}
+		})
+	}
+}
+
+func TestEvictReadings(t *testing.T) {
+	tests := []struct {
+		name string
+		ttl time.Duration
+		// How long ago the location's reading was exported
+		age time.Duration
+		wantServed bool
+	}{
+		{"fresh", 10 * time.Minute, 5 * time.Minute, true},
+		{"at the TTL", 10 * time.Minute, 10 * time.Minute, true},
+		{"past the TTL", 10 * time.Minute, 11 * time.Minute, false},
+		{"no TTL", 0, 24 * time.Hour, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := useSelfAdvancingClock(t)
+			captureLog(t)
+
+			exportWeather("metric", "lisbon-ttl", testReading("Lisbon"))
+			defer dropGauges("lisbon-ttl")
+
+			c.Advance(test.age)
+
+			handler := evicting(test.ttl, http.HandlerFunc(locationMetrics))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics/lisbon-ttl", nil))
+
+			if served := w.Code == http.StatusOK; served != test.wantServed {
+				t.Errorf("Served the reading: %v, want %v", served, test.wantServed)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+)
+
+// A postal code followed by the ISO 3166 code of its country, which the
+// geocoding API insists on
+var zipPattern = regexp.MustCompile(`^[^,]+,[A-Za-z]{2}$`)
+
+// Response of the zip geocoding endpoint
+type ZipResponse struct {
+	Zip string `json:"zip"`
+	Name string `json:"name"`
+	Latitude float64 `json:"lat"`
+	Longitude float64 `json:"lon"`
+	Country string `json:"country"`
+}
+
+// Coordinates of the postal codes resolved so far, so a reload only looks
+// up new ones
+var zips = map[string]ZipResponse{}
+
+// Times a lookup that failed for reasons other than the zip code itself is
+// tried again before moving on
+const geocodeRetries = 3
+
+// geocodeZips resolves the coordinates of the locations given as postal
+// codes. Those resolved are then fetched by coordinates like any other.
+// Only zip codes the geocoder rejects are an error. Lookups failing
+// otherwise, e.g. on a network blip, are retried with a backoff and then
+// left for the next call. Until then the location is fetched by zip code.
+func geocodeZips(ctx context.Context, cfg *Config, retries int) error {
+	for i, location := range cfg.Locations {
+		if location.Zip == "" || location.HasCoordinates {
+			continue
+		}
+
+		res, ok := zips[location.Zip]
+
+		if !ok {
+			var err error
+
+			if res, err = lookupZip(ctx, cfg.WeatherAPI, location.Zip, retries); err != nil {
+				var serr statusError
+
+				if errors.As(err, &serr) && (serr.StatusCode == http.StatusNotFound || serr.StatusCode == http.StatusBadRequest) {
+					return fmt.Errorf("Error resolving zip code '%s': %v", location.Zip, err)
+				}
+
+				log.Printf("Error resolving zip code '%s', fetching it by zip code until it resolves: %v", location.Zip, err)
+				continue
+			}
+
+			log.Printf("Resolved zip code '%s' to %s at %v,%v", location.Zip, res.Name, res.Latitude, res.Longitude)
+			zips[location.Zip] = res
+		}
+
+		cfg.Locations[i].HasCoordinates = true
+		cfg.Locations[i].Latitude = res.Latitude
+		cfg.Locations[i].Longitude = res.Longitude
+	}
+
+	return nil
+}
+
+// lookupZip asks the geocoder for the coordinates of a zip code, trying
+// again with an exponential backoff unless the geocoder rejected it
+func lookupZip(ctx context.Context, cfg WeatherAPIConfig, zip string, retries int) (ZipResponse, error) {
+	params := url.Values{}
+	params.Add("zip", zip)
+	params.Add("appid", cfg.AppID)
+
+	backoff := time.Second
+
+	for attempt := 0; ; attempt++ {
+		var res ZipResponse
+		err := apiGet(ctx, cfg, "zip", params, &res)
+
+		var serr statusError
+
+		if err == nil || attempt >= retries || errors.As(err, &serr) && serr.StatusCode / 100 == 4 {
+			return res, err
+		}
+
+		log.Printf("Retrying the lookup of zip code '%s' in %v (%d/%d): %v", zip, backoff, attempt + 1, retries, err)
+		clock.Sleep(backoff)
+		backoff *= 2
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+// geocoder answers zip lookups with the given statuses in turn, the last
+// one from then on
+type geocoder struct {
+	mutex sync.Mutex
+	statuses []int
+	lookups int
+}
+
+func (g *geocoder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mutex.Lock()
+	status := g.statuses[len(g.statuses) - 1]
+
+	if g.lookups < len(g.statuses) {
+		status = g.statuses[g.lookups]
+	}
+
+	g.lookups++
+	g.mutex.Unlock()
+
+	if status != http.StatusOK {
+		w.WriteHeader(status)
+		return
+	}
+
+	fmt.Fprintf(w, `{"zip": "%s", "name": "Lisboa", "lat": 38.7167, "lon": -9.1333, "country": "PT"}`, r.URL.Query().Get("zip"))
+}
+
+func TestGeocodeZips(t *testing.T) {
+	tests := []struct {
+		name string
+		statuses []int
+		retries int
+		wantErr bool
+		wantResolved bool
+		wantLookups int
+	}{
+		{"resolved", []int{200}, 3, false, true, 1},
+		{"unknown zip code", []int{404}, 3, true, false, 1},
+		{"rejected zip code", []int{400}, 3, true, false, 1},
+		{"geocoder down", []int{503}, 3, false, false, 4},
+		{"geocoder back up", []int{503, 502, 200}, 3, false, true, 3},
+		{"geocoder down, no retries", []int{503}, 0, false, false, 1},
+		{"rate limited", []int{429}, 3, false, false, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useSelfAdvancingClock(t)
+
+			g := &geocoder{statuses: test.statuses}
+			useAPI(t, g)
+
+			zips = map[string]ZipResponse{}
+			cfg := testConfig(t, "[[weather_api.location]]\nzip = \"1000-001,PT\"\n")
+
+			err := geocodeZips(context.Background(), cfg, test.retries)
+
+			if (err != nil) != test.wantErr {
+				t.Errorf("geocodeZips returned %v, want an error: %v", err, test.wantErr)
+			}
+
+			location := cfg.Locations[len(cfg.Locations) - 1]
+
+			if location.HasCoordinates != test.wantResolved {
+				t.Errorf("Zip code resolved: %v, want %v", location.HasCoordinates, test.wantResolved)
+			}
+
+			if test.wantResolved && (location.Latitude != 38.7167 || location.Longitude != -9.1333) {
+				t.Errorf("Zip code resolved to %v,%v, want 38.7167,-9.1333", location.Latitude, location.Longitude)
+			}
+
+			if g.lookups != test.wantLookups {
+				t.Errorf("Looked the zip code up %d times, want %d", g.lookups, test.wantLookups)
+			}
+		})
+	}
+}
+
+func TestGeocodeZipsCached(t *testing.T) {
+	g := &geocoder{statuses: []int{200}}
+	useAPI(t, g)
+
+	zips = map[string]ZipResponse{}
+
+	for i := 0; i < 2; i++ {
+		cfg := testConfig(t, "[[weather_api.location]]\nzip = \"1000-001,PT\"\n")
+
+		if err := geocodeZips(context.Background(), cfg, 0); err != nil {
+			t.Fatalf("Error resolving: %v", err)
+		}
+	}
+
+	if g.lookups != 1 {
+		t.Errorf("Looked the zip code up %d times for two configs, want 1", g.lookups)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Guard against a step so small that the grid would burn through the quota
+const maxGridPoints = 10000
+
+// expandGrid turns a bounding box into one location per grid point, going
+// from the south west corner in steps of the configured size. The corners
+// themselves are included whenever the box is a whole number of steps.
+func expandGrid(cfg GridConfig) ([]Location, error) {
+	if cfg.Step <= 0 {
+		return nil, fmt.Errorf("Grid step must be positive, got %v", cfg.Step)
+	}
+
+	if cfg.South > cfg.North || cfg.West > cfg.East {
+		return nil, errors.New("Grid corners are inverted")
+	}
+
+	// Counting steps rather than accumulating them avoids drifting past the
+	// corners through float rounding
+	rows := int(math.Floor((cfg.North - cfg.South) / cfg.Step + 1e-9)) + 1
+	cols := int(math.Floor((cfg.East - cfg.West) / cfg.Step + 1e-9)) + 1
+
+	if rows * cols > maxGridPoints {
+		return nil, fmt.Errorf("Grid has %d points, more than the %d allowed", rows * cols, maxGridPoints)
+	}
+
+	var locations []Location
+
+	for i := 0; i < rows; i++ {
+		for j := 0; j < cols; j++ {
+			lat := roundCoordinate(cfg.South + float64(i) * cfg.Step)
+			lon := roundCoordinate(cfg.West + float64(j) * cfg.Step)
+
+			locations = append(locations, Location{
+				Name: strconv.FormatFloat(lat, 'f', -1, 64) + "," + strconv.FormatFloat(lon, 'f', -1, 64),
+				HasCoordinates: true,
+				Latitude: lat,
+				Longitude: lon,
+				Interval: time.Duration(cfg.Interval) * time.Second,
+			})
+		}
+	}
+
+	return locations, nil
+}
+
+// roundCoordinate drops float noise beyond what the API resolves
+func roundCoordinate(c float64) float64 {
+	return math.Round(c * 1e6) / 1e6
+}
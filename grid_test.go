@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExpandGrid(t *testing.T) {
+	tests := []struct {
+		name string
+		grid GridConfig
+		want []string
+		wantErr string
+	}{
+		{"whole steps", GridConfig{South: 38.6, North: 38.8, West: -9.2, East: -9.1, Step: 0.1}, []string{"38.6,-9.2", "38.6,-9.1", "38.7,-9.2", "38.7,-9.1", "38.8,-9.2", "38.8,-9.1"}, ""},
+		{"partial step", GridConfig{South: 0, North: 0.25, West: 0, East: 0, Step: 0.1}, []string{"0,0", "0.1,0", "0.2,0"}, ""},
+		{"single point", GridConfig{South: 38.7, North: 38.7, West: -9.1, East: -9.1, Step: 0.5}, []string{"38.7,-9.1"}, ""},
+		{"no step", GridConfig{South: 0, North: 1, West: 0, East: 1}, nil, "must be positive"},
+		{"inverted", GridConfig{South: 1, North: 0, West: 0, East: 1, Step: 0.1}, nil, "inverted"},
+		{"too many points", GridConfig{South: -90, North: 90, West: -180, East: 180, Step: 0.5}, nil, "more than the 10000 allowed"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			locations, err := expandGrid(test.grid)
+
+			if test.wantErr == "" && err != nil || test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Fatalf("expandGrid returned %v, want an error with '%s'", err, test.wantErr)
+			}
+
+			var got []string
+
+			for _, location := range locations {
+				got = append(got, location.Name)
+
+				if !location.HasCoordinates {
+					t.Errorf("Grid point %s has no coordinates", location.Name)
+				}
+			}
+
+			if strings.Join(got, " ") != strings.Join(test.want, " ") {
+				t.Errorf("Grid points are %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+// writeHeartbeat writes a point saying the sensor is alive, so dashboards
+// can tell a dead sensor from readings that didn't change
+func writeHeartbeat(ctx context.Context, cfg *Config) {
+	instance := cfg.Heartbeat.Instance
+
+	if instance == "" {
+		instance, _ = os.Hostname()
+	}
+
+	p := influxdb2.NewPointWithMeasurement(cfg.Heartbeat.Measurement).
+		AddTag("instance", tagValue(instance)).
+		AddField("alive", 1).
+		SetTime(clock.Now())
+
+	if err := writePoints(ctx, cfg, "heartbeat", []*write.Point{p}); err != nil {
+		logThrottled("Error writing the heartbeat: %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestHeartbeat(t *testing.T) {
+	hostname, _ := os.Hostname()
+
+	tests := []struct {
+		name string
+		config string
+		failing bool
+		wantInstance string
+	}{
+		{"locations written", "[heartbeat]\nenabled = true\ninstance = \"attic\"\n", false, "attic"},
+		{"every location failing", "[heartbeat]\nenabled = true\ninstance = \"attic\"\n", true, "attic"},
+		{"instance from the host name", "[heartbeat]\nenabled = true\n", false, hostname},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := useSelfAdvancingClock(t)
+			p, s := useFakes(t)
+			cfg := testConfig(t, test.config)
+
+			if test.failing {
+				p.setFailing("Lisbon", fmt.Errorf("connection refused"))
+			}
+
+			for i := 0; i < 2; i++ {
+				runCycle(cfg, map[string]time.Time{})
+				c.Advance(5 * time.Minute)
+			}
+
+			var beats int
+
+			for _, point := range s.points {
+				if point.Name() != "sensor_heartbeat" {
+					continue
+				}
+
+				beats++
+
+				if alive, _ := fieldValue(point, "alive"); alive != int64(1) {
+					t.Errorf("Heartbeat has alive %v, want 1", alive)
+				}
+
+				for _, tag := range point.TagList() {
+					if tag.Key == "instance" && tag.Value != test.wantInstance {
+						t.Errorf("Heartbeat is for instance '%s', want '%s'", tag.Value, test.wantInstance)
+					}
+				}
+			}
+
+			if beats != 2 {
+				t.Errorf("Wrote %d heartbeats in 2 cycles, want 2", beats)
+			}
+		})
+	}
+}
+
+func TestHeartbeatDisabled(t *testing.T) {
+	_, s := useFakes(t)
+
+	runCycle(testConfig(t, ""), map[string]time.Time{})
+
+	for _, point := range s.points {
+		if point.Name() == "sensor_heartbeat" {
+			t.Fatalf("Wrote a heartbeat without it being enabled")
+		}
+	}
+}
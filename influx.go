@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	"github.com/influxdata/influxdb-client-go/v2/domain"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var influxReconnections = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "weather_sensor_influxdb_reconnections_total",
+	Help: "Number of times the InfluxDB client was recreated after losing its connection, by host.",
+}, []string{"host"})
+
+// Times a write is retried on a fresh client after losing the connection
+const reconnectAttempts = 3
+
+// influxClient is one connection to InfluxDB, closed once a reconnect has
+// replaced it and nobody is using it any more
+type influxClient struct {
+	influxdb2.Client
+	writer api.WriteAPIBlocking
+	users sync.WaitGroup
+}
+
+// influxSink writes points to InfluxDB over HTTP
+type influxSink struct {
+	cfg InfluxInstanceConfig
+	hostname string
+	// Holds a token for every write in flight, nil without a limit
+	inFlight chan struct{}
+
+	// Replaced whenever the connection is lost
+	mutex sync.Mutex
+	client *influxClient
+}
+
+func newInfluxSink(cfg InfluxInstanceConfig) *influxSink {
+	s := &influxSink{cfg: cfg, hostname: cfg.Hostname}
+	s.connect(nil)
+
+	if cfg.MaxInFlight > 0 {
+		s.inFlight = make(chan struct{}, cfg.MaxInFlight)
+	}
+
+	return s
+}
+
+// connect replaces the client that lost the connection with a new one. It
+// reports false if another write already replaced it.
+func (s *influxSink) connect(lost *influxClient) bool {
+	s.mutex.Lock()
+
+	if s.client != lost {
+		s.mutex.Unlock()
+		return false
+	}
+
+	client := influxdb2.NewClientWithOptions(s.cfg.Hostname, s.cfg.Token, influxdb2.DefaultOptions().SetBatchSize(20).SetUseGZip(s.cfg.UseGZip))
+	s.client = &influxClient{Client: client, writer: client.WriteAPIBlocking(s.cfg.Org, s.cfg.Bucket)}
+	s.mutex.Unlock()
+
+	// Writes and health checks may still be using the old client
+	if lost != nil {
+		go func() {
+			lost.users.Wait()
+			lost.Close()
+		}()
+	}
+
+	return true
+}
+
+// current returns the client along with a function to call once done with it
+func (s *influxSink) current() (*influxClient, func()) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.client.users.Add(1)
+
+	return s.client, s.client.users.Done
+}
+
+// Write writes the points, recreating the client and trying again with a
+// backoff if the connection was lost
+func (s *influxSink) Write(ctx context.Context, points []*write.Point) error {
+	// A slow instance is only given as many writes at once as it can take
+	if s.inFlight != nil {
+		select {
+		case s.inFlight <- struct{}{}:
+			defer func() { <-s.inFlight }()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	backoff := time.Second
+
+	for attempt := 0; ; attempt++ {
+		client, release := s.current()
+		err := client.writer.WritePoint(ctx, points...)
+
+		if err == nil || attempt >= reconnectAttempts || ctx.Err() != nil {
+			release()
+			return err
+		}
+
+		// The write error doesn't tell whether InfluxDB answered at all, any
+		// answer to a health check means the connection is fine
+		_, herr := client.Health(ctx)
+		release()
+
+		if herr == nil {
+			return err
+		}
+
+		log.Printf("Lost the connection to InfluxDB at %s, reconnecting in %v (%d/%d): %v", s.hostname, backoff, attempt + 1, reconnectAttempts, err)
+
+		select {
+		case <-clock.After(backoff):
+		case <-ctx.Done():
+			return err
+		}
+
+		backoff *= 2
+
+		if s.connect(client) {
+			influxReconnections.WithLabelValues(s.hostname).Inc()
+		}
+	}
+}
+
+func (s *influxSink) Health(ctx context.Context) error {
+	client, release := s.current()
+	defer release()
+
+	health, err := client.Health(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if health.Status != domain.HealthCheckStatusPass {
+		return fmt.Errorf("InfluxDB reports status '%s'", health.Status)
+	}
+
+	return nil
+}
+
+func (s *influxSink) String() string {
+	return "InfluxDB at " + s.hostname
+}
+
+func (s *influxSink) Close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.client.Close()
+}
+
+// waitForInfluxSinks waits for the InfluxDB instances behind a sink. With
+// several instances it's enough for one of them to be reachable.
+func waitForInfluxSinks(s Sink, retries int) error {
+	sinks := sinkList(s)
+
+	var errs []error
+
+	for _, s := range sinks {
+		if is, ok := s.(*influxSink); ok {
+			client, release := is.current()
+			err := waitForInflux(client, retries)
+			release()
+
+			if err != nil {
+				log.Printf("Giving up on InfluxDB at %s: %v", is.hostname, err)
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	if len(errs) > 0 && len(errs) == len(sinks) {
+		return errs[0]
+	}
+
+	return nil
+}
+
+// waitForInflux checks that InfluxDB is reachable, retrying with an
+// exponential backoff so the sensor can start alongside the database
+func waitForInflux(client influxdb2.Client, retries int) error {
+	backoff := time.Second
+
+	for attempt := 0; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10 * time.Second)
+		_, err := client.Health(ctx)
+		cancel()
+
+		if err == nil {
+			return nil
+		}
+
+		if attempt >= retries {
+			return fmt.Errorf("InfluxDB still unreachable after %d retries: %v", retries, err)
+		}
+
+		log.Printf("Waiting %v for InfluxDB to come online (%d/%d): %v", backoff, attempt + 1, retries, err)
+		clock.Sleep(backoff)
+
+		if backoff *= 2; backoff > 30 * time.Second {
+			backoff = 30 * time.Second
+		}
+	}
+}
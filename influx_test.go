@@ -0,0 +1,390 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+// slowInflux answers writes after a delay, keeping track of how many it was
+// handling at once
+type slowInflux struct {
+	mutex sync.Mutex
+	inFlight int
+	peak int
+	writes int
+}
+
+func (s *slowInflux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	s.inFlight++
+	s.writes++
+
+	if s.inFlight > s.peak {
+		s.peak = s.inFlight
+	}
+
+	s.mutex.Unlock()
+
+	time.Sleep(50 * time.Millisecond)
+
+	s.mutex.Lock()
+	s.inFlight--
+	s.mutex.Unlock()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func TestInfluxSinkMaxInFlight(t *testing.T) {
+	tests := []struct {
+		maxInFlight int
+		// Most writes InfluxDB may see at once
+		wantPeak int
+	}{
+		{0, 6},
+		{1, 1},
+		{2, 2},
+	}
+
+	for _, test := range tests {
+		server := &slowInflux{}
+		ts := httptest.NewServer(server)
+
+		s := newInfluxSink(InfluxInstanceConfig{Hostname: ts.URL, Org: "org", Bucket: "bucket", MaxInFlight: test.maxInFlight})
+
+		var wg sync.WaitGroup
+
+		for i := 0; i < 6; i++ {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				p := influxdb2.NewPointWithMeasurement("weather").AddField("temperature", 20.5)
+
+				if err := s.Write(context.Background(), []*write.Point{p}); err != nil {
+					t.Errorf("Error writing: %v", err)
+				}
+			}()
+		}
+
+		wg.Wait()
+		s.Close()
+		ts.Close()
+
+		if server.writes != 6 {
+			t.Errorf("max_in_flight %d: InfluxDB got %d writes, want 6", test.maxInFlight, server.writes)
+		}
+
+		if server.peak > test.wantPeak {
+			t.Errorf("max_in_flight %d: InfluxDB handled %d writes at once, want at most %d", test.maxInFlight, server.peak, test.wantPeak)
+		}
+
+		// Writes do overlap without a limit, so the limit is what held them back
+		if test.maxInFlight == 0 && server.peak < 2 {
+			t.Errorf("Without max_in_flight InfluxDB only handled %d write at once", server.peak)
+		}
+	}
+}
+
+func TestInfluxSinkMaxInFlightGivesUpOnCancel(t *testing.T) {
+	s := newInfluxSink(InfluxInstanceConfig{Hostname: "http://127.0.0.1:1", MaxInFlight: 1})
+	defer s.Close()
+
+	// The only slot is taken by a write that never finishes
+	s.inFlight <- struct{}{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10 * time.Millisecond)
+	defer cancel()
+
+	p := influxdb2.NewPointWithMeasurement("weather").AddField("temperature", 20.5)
+
+	if err := s.Write(ctx, []*write.Point{p}); err != context.DeadlineExceeded {
+		t.Errorf("Waiting for a slot returned %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestNewInfluxDBSinkMaxInFlight(t *testing.T) {
+	cfg := InfluxDBConfig{
+		MaxInFlight: 4,
+		Instances: []InfluxInstanceConfig{
+			{Hostname: "http://influx-a:8086/"},
+			{Hostname: "http://influx-b:8086/", MaxInFlight: 1},
+		},
+	}
+
+	s, err := newInfluxDBSink(cfg)
+
+	if err != nil {
+		t.Fatalf("Error creating the sink: %v", err)
+	}
+
+	defer s.Close()
+
+	for i, want := range []int{4, 1} {
+		is := sinkList(s)[i].(*influxSink)
+
+		if got := cap(is.inFlight); got != want {
+			t.Errorf("Instance %d takes %d writes at once, want %d", i + 1, got, want)
+		}
+	}
+}
+
+func TestInfluxSinkReconnectGivesUpOnCancel(t *testing.T) {
+	c := newFakeClock()
+	previous := clock
+	clock = c
+	defer func() { clock = previous }()
+
+	// Nothing listens there, so writes and health checks both fail
+	s := newInfluxSink(InfluxInstanceConfig{Hostname: "http://127.0.0.1:1", Org: "org", Bucket: "bucket"})
+	defer s.Close()
+
+	lost, release := s.current()
+	release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+
+	go func() {
+		p := influxdb2.NewPointWithMeasurement("weather").AddField("temperature", 20.5)
+		done <- s.Write(ctx, []*write.Point{p})
+	}()
+
+	// Cancelled while waiting to reconnect, which the clock never lets happen
+	c.waitForTimers(t, 1)
+	cancel()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("Write returned no error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Write kept waiting to reconnect after being cancelled")
+	}
+
+	client, release := s.current()
+	release()
+
+	if client != lost {
+		t.Errorf("Reconnected after being cancelled")
+	}
+}
+
+// closeRecorder notes when the client is closed
+type closeRecorder struct {
+	influxdb2.Client
+	closed int32
+}
+
+func (c *closeRecorder) Close() {
+	atomic.StoreInt32(&c.closed, 1)
+	c.Client.Close()
+}
+
+func TestInfluxSinkConnectClosesUnusedClient(t *testing.T) {
+	s := newInfluxSink(InfluxInstanceConfig{Hostname: "http://127.0.0.1:1", Org: "org", Bucket: "bucket"})
+	defer s.Close()
+
+	// A write or health check still using the client that lost the connection
+	lost, release := s.current()
+	recorder := &closeRecorder{Client: lost.Client}
+	lost.Client = recorder
+
+	if !s.connect(lost) {
+		t.Fatalf("Didn't reconnect")
+	}
+
+	// Other writes that saw the same client fail don't reconnect again
+	if s.connect(lost) {
+		t.Errorf("Reconnected twice for the same lost client")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	if atomic.LoadInt32(&recorder.closed) != 0 {
+		t.Fatalf("Closed the old client while it was still in use")
+	}
+
+	release()
+
+	for deadline := time.Now().Add(5 * time.Second); atomic.LoadInt32(&recorder.closed) == 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Old client wasn't closed once unused")
+		}
+	}
+}
+
+func TestInfluxSinkHealth(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	tests := []struct {
+		name string
+		status int
+		body string
+		wantErr bool
+	}{
+		{"passing", http.StatusOK, `{"name": "influxdb", "status": "pass"}`, false},
+		{"failing", http.StatusServiceUnavailable, `{"name": "influxdb", "status": "fail", "message": "not ready"}`, true},
+		// Nothing listening
+		{"unreachable", 0, "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hostname := down.URL
+
+			if test.status != 0 {
+				server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(test.status)
+					w.Write([]byte(test.body))
+				}))
+				defer server.Close()
+
+				hostname = server.URL
+			}
+
+			s := newInfluxSink(InfluxInstanceConfig{Hostname: hostname})
+			defer s.Close()
+
+			if err := s.Health(context.Background()); (err != nil) != test.wantErr {
+				t.Errorf("Health returned %v, want an error: %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestWaitForInflux(t *testing.T) {
+	tests := []struct {
+		name string
+		// Health checks failing before InfluxDB is up
+		failures int32
+		retries int
+		wantErr bool
+		wantChecks int32
+	}{
+		{"up already", 0, 3, false, 1},
+		{"coming up", 2, 3, false, 3},
+		{"up on the last retry", 3, 3, false, 4},
+		{"never up", 5, 3, true, 4},
+		{"no retries", 1, 0, true, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := useSelfAdvancingClock(t)
+			start := c.Now()
+
+			var checks int32
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt32(&checks, 1) <= test.failures {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"name": "influxdb", "status": "pass"}`))
+			}))
+			defer server.Close()
+
+			client := influxdb2.NewClient(server.URL, "")
+			defer client.Close()
+
+			if err := waitForInflux(client, test.retries); (err != nil) != test.wantErr {
+				t.Errorf("waitForInflux returned %v, want an error: %v", err, test.wantErr)
+			}
+
+			if got := atomic.LoadInt32(&checks); got != test.wantChecks {
+				t.Errorf("Checked InfluxDB %d times, want %d", got, test.wantChecks)
+			}
+
+			// Backing off 1s, 2s, 4s... between checks
+			if got, want := c.Now().Sub(start), time.Duration(1 << (test.wantChecks - 1) - 1) * time.Second; got != want {
+				t.Errorf("Waited %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestInfluxSinkGZip(t *testing.T) {
+	tests := []struct {
+		name string
+		gzip bool
+		// Whether the instance is configured in [[influxdb.instances]]
+		instances bool
+	}{
+		{"plain", false, false},
+		{"gzipped", true, false},
+		{"gzipped instances", true, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var encoding, body string
+
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				encoding = r.Header.Get("Content-Encoding")
+				in := io.Reader(r.Body)
+
+				if encoding == "gzip" {
+					zr, err := gzip.NewReader(r.Body)
+
+					if err != nil {
+						t.Errorf("Body isn't gzipped: %v", err)
+						return
+					}
+
+					in = zr
+				}
+
+				b, _ := io.ReadAll(in)
+				body = string(b)
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			defer ts.Close()
+
+			config := fmt.Sprintf("[influxdb]\nhostname = \"%s\"\nuse_gzip = %v\n", ts.URL, test.gzip)
+
+			if test.instances {
+				config += "[[influxdb.instances]]\nhostname = \"" + ts.URL + "\"\n"
+			}
+
+			s, err := newInfluxDBSink(testConfig(t, config).InfluxDB)
+
+			if err != nil {
+				t.Fatalf("Error creating the sink: %v", err)
+			}
+
+			defer s.Close()
+
+			p := influxdb2.NewPointWithMeasurement("weather").AddTag("location", "Lisbon").AddField("temperature", 18.5)
+
+			if err := s.Write(context.Background(), []*write.Point{p}); err != nil {
+				t.Fatalf("Write returned %v", err)
+			}
+
+			if (encoding == "gzip") != test.gzip {
+				t.Errorf("Sent Content-Encoding '%s', want gzip: %v", encoding, test.gzip)
+			}
+
+			if !strings.HasPrefix(body, "weather,location=Lisbon temperature=18.5") {
+				t.Errorf("InfluxDB got '%s'", body)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	"github.com/segmentio/kafka-go"
+)
+
+// kafkaSink publishes every point as a JSON message keyed by its location,
+// so a location's readings stay in order on one partition
+type kafkaSink struct {
+	cfg KafkaConfig
+	writer *kafka.Writer
+}
+
+// Message published for each point
+type kafkaMessage struct {
+	Measurement string `json:"measurement"`
+	Tags map[string]string `json:"tags"`
+	Fields map[string]interface{} `json:"fields"`
+	Time time.Time `json:"time"`
+}
+
+func newKafkaSink(cfg KafkaConfig) *kafkaSink {
+	writer := &kafka.Writer{
+		Addr: kafka.TCP(cfg.Brokers...),
+		Topic: cfg.Topic,
+		Balancer: &kafka.Hash{},
+		// Writes only return once every in-sync replica has the messages, or
+		// with the error that kept them from it
+		RequiredAcks: kafka.RequireAll,
+		// Points come in per location, there's nothing to gain from waiting
+		// for a fuller batch
+		BatchTimeout: 10 * time.Millisecond,
+	}
+
+	return &kafkaSink{cfg: cfg, writer: writer}
+}
+
+// Write publishes the points. Lost broker connections are re-established by
+// the writer, which retries the messages a few times before giving up.
+func (s *kafkaSink) Write(ctx context.Context, points []*write.Point) error {
+	messages, err := kafkaMessages(points)
+
+	if err != nil {
+		return err
+	}
+
+	return s.writer.WriteMessages(ctx, messages...)
+}
+
+// kafkaMessages turns points into the messages published for them
+func kafkaMessages(points []*write.Point) ([]kafka.Message, error) {
+	var messages []kafka.Message
+
+	for _, p := range points {
+		m := kafkaMessage{
+			Measurement: p.Name(),
+			Tags: map[string]string{},
+			Fields: map[string]interface{}{},
+			Time: p.Time(),
+		}
+
+		// Unstamped points are stamped on arrival by InfluxDB, consumers
+		// here get the time they were published at instead
+		if m.Time.IsZero() {
+			m.Time = clock.Now()
+		}
+
+		for _, t := range p.TagList() {
+			m.Tags[t.Key] = t.Value
+		}
+
+		for _, f := range p.FieldList() {
+			m.Fields[f.Key] = f.Value
+		}
+
+		value, err := json.Marshal(m)
+
+		if err != nil {
+			return nil, err
+		}
+
+		messages = append(messages, kafka.Message{Key: []byte(m.Tags["location"]), Value: value})
+	}
+
+	return messages, nil
+}
+
+// Health checks that one of the brokers accepts connections
+func (s *kafkaSink) Health(ctx context.Context) error {
+	var err error
+
+	for _, broker := range s.cfg.Brokers {
+		var conn *kafka.Conn
+
+		if conn, err = kafka.DialContext(ctx, "tcp", broker); err == nil {
+			conn.Close()
+			return nil
+		}
+	}
+
+	return err
+}
+
+func (s *kafkaSink) String() string {
+	return "Kafka topic '" + s.cfg.Topic + "' at " + strings.Join(s.cfg.Brokers, ",")
+}
+
+func (s *kafkaSink) Close() {
+	s.writer.Close()
+}
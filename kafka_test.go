@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+func TestKafkaMessages(t *testing.T) {
+	c := useSelfAdvancingClock(t)
+	at := time.Date(2022, 6, 1, 11, 55, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		point *write.Point
+		wantKey string
+		want kafkaMessage
+	}{
+		{
+			"stamped",
+			write.NewPoint("weather", map[string]string{"location": "Lisbon", "country": "PT"}, map[string]interface{}{"temperature": 18.5, "condition": "Rain"}, at),
+			"Lisbon",
+			kafkaMessage{"weather", map[string]string{"location": "Lisbon", "country": "PT"}, map[string]interface{}{"temperature": 18.5, "condition": "Rain"}, at},
+		},
+		{
+			"unstamped",
+			write.NewPointWithMeasurement("sensor_heartbeat").AddField("cycles", int64(3)),
+			"",
+			kafkaMessage{"sensor_heartbeat", map[string]string{}, map[string]interface{}{"cycles": 3.0}, c.Now()},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			messages, err := kafkaMessages([]*write.Point{test.point})
+
+			if err != nil || len(messages) != 1 {
+				t.Fatalf("Got %d messages and %v, want one", len(messages), err)
+			}
+
+			if got := string(messages[0].Key); got != test.wantKey {
+				t.Errorf("Keyed '%s', want '%s'", got, test.wantKey)
+			}
+
+			var got kafkaMessage
+
+			if err := json.Unmarshal(messages[0].Value, &got); err != nil {
+				t.Fatalf("Message isn't JSON: %v", err)
+			}
+
+			if !got.Time.Equal(test.want.Time) {
+				t.Errorf("Timed %v, want %v", got.Time, test.want.Time)
+			}
+
+			got.Time = test.want.Time
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestKafkaHealth(t *testing.T) {
+	up, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer up.Close()
+
+	down, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing listens on it anymore
+	down.Close()
+
+	tests := []struct {
+		name string
+		brokers []string
+		wantErr bool
+	}{
+		{"broker up", []string{up.Addr().String()}, false},
+		{"broker down", []string{down.Addr().String()}, true},
+		{"one of two up", []string{down.Addr().String(), up.Addr().String()}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newKafkaSink(KafkaConfig{Brokers: test.brokers, Topic: "weather"})
+			defer s.Close()
+
+			if err := s.Health(context.Background()); (err != nil) != test.wantErr {
+				t.Errorf("Health returned %v, want an error: %v", err, test.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	lp "github.com/influxdata/line-protocol"
+)
+
+// tagValue drops the trailing backslashes of a tag value. Neither the
+// InfluxDB client nor the line protocol encoder escape backslashes, so one
+// at the end would escape the separator after the tag and break the line.
+// Points are built with it so every sink gets the same tags.
+func tagValue(value string) string {
+	return strings.TrimRight(value, `\`)
+}
+
+// lineProtocol encodes points one per line the same way the InfluxDB client
+// does over HTTP. Unlike write.PointToLineProtocol that leaves out tags with
+// empty values instead of producing lines InfluxDB rejects, and escapes
+// special characters in tag and field values consistently.
+func lineProtocol(points []*write.Point, precision time.Duration) (string, error) {
+	var buf bytes.Buffer
+
+	e := lp.NewEncoder(&buf)
+	e.SetFieldTypeSupport(lp.UintSupport)
+	e.FailOnFieldErr(true)
+	e.SetPrecision(precision)
+
+	for _, p := range points {
+		if _, err := e.Encode(p); err != nil {
+			return "", err
+		}
+	}
+
+	return buf.String(), nil
+}
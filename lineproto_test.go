@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+func TestLineProtocol(t *testing.T) {
+	at := time.Unix(1654084800, 0)
+
+	tests := []struct {
+		name string
+		point *write.Point
+		precision time.Duration
+		want string
+	}{
+		{
+			"fields of every type",
+			write.NewPointWithMeasurement("weather").AddTag("location", "Lisbon").AddField("temperature", 18.5).AddField("humidity", int64(70)).AddField("clouds", uint64(40)).AddField("description", "light rain").AddField("raining", true).SetTime(at),
+			time.Second,
+			"weather,location=Lisbon temperature=18.5,humidity=70i,clouds=40u,description=\"light rain\",raining=true 1654084800\n",
+		},
+		{
+			"special characters",
+			write.NewPointWithMeasurement("weather data").AddTag("city", "Rio de Janeiro, BR").AddTag("x=y", "a=b").AddField("description", `it said "rain"`).SetTime(at),
+			time.Second,
+			"weather\\ data,city=Rio\\ de\\ Janeiro\\,\\ BR,x\\=y=a\\=b description=\"it said \\\"rain\\\"\" 1654084800\n",
+		},
+		{
+			"empty tag",
+			write.NewPointWithMeasurement("weather").AddTag("country", "").AddTag("location", "Lisbon").AddField("temperature", 18.5).SetTime(at),
+			time.Second,
+			"weather,location=Lisbon temperature=18.5 1654084800\n",
+		},
+		{
+			"nanoseconds",
+			write.NewPointWithMeasurement("weather").AddField("temperature", 18.5).SetTime(at),
+			time.Nanosecond,
+			"weather temperature=18.5 1654084800000000000\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := lineProtocol([]*write.Point{test.point}, test.precision)
+
+			if err != nil {
+				t.Fatalf("lineProtocol returned %v", err)
+			}
+
+			if got != test.want {
+				t.Errorf("Encoded as\n%s\nwant\n%s", got, test.want)
+			}
+		})
+	}
+}
+
+// influxRecorder keeps the bodies of the writes it gets
+type influxRecorder struct {
+	mutex sync.Mutex
+	bodies []string
+}
+
+func (r *influxRecorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+
+	r.mutex.Lock()
+	r.bodies = append(r.bodies, string(body))
+	r.mutex.Unlock()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func TestAdversarialTags(t *testing.T) {
+	tests := []struct {
+		name string
+		location string
+		city string
+		// Tags of the line, whichever sink it's written through
+		want string
+	}{
+		{"spaces and commas", "New York, NY", "New York", `weather,location=New\ York\,\ NY,city=New\ York,country=PT `},
+		{"equals signs", "a=b", "a=b", `weather,location=a\=b,city=a\=b,country=PT `},
+		{"trailing backslash", `Lisbon\`, `Lisbon\\`, `weather,location=Lisbon,city=Lisbon,country=PT `},
+		{"backslash inside", `C:\Lisbon`, "Lisbon", `weather,location=C:\Lisbon,city=Lisbon,country=PT `},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := testConfig(t, "")
+			weather := testReading(test.city)
+			weather.Timestamp = 1654084800
+
+			points := weatherPoints(cfg, weather, test.location)
+
+			encoded, err := lineProtocol(points, time.Nanosecond)
+
+			if err != nil {
+				t.Fatalf("lineProtocol returned %v", err)
+			}
+
+			server := &influxRecorder{}
+			ts := httptest.NewServer(server)
+			defer ts.Close()
+
+			s := newInfluxSink(InfluxInstanceConfig{Hostname: ts.URL, Org: "org", Bucket: "bucket"})
+			defer s.Close()
+
+			if err := s.Write(context.Background(), points); err != nil {
+				t.Fatalf("Error writing over HTTP: %v", err)
+			}
+
+			if len(server.bodies) != 1 {
+				t.Fatalf("InfluxDB got %d writes, want 1", len(server.bodies))
+			}
+
+			for name, line := range map[string]string{"line protocol": encoded, "HTTP": server.bodies[0]} {
+				if !strings.HasPrefix(line, test.want) {
+					t.Errorf("Wrote over %s:\n%s\nwant the tags %s", name, line, test.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Location struct {
+	// Name sent to the API as the query and, unless aliased, used as the
+	// location tag
+	Name string
+	// Stable tag value, independent of what the API calls the location
+	Alias string
+	// Coordinates take precedence over the name when querying, if set
+	HasCoordinates bool
+	Latitude float64
+	Longitude float64
+	// How often to fetch this location, zero means every cycle
+	Interval time.Duration
+	// Number of nearby stations to fetch instead of a single reading
+	Nearby int
+	// Postal code and country the coordinates are looked up from
+	Zip string
+	// Time zone of the location, the offset reported by the API if nil
+	TimeZone *time.Location
+}
+
+// Tag is the value the location is tagged and logged with
+func (l Location) Tag() string {
+	if l.Alias != "" {
+		return l.Alias
+	}
+
+	return l.Name
+}
+
+// parseCoordinates reads a "lat,lon" query
+func parseCoordinates(s string) (float64, float64, bool) {
+	parts := strings.Split(s, ",")
+
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+
+	lat, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+
+	if err != nil || lat < -90 || lat > 90 {
+		return 0, 0, false
+	}
+
+	lon, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+
+	if err != nil || lon < -180 || lon > 180 {
+		return 0, 0, false
+	}
+
+	return lat, lon, true
+}
+
+// loadLocations gathers the inline locations, the [[weather_api.location]]
+// tables, the points of every [[weather_api.grid]] and those in the
+// locations file, if one is configured
+func loadLocations(cfg WeatherAPIConfig) ([]Location, error) {
+	var locations []Location
+
+	for _, name := range cfg.Locations {
+		locations = append(locations, Location{Name: name})
+	}
+
+	for i, c := range cfg.Location {
+		location := Location{
+			Name: c.Name,
+			Alias: c.Alias,
+			Interval: time.Duration(c.Interval) * time.Second,
+			Nearby: c.Nearby,
+		}
+
+		if c.Query != "" {
+			if c.Name != "" {
+				return nil, fmt.Errorf("Location %d has both a name and a query", i + 1)
+			}
+
+			location.Name = c.Query
+			location.Latitude, location.Longitude, location.HasCoordinates = parseCoordinates(c.Query)
+		}
+
+		if c.Zip != "" {
+			if location.Name != "" {
+				return nil, fmt.Errorf("Location %d has both a name and a zip code", i + 1)
+			}
+
+			if !zipPattern.MatchString(c.Zip) {
+				return nil, fmt.Errorf("Zip code '%s' needs a country code, e.g. \"10001,US\"", c.Zip)
+			}
+
+			location.Name = c.Zip
+			location.Zip = c.Zip
+		}
+
+		if c.Display != "" {
+			if c.Alias != "" {
+				return nil, fmt.Errorf("Location '%s' has both an alias and a display name", location.Name)
+			}
+
+			location.Alias = c.Display
+		}
+
+		if location.Name == "" {
+			return nil, fmt.Errorf("Location %d has no name", i + 1)
+		}
+
+		if c.TimeZone != "" {
+			zone, err := time.LoadLocation(c.TimeZone)
+
+			if err != nil {
+				return nil, fmt.Errorf("Location '%s' has an unknown time zone: %v", location.Name, err)
+			}
+
+			location.TimeZone = zone
+		}
+
+		if location.Nearby < 0 || location.Nearby > 50 {
+			return nil, fmt.Errorf("Location '%s' asks for %d nearby stations, at most 50 are supported", location.Name, location.Nearby)
+		}
+
+		if c.Latitude != nil && c.Longitude != nil {
+			location.HasCoordinates = true
+			location.Latitude = *c.Latitude
+			location.Longitude = *c.Longitude
+		}
+
+		locations = append(locations, location)
+	}
+
+	for _, grid := range cfg.Grid {
+		points, err := expandGrid(grid)
+
+		if err != nil {
+			return nil, err
+		}
+
+		locations = append(locations, points...)
+	}
+
+	if path := cfg.LocationsFile; path != "" {
+		f, err := os.Open(path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		defer f.Close()
+
+		var fromFile []Location
+
+		if strings.EqualFold(filepath.Ext(path), ".csv") {
+			fromFile, err = parseLocationsCSV(f)
+		} else {
+			fromFile, err = parseLocationsList(f)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing %s: %v", path, err)
+		}
+
+		locations = append(locations, fromFile...)
+	}
+
+	return locations, nil
+}
+
+// parseLocationsList reads one location per line, ignoring blank lines and
+// lines starting with #
+func parseLocationsList(r io.Reader) ([]Location, error) {
+	var locations []Location
+
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		locations = append(locations, Location{Name: line})
+	}
+
+	return locations, scanner.Err()
+}
+
+// parseLocationsCSV reads rows of name,latitude,longitude,interval,alias
+// where everything but the name is optional. Names containing commas must be
+// quoted. A leading header row starting with "name" is skipped.
+func parseLocationsCSV(r io.Reader) ([]Location, error) {
+	var locations []Location
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+	reader.TrimLeadingSpace = true
+
+	records, err := reader.ReadAll()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for i, record := range records {
+		if i == 0 && strings.EqualFold(record[0], "name") {
+			continue
+		}
+
+		location := Location{Name: strings.TrimSpace(record[0])}
+
+		if location.Name == "" {
+			return nil, fmt.Errorf("Row %d has no location name", i + 1)
+		}
+
+		if len(record) > 2 && record[1] != "" && record[2] != "" {
+			location.Latitude, err = strconv.ParseFloat(record[1], 64)
+
+			if err != nil || location.Latitude < -90 || location.Latitude > 90 {
+				return nil, fmt.Errorf("Row %d has an invalid latitude '%s'", i + 1, record[1])
+			}
+
+			location.Longitude, err = strconv.ParseFloat(record[2], 64)
+
+			if err != nil || location.Longitude < -180 || location.Longitude > 180 {
+				return nil, fmt.Errorf("Row %d has an invalid longitude '%s'", i + 1, record[2])
+			}
+
+			location.HasCoordinates = true
+		}
+
+		if len(record) > 3 && record[3] != "" {
+			seconds, err := strconv.Atoi(record[3])
+
+			if err != nil || seconds < 0 {
+				return nil, fmt.Errorf("Row %d has an invalid interval '%s'", i + 1, record[3])
+			}
+
+			location.Interval = time.Duration(seconds) * time.Second
+		}
+
+		if len(record) > 4 {
+			location.Alias = strings.TrimSpace(record[4])
+		}
+
+		locations = append(locations, location)
+	}
+
+	return locations, nil
+}
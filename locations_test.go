@@ -0,0 +1,143 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLocationsCSV(t *testing.T) {
+	tests := []struct {
+		name string
+		csv string
+		want []Location
+		wantErr string
+	}{
+		{"names only", "Lisbon\nPorto\n", []Location{{Name: "Lisbon"}, {Name: "Porto"}}, ""},
+		{"header", "name,latitude,longitude,interval,alias\nLisbon\n", []Location{{Name: "Lisbon"}}, ""},
+		{"every column", "Lisbon, 38.7, -9.1, 600, lisbon-home\n", []Location{{Name: "Lisbon", HasCoordinates: true, Latitude: 38.7, Longitude: -9.1, Interval: 10 * time.Minute, Alias: "lisbon-home"}}, ""},
+		{"empty columns", "Lisbon,,,,lisbon-home\n", []Location{{Name: "Lisbon", Alias: "lisbon-home"}}, ""},
+		{"only a latitude", "Lisbon,38.7\n", []Location{{Name: "Lisbon"}}, ""},
+		{"quoted name with a comma", "\"Lisbon,PT\",38.7,-9.1\n", []Location{{Name: "Lisbon,PT", HasCoordinates: true, Latitude: 38.7, Longitude: -9.1}}, ""},
+		{"comments", "# home\nLisbon\n", []Location{{Name: "Lisbon"}}, ""},
+		{"no name", "Lisbon\n,38.7,-9.1\n", nil, "Row 2 has no location name"},
+		{"bad latitude", "Lisbon,north,-9.1\n", nil, "Row 1 has an invalid latitude"},
+		{"latitude out of range", "Lisbon,138.7,-9.1\n", nil, "Row 1 has an invalid latitude"},
+		{"bad longitude", "Lisbon,38.7,west\n", nil, "Row 1 has an invalid longitude"},
+		{"longitude out of range", "Lisbon,38.7,-189.1\n", nil, "Row 1 has an invalid longitude"},
+		{"bad interval", "Lisbon,,,10m\n", nil, "Row 1 has an invalid interval"},
+		{"negative interval", "Lisbon,,,-600\n", nil, "Row 1 has an invalid interval"},
+		{"unterminated quote", "\"Lisbon\n", nil, "quote"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseLocationsCSV(strings.NewReader(test.csv))
+
+			if test.wantErr == "" && err != nil || test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Fatalf("parseLocationsCSV returned %v, want an error with '%s'", err, test.wantErr)
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("parseLocationsCSV returned %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseLocationsList(t *testing.T) {
+	got, err := parseLocationsList(strings.NewReader("Lisbon\n\n  # home\n  Porto \n"))
+
+	if err != nil {
+		t.Fatalf("parseLocationsList returned %v", err)
+	}
+
+	if want := []Location{{Name: "Lisbon"}, {Name: "Porto"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("parseLocationsList returned %+v, want %+v", got, want)
+	}
+}
+
+func TestLoadLocationsFile(t *testing.T) {
+	tests := []struct {
+		file string
+		contents string
+		want []string
+		wantErr string
+	}{
+		{"locations.txt", "Porto\n", []string{"Lisbon", "Porto"}, ""},
+		{"locations.csv", "name\nPorto,41.1,-8.6\n", []string{"Lisbon", "Porto"}, ""},
+		{"locations.CSV", "Porto,north,-8.6\n", nil, "Error parsing"},
+		{"missing.txt", "", nil, "no such file"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.file, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), test.file)
+
+			if test.contents != "" {
+				if err := os.WriteFile(path, []byte(test.contents), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			locations, err := loadLocations(WeatherAPIConfig{Locations: []string{"Lisbon"}, LocationsFile: path})
+
+			if test.wantErr == "" && err != nil || test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Fatalf("loadLocations returned %v, want an error with '%s'", err, test.wantErr)
+			}
+
+			var got []string
+
+			for _, location := range locations {
+				got = append(got, location.Tag())
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Loaded locations %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestLoadLocationsQueryAndDisplay(t *testing.T) {
+	tests := []struct {
+		name string
+		location LocationConfig
+		want Location
+		wantTag string
+		wantErr string
+	}{
+		{"query and display name", LocationConfig{Query: "Lisboa,PT", Display: "Lisbon"}, Location{Name: "Lisboa,PT", Alias: "Lisbon"}, "Lisbon", ""},
+		{"query only", LocationConfig{Query: "Lisboa,PT"}, Location{Name: "Lisboa,PT"}, "Lisboa,PT", ""},
+		{"coordinates query", LocationConfig{Query: "38.7,-9.1", Display: "Lisbon"}, Location{Name: "38.7,-9.1", Alias: "Lisbon", HasCoordinates: true, Latitude: 38.7, Longitude: -9.1}, "Lisbon", ""},
+		{"name and display name", LocationConfig{Name: "Lisbon", Display: "Home"}, Location{Name: "Lisbon", Alias: "Home"}, "Home", ""},
+		{"name and query", LocationConfig{Name: "Lisbon", Query: "Lisboa,PT"}, Location{}, "", "has both a name and a query"},
+		{"alias and display name", LocationConfig{Query: "Lisboa,PT", Alias: "lisbon", Display: "Lisbon"}, Location{}, "", "has both an alias and a display name"},
+		{"neither name nor query", LocationConfig{Display: "Lisbon"}, Location{}, "", "has no name"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := loadLocations(WeatherAPIConfig{Location: []LocationConfig{test.location}})
+
+			if test.wantErr == "" && err != nil || test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Fatalf("loadLocations returned %v, want an error with '%s'", err, test.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if len(got) != 1 || !reflect.DeepEqual(got[0], test.want) {
+				t.Fatalf("loadLocations returned %+v, want %+v", got, test.want)
+			}
+
+			if tag := got[0].Tag(); tag != test.wantTag {
+				t.Errorf("Tagged '%s', want '%s'", tag, test.wantTag)
+			}
+		})
+	}
+}
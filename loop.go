@@ -0,0 +1,115 @@
+package main
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+// loop runs cycles on the sensor interval until told to stop, handling
+// reloads and pauses in between
+type loop struct {
+	clock Clock
+	// Runs a single cycle, runCycle outside of tests
+	cycle func(cfg *Config)
+	// Returns the config to carry on with after a SIGHUP
+	reload func(cfg *Config) *Config
+
+	stop <-chan os.Signal
+	hup <-chan os.Signal
+	pause <-chan os.Signal
+	giveUp <-chan int
+}
+
+// run runs the loop, returning the code to exit with and whether the exit is
+// forced because the running cycle didn't finish in time
+func (l *loop) run(cfg *Config) (int, bool) {
+	ticker := l.clock.NewTicker(time.Duration(cfg.Sensor.Interval) * time.Second)
+	defer ticker.Stop()
+
+	done := make(chan bool)
+	busy := false
+	paused := false
+	pendingReload := false
+
+	// Cycles run in the background so signals are still handled while a
+	// fetch or write is hanging
+	cycle := func() {
+		busy = true
+		cfg := cfg
+
+		go func() {
+			l.cycle(cfg)
+			beat()
+			done <- true
+		}()
+	}
+
+	// Waits for the running cycle, if any, before exiting
+	shutdown := func(code int) (int, bool) {
+		if busy {
+			timeout := time.Duration(cfg.Shutdown.TimeoutSeconds) * time.Second
+
+			select {
+			case <-done:
+			case <-l.clock.After(timeout):
+				log.Printf("Shutdown timed out after %v while processing location '%s', forcing exit", timeout, current.Load())
+				return exitFailure, true
+			}
+		}
+
+		return code, false
+	}
+
+	cycle()
+
+	for {
+		select {
+		case sig := <-l.stop:
+			log.Printf("Signal %v captured, exiting...", sig)
+			return shutdown(0)
+		case code := <-l.giveUp:
+			return shutdown(code)
+		case <-done:
+			busy = false
+
+			if pendingReload {
+				pendingReload = false
+				cfg = l.reload(cfg)
+				ticker.Reset(time.Duration(cfg.Sensor.Interval) * time.Second)
+			}
+		case <-l.hup:
+			// Reloading mid-cycle would swap the config under the running
+			// fetches, so wait for the cycle to finish
+			if busy {
+				pendingReload = true
+			} else {
+				cfg = l.reload(cfg)
+				ticker.Reset(time.Duration(cfg.Sensor.Interval) * time.Second)
+			}
+		case <-l.pause:
+			// Toggled, e.g. to hold off during API maintenance. A running
+			// cycle still finishes.
+			paused = !paused
+
+			if paused {
+				log.Printf("Collection paused, send SIGUSR1 again to resume")
+			} else {
+				log.Printf("Collection resumed, next cycle on schedule")
+			}
+		case <-ticker.C():
+			if paused {
+				// Idle on purpose, not stuck
+				beat()
+				continue
+			}
+
+			if busy {
+				log.Printf("Previous cycle still running, skipping this one")
+				continue
+			}
+
+			cycle()
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// loopHarness drives a loop on a fake clock, with cycles that only finish
+// when told to
+type loopHarness struct {
+	t *testing.T
+	clock *fakeClock
+	loop *loop
+
+	started chan *Config
+	finish chan bool
+	reloaded chan bool
+	// Config handed back on the next reload, the current one if nil
+	next *Config
+
+	stop chan os.Signal
+	hup chan os.Signal
+	pause chan os.Signal
+	giveUp chan int
+
+	exited chan loopExit
+}
+
+func newLoopHarness(t *testing.T) *loopHarness {
+	h := &loopHarness{
+		t: t,
+		clock: newFakeClock(),
+		started: make(chan *Config),
+		finish: make(chan bool),
+		reloaded: make(chan bool),
+		stop: make(chan os.Signal),
+		hup: make(chan os.Signal),
+		pause: make(chan os.Signal),
+		giveUp: make(chan int),
+		exited: make(chan loopExit, 1),
+	}
+
+	h.loop = &loop{
+		clock: h.clock,
+		cycle: func(cfg *Config) {
+			h.started <- cfg
+			<-h.finish
+		},
+		reload: func(cfg *Config) *Config {
+			if h.next != nil {
+				cfg, h.next = h.next, nil
+			}
+
+			h.reloaded <- true
+			return cfg
+		},
+		stop: h.stop,
+		hup: h.hup,
+		pause: h.pause,
+		giveUp: h.giveUp,
+	}
+
+	return h
+}
+
+type loopExit struct {
+	code int
+	forced bool
+}
+
+func loopConfig(interval int, shutdownTimeout int) *Config {
+	cfg := &Config{}
+	cfg.Sensor.Interval = interval
+	cfg.Shutdown.TimeoutSeconds = shutdownTimeout
+
+	return cfg
+}
+
+func (h *loopHarness) start(cfg *Config) {
+	go func() {
+		code, forced := h.loop.run(cfg)
+		h.exited <- loopExit{code, forced}
+	}()
+}
+
+// cycleStarted waits for the next cycle to start
+func (h *loopHarness) cycleStarted() *Config {
+	h.t.Helper()
+
+	select {
+	case cfg := <-h.started:
+		return cfg
+	case <-time.After(5 * time.Second):
+		h.t.Fatalf("No cycle started")
+		return nil
+	}
+}
+
+// noCycle checks that no cycle is running or about to start
+func (h *loopHarness) noCycle() {
+	h.t.Helper()
+
+	select {
+	case <-h.started:
+		h.t.Fatalf("Unexpected cycle started")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// settle waits for the loop to have taken in everything sent to it so far,
+// by asking for a reload, which only happens once no cycle is running
+func (h *loopHarness) settle() {
+	h.t.Helper()
+
+	h.hup <- syscall.SIGHUP
+
+	select {
+	case <-h.reloaded:
+	case <-time.After(5 * time.Second):
+		h.t.Fatalf("Loop didn't reload")
+	}
+
+	// Only taken once the loop is done with the reload, toggled twice to
+	// leave the pause as it was
+	h.pause <- syscall.SIGUSR1
+	h.pause <- syscall.SIGUSR1
+}
+
+// finishCycle lets the running cycle finish and waits for the loop to see it
+func (h *loopHarness) finishCycle() {
+	h.t.Helper()
+
+	h.finish <- true
+	h.settle()
+}
+
+func (h *loopHarness) exit(wantCode int, wantForced bool) {
+	h.t.Helper()
+
+	select {
+	case got := <-h.exited:
+		if got != (loopExit{wantCode, wantForced}) {
+			h.t.Errorf("Loop exited with code %d, forced %v, want %d, forced %v", got.code, got.forced, wantCode, wantForced)
+		}
+	case <-time.After(5 * time.Second):
+		h.t.Fatalf("Loop didn't exit")
+	}
+}
+
+func TestLoopRunsCycleEachInterval(t *testing.T) {
+	h := newLoopHarness(t)
+	h.start(loopConfig(60, 10))
+
+	// Right away, and then on every tick
+	h.cycleStarted()
+	h.finishCycle()
+
+	for i := 0; i < 3; i++ {
+		h.clock.Advance(59 * time.Second)
+		h.noCycle()
+
+		h.clock.Advance(time.Second)
+		h.cycleStarted()
+		h.finishCycle()
+	}
+
+	h.stop <- syscall.SIGTERM
+	h.exit(0, false)
+}
+
+func TestLoopSkipsTickWhileCycleRuns(t *testing.T) {
+	h := newLoopHarness(t)
+	h.start(loopConfig(60, 10))
+
+	h.cycleStarted()
+
+	// Two ticks go by while the first cycle hangs
+	h.clock.Advance(60 * time.Second)
+	h.clock.Advance(60 * time.Second)
+	h.finishCycle()
+	h.noCycle()
+
+	h.clock.Advance(60 * time.Second)
+	h.cycleStarted()
+	h.finishCycle()
+
+	h.stop <- syscall.SIGTERM
+	h.exit(0, false)
+}
+
+func TestLoopPauses(t *testing.T) {
+	h := newLoopHarness(t)
+	h.start(loopConfig(60, 10))
+
+	h.cycleStarted()
+	h.finishCycle()
+
+	h.pause <- syscall.SIGUSR1
+
+	for i := 0; i < 3; i++ {
+		h.clock.Advance(60 * time.Second)
+		h.noCycle()
+	}
+
+	h.pause <- syscall.SIGUSR1
+
+	h.clock.Advance(60 * time.Second)
+	h.cycleStarted()
+	h.finishCycle()
+
+	h.stop <- syscall.SIGTERM
+	h.exit(0, false)
+}
+
+func TestLoopReloadsBetweenCycles(t *testing.T) {
+	h := newLoopHarness(t)
+	h.start(loopConfig(60, 10))
+
+	h.cycleStarted()
+
+	// Asked for mid-cycle, the reload waits for the cycle and takes the new
+	// interval from then on
+	h.next = loopConfig(300, 10)
+	h.finishCycle()
+
+	h.clock.Advance(299 * time.Second)
+	h.noCycle()
+
+	h.clock.Advance(time.Second)
+
+	if cfg := h.cycleStarted(); cfg.Sensor.Interval != 300 {
+		t.Errorf("Cycle ran with interval %d, want the reloaded 300", cfg.Sensor.Interval)
+	}
+
+	h.finishCycle()
+
+	h.stop <- syscall.SIGTERM
+	h.exit(0, false)
+}
+
+func TestLoopShutdown(t *testing.T) {
+	tests := []struct {
+		name string
+		// Whether the running cycle finishes before the shutdown timeout
+		finishes bool
+		giveUp int
+		wantCode int
+		wantForced bool
+	}{
+		{"signal waits for the cycle", true, 0, 0, false},
+		{"signal times out", false, 0, exitFailure, true},
+		{"giving up keeps the code", true, exitSinks, exitSinks, false},
+		{"giving up times out", false, exitAuth, exitFailure, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := newLoopHarness(t)
+			h.start(loopConfig(60, 10))
+
+			h.cycleStarted()
+
+			if test.giveUp != 0 {
+				h.giveUp <- test.giveUp
+			} else {
+				h.stop <- syscall.SIGTERM
+			}
+
+			h.clock.waitForTimers(t, 1)
+
+			if test.finishes {
+				h.clock.Advance(9 * time.Second)
+				h.finish <- true
+			} else {
+				h.clock.Advance(10 * time.Second)
+			}
+
+			h.exit(test.wantCode, test.wantForced)
+		})
+	}
+}
@@ -1,21 +1,25 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
+	"flag"
 	"fmt"
 	"log"
-	"net/http"
-	"net/url"
+	"math"
 	"os"
 	"os/signal"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
-	"github.com/knadh/koanf"
-	"github.com/knadh/koanf/parsers/toml"
-	"github.com/knadh/koanf/providers/file"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type PointSpec struct {
@@ -39,12 +43,29 @@ type MainSpec struct {
 	Humidity float32 `json:"humidity"`
 	SeaLevel float32 `json:"sea_level"`
 	GroundLevel float32 `json:"grnd_level"`
+
+	// Whether the API reported these at all, set when decoding
+	HasSeaLevel bool `json:"-"`
+	HasGroundLevel bool `json:"-"`
+}
+
+// localPressure is the atmospheric pressure in the location itself, at
+// ground level when the API reports it
+func (m MainSpec) localPressure() float32 {
+	if m.HasGroundLevel {
+		return m.GroundLevel
+	}
+
+	return m.Pressure
 }
 
 type WindSpec struct {
 	Speed float32 `json:"speed"`
 	Degree float32 `json:"deg"`
 	Gust float32 `json:"gust"`
+
+	// Only set when the API reports gusts
+	HasGust bool `json:"-"`
 }
 
 type CloudSpec struct {
@@ -84,133 +105,751 @@ type WeatherResponse struct {
 	Timezone int `json:"timezone"`
 	Id int `json:"id"`
 	Name string `json:"name"`
-	Cod int `json:"cod"`
+	Cod Code `json:"cod"`
+
+	// Provider the reading came from, only set when there is a fallback
+	Source string `json:"-"`
+
+	// Whether the API reported the visibility at all, set when decoding
+	HasVisibility bool `json:"-"`
+
+	// Time zone of the location, if configured
+	Zone *time.Location `json:"-"`
+}
+
+// Response of the find endpoint, listing the stations around a location
+type FindResponse struct {
+	Count int `json:"count"`
+	List []WeatherResponse `json:"list"`
+}
+
+var dryRunFlag = flag.Bool("dry-run", false, "Fetch and log the points without writing them")
+var diffFlag = flag.Bool("diff", false, "Log how each reading differs from the previous one")
+var onceFlag = flag.Bool("once", false, "Run a single cycle and exit")
+var locationFlag = flag.String("location", "", "Location to tag replayed readings with, or to benchmark")
+var requestsFlag = flag.Int("requests", 10, "Requests to make when benchmarking")
+var concurrencyFlag = flag.Int("concurrency", 2, "Requests in flight at once when benchmarking")
+var rateFlag = flag.Float64("rate", 1, "Requests per second when benchmarking, 0 for no limit")
+
+// iconURL is where OpenWeatherMap serves the image for an icon code
+func iconURL(icon string) string {
+	return fmt.Sprintf("https://openweathermap.org/img/wn/%s@2x.png", icon)
+}
+
+// weatherPoints builds the points to write for a reading
+func weatherPoints(cfg *Config, weather WeatherResponse, location string) []*write.Point {
+	// We're interested in knowing the atmospheric pressure in the location
+	pressure := weather.Main.localPressure()
+
+	p := influxdb2.NewPointWithMeasurement(measurementName(cfg, weather, location)).
+		AddTag("location", tagValue(location)).
+		AddTag("city", tagValue(weather.Name)).
+		AddTag("country", tagValue(weather.Sys.Country)).
+		AddField("clouds", weather.Clouds.All).
+		AddField("wind_speed", weather.Wind.Speed).
+		AddField("wind_bearing", weather.Wind.Degree).
+		AddField("rain_1h", weather.Rain.LastHour).
+		AddField("rain_3h", weather.Rain.Last3Hours).
+		AddField("snow_1h", weather.Snow.LastHour).
+		AddField("snow_3h", weather.Snow.Last3Hours).
+		AddField("humidity", weather.Main.Humidity).
+		AddField("temperature", weather.Main.Temp).
+		AddField("temperature_max", weather.Main.TempMax).
+		AddField("temperature_min", weather.Main.TempMin).
+		AddField("pressure", pressure).
+		AddField("timezone_offset", weather.Timezone)
+
+	if weather.Source != "" {
+		p.AddTag("source", tagValue(weather.Source))
+	}
+
+	for _, tag := range cfg.Derived.Tags {
+		p.AddTag(tag, derivedTags[tag](localTime(weather), weather.Coordinates.Latitude))
+	}
+
+	// Tells which upstream station produced the reading, at the cost of a
+	// new series whenever OpenWeatherMap switches stations
+	if cfg.InfluxDB.StationTag && weather.Sys.Id != 0 {
+		p.AddTag("station_id", strconv.Itoa(weather.Sys.Id))
+	}
+
+	// Calm conditions report no gusts at all, storing those as zero skews
+	// gust statistics
+	if weather.Wind.HasGust || !cfg.InfluxDB.OmitAbsentGust {
+		p.AddField("wind_gusts", weather.Wind.Gust)
+	}
+
+	// Visibility is left out by the API when it can't tell
+	if weather.HasVisibility || !cfg.InfluxDB.OmitAbsentVisibility {
+		p.AddField("visibility", weather.Visibility)
+	}
+
+	// How stale the reading already is by the time it's written
+	if cfg.InfluxDB.IngestLag && weather.Timestamp != 0 {
+		p.AddField("ingest_lag_seconds", int64(clock.Now().Sub(time.Unix(int64(weather.Timestamp), 0)) / time.Second))
+	}
+
+	// Saves dashboards from building the URL out of the icon code
+	if cfg.InfluxDB.IconURL && len(weather.Weather) > 0 && weather.Weather[0].Icon != "" {
+		p.AddField("icon_url", iconURL(weather.Weather[0].Icon))
+	}
+
+	// Several conditions can hold at once, e.g. rain and mist
+	if cfg.InfluxDB.Conditions {
+		var conditions []string
+
+		for _, w := range weather.Weather {
+			conditions = append(conditions, w.Main)
+		}
+
+		p.AddField("conditions_count", len(conditions))
+
+		if len(conditions) > 0 {
+			p.AddField("conditions", strings.Join(conditions, ","))
+		}
+	}
+
+	if cfg.Derived.ApparentTemperature != "" {
+		p.AddField("apparent_temperature", apparentTemperature(cfg.Derived.ApparentTemperature, cfg.WeatherAPI.Units, weather))
+	}
+
+	// Every unit side by side, for dashboards shared across unit systems
+	if cfg.Derived.AllUnits {
+		celsius := toCelsius(float64(weather.Main.Temp), cfg.WeatherAPI.Units)
+		speed := toMetersPerSecond(float64(weather.Wind.Speed), cfg.WeatherAPI.Units)
+
+		p.AddField("temperature_c", celsius).
+			AddField("temperature_f", toUnits(celsius, "imperial")).
+			AddField("temperature_k", toUnits(celsius, "standard")).
+			AddField("wind_speed_ms", speed).
+			AddField("wind_speed_mph", speed / 0.44704)
+	}
+
+	// The field keeps hPa, whatever units readings are fetched in
+	for _, unit := range cfg.Derived.PressureUnits {
+		p.AddField("pressure_" + unit, float64(pressure) * hPaIn[unit])
+	}
+
+	if cfg.Derived.WindBearingRadians {
+		p.AddField("wind_bearing_rad", float64(weather.Wind.Degree) * math.Pi / 180)
+	}
+
+	if cfg.Derived.WindSpeedEMA {
+		p.AddField("wind_speed_ema", ema(location, "wind_speed", float64(weather.Wind.Speed), cfg.Derived.EMAAlpha))
+	}
+
+	// Snap the observation time onto the nearest boundary so points from
+	// different locations line up
+	if cfg.InfluxDB.RoundTime > 0 {
+		p.SetTime(time.Unix(int64(weather.Timestamp), 0).Round(cfg.InfluxDB.RoundTime))
+	}
+
+	points := []*write.Point{p}
+
+	if cfg.Daily.Enabled {
+		if summary := dailySummary(cfg.Daily, location, p, localTime(weather)); summary != nil {
+			log.Printf("Daily summary for location '%s' on %s", location, summary.Time().Format("2006-01-02"))
+			points = append(points, summary)
+		}
+	}
+
+	if cfg.Events.Enabled {
+		if event := rainEvent(location, weather.Rain.LastHour, cfg.Events.RainThreshold); event != "" {
+			log.Printf("Rain event '%s' for location '%s'", event, location)
+			points = append(points, eventPoint(cfg.Events, event, weather, location))
+		}
+	}
+
+	if cfg.InfluxDB.FloatPrecision != nil {
+		for _, p := range points {
+			roundFields(p, *cfg.InfluxDB.FloatPrecision)
+		}
+	}
+
+	return points
+}
+
+func writeWeather(ctx context.Context, cfg *Config, weather WeatherResponse, location string) error {
+	ctx, span := tracer.Start(ctx, "write", trace.WithAttributes(attribute.String("location", location)))
+	defer span.End()
+
+	points := weatherPoints(cfg, weather, location)
+	reading := points[0]
+
+	if *diffFlag {
+		log.Printf("Changes for location '%s': %s", location, diffPoint(location, reading))
+	}
+
+	// Readings that barely moved are dropped, events still go through
+	var watched map[string]float64
+
+	if cfg.ChangeFilter.Enabled {
+		var significant bool
+
+		if significant, watched = significantChange(cfg.ChangeFilter, location, reading); !significant {
+			log.Printf("No significant change for location '%s', skipping (%d in a row)", location, skipped[location])
+			points = points[1:]
+
+			if len(points) == 0 {
+				return nil
+			}
+		}
+	}
+
+	// Only the reading is trimmed, daily summaries and events keep their
+	// own fields
+	if fields := cfg.InfluxDB.fields(); fields != nil && points[0] == reading {
+		reading = keepFields(reading, fields)
+		points[0] = reading
+	}
+
+	// Renamed last, everything else refers to fields by their own names
+	if len(cfg.InfluxDB.FieldMap) > 0 && points[0] == reading {
+		renameFields(reading, cfg.InfluxDB.FieldMap)
+	}
+
+	if err := writePoints(ctx, cfg, location, points); err != nil {
+		return err
+	}
+
+	if watched != nil {
+		changeWritten(location, watched)
+	}
+
+	return nil
 }
 
-var k = koanf.New(".")
+// writePoints writes the points to the sink, through the pipeline if there
+// is one, or only logs them on dry runs
+func writePoints(ctx context.Context, cfg *Config, location string, points []*write.Point) error {
+	span := trace.SpanFromContext(ctx)
+
+	if *dryRunFlag {
+		for _, p := range points {
+			line, err := lineProtocol([]*write.Point{p}, time.Second)
+
+			if err != nil {
+				return err
+			}
+
+			log.Printf("Dry run, not writing: %s", strings.TrimSuffix(line, "\n"))
+		}
+
+		return nil
+	}
+
+	span.SetAttributes(attribute.Int("points", len(points)))
 
-func fetchWeather(location string) (WeatherResponse, error) {
-	var res WeatherResponse
+	if c := collectorFrom(ctx); c != nil {
+		c.add(cfg.Sensor, location, points)
+		return nil
+	}
+
+	if writes != nil {
+		writes.enqueue(ctx, cfg.Sensor, location, points)
+		return nil
+	}
 
-	baseUrl, err := url.Parse("https://api.openweathermap.org/data/2.5/weather")
+	s, release := acquireSink()
+	defer release()
+
+	start := clock.Now()
+	err := s.Write(ctx, points)
+	checkSinks(cfg.Sensor, err)
+	recordWrite(start, len(points), err)
 
 	if err != nil {
-		return res, err
+		span.RecordError(err)
+		return err
 	}
 
-	params := url.Values{}
-	params.Add("q", location)
-	params.Add("appid", k.String("weather_api.appid"))
-	params.Add("units", k.String("weather_api.units"))
+	atomic.AddInt64(&stats.points, int64(len(points)))
+
+	return nil
+}
+
+// Number of panics recovered from while processing locations
+var panics int64
+
+// Number of readings rejected as implausible
+var rejected int64
+
+// fetchFailed is returned for a location that couldn't be fetched, so
+// nothing was written for it and it's safe to try again
+type fetchFailed struct {
+	err error
+}
+
+func (e fetchFailed) Error() string {
+	return e.err.Error()
+}
+
+func (e fetchFailed) Unwrap() error {
+	return e.err
+}
 
-	baseUrl.RawQuery = params.Encode()
+// Location currently being processed, reported if shutdown times out
+var current atomic.Value
+
+// processLocation fetches and writes the weather for a single location. A
+// panic anywhere in that path is logged and returned as an error so that one
+// bad location can't take the whole sensor down.
+func processLocation(ctx context.Context, cfg *Config, location Location) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			n := atomic.AddInt64(&panics, 1)
+			panicsRecovered.WithLabelValues(location.Tag()).Inc()
+			statsd.count("panics", 1, "location:" + location.Tag())
+			log.Printf("Recovered from panic processing location '%s' (%d so far): %v\n%s", location.Tag(), n, r, debug.Stack())
+			err = fmt.Errorf("Panic processing location '%s': %v", location.Tag(), r)
+		}
+	}()
 
-	resp, err := http.Get(baseUrl.String())
+	var readings []WeatherResponse
+
+	start := clock.Now()
+
+	if location.Nearby > 0 {
+		readings, err = fetchNearby(ctx, provider, location)
+	} else {
+		var weather WeatherResponse
+		weather, err = provider.Fetch(ctx, location)
+		readings = append(readings, weather)
+	}
+
+	checkAuth(cfg.Sensor, err)
+	fetchResults.WithLabelValues(fetchResult(err)).Inc()
+	statsd.count("fetches", 1, "result:" + fetchResult(err))
+	statsd.timing("fetch_duration", clock.Now().Sub(start), "result:" + fetchResult(err))
+	observeFetch(ctx, fetchResult(err), clock.Now().Sub(start))
 
 	if err != nil {
-		return res, err
+		atomic.AddInt64(&stats.failedFetches, 1)
+		logThrottled("Error fetching the weather for location '%s' (%s): %v", location.Tag(), fetchResult(err), err)
+		return fetchFailed{err}
 	}
 
-	defer resp.Body.Close()
+	atomic.AddInt64(&stats.fetches, 1)
+	log.Printf("Weather fetched for location '%s'", location.Tag())
+
+	for _, weather := range readings {
+		tag := location.Tag()
+
+		// Nearby stations are each written as their own series
+		if location.Nearby > 0 {
+			tag += "/" + weather.Name
+		}
+
+		weather.Zone = location.TimeZone
+
+		checkKelvin(cfg.Validation.Kelvin, cfg.WeatherAPI.Units, tag, &weather)
+
+		if cfg.Validation.Enabled {
+			if err := validateWeather(cfg.Validation, cfg.WeatherAPI.Units, weather); err != nil {
+				n := atomic.AddInt64(&rejected, 1)
+				rejectedReadings.WithLabelValues(tag, rejectReason(err)).Inc()
+				statsd.count("rejected_readings", 1, "location:" + tag, "reason:" + rejectReason(err))
+				log.Printf("Rejecting reading for location '%s' (%d so far): %v", tag, n, err)
+				continue
+			}
+		}
+
+		// Writing a stale reading again would pass it off as fresh
+		if stale(cfg.Staleness, tag, weather) && cfg.Staleness.Skip {
+			continue
+		}
+
+		exportWeather(cfg.WeatherAPI.Units, tag, weather)
+
+		if err := writeWeather(ctx, cfg, weather, tag); err != nil {
+			logThrottled("Error writing the weather for location '%s': %v", tag, err)
+			return err
+		}
+	}
+
+	// A failed forecast leaves the reading it comes with written, it's only
+	// tried again once the forecast interval is up
+	if cfg.Forecast.enabled() && forecastDue(cfg.Forecast, location) {
+		if err := writeForecast(ctx, cfg, location); err != nil {
+			logThrottled("Error updating the forecast for location '%s': %v", location.Tag(), err)
+		} else {
+			log.Printf("Forecast written for location '%s'", location.Tag())
+		}
+	}
+
+	return nil
+}
+
+// runCycle processes every location that is due, returning the first error
+// encountered. Under the abort error policy the cycle stops at that error.
+func runCycle(cfg *Config, fetched map[string]time.Time) error {
+	ctx, span := tracer.Start(context.Background(), "cycle")
+	defer span.End()
 
-	if resp.StatusCode / 100 == 2 {
+	atomic.AddInt64(&stats.cycles, 1)
 
-		err := json.NewDecoder(resp.Body).Decode(&res)
+	var collected *collector
 
+	if cfg.Pipeline.Coalesce {
+		ctx, collected = withCollector(ctx)
+	}
+
+	// Zip codes that couldn't be resolved before
+	if err := geocodeZips(ctx, cfg, 0); err != nil {
+		log.Printf("%v", err)
+	}
+
+	var due []Location
+
+	for _, location := range cfg.Locations {
+		if location.Interval > 0 && clock.Now().Sub(fetched[location.Tag()]) < location.Interval {
+			continue
+		}
+
+		fetched[location.Tag()] = clock.Now()
+		due = append(due, location)
+	}
+
+	errs := processLocations(ctx, cfg, due)
+
+	// Every location failing points at our own network rather than the API,
+	// which is worth another try before the next interval. Only fetches are
+	// tried again, whatever was written stays written.
+	for retry := 1; retry <= cfg.Sensor.CycleRetries; retry++ {
+		var unfetched []int
+		var locations []Location
+
+		for i, err := range errs {
+			if errors.As(err, &fetchFailed{}) {
+				unfetched = append(unfetched, i)
+				locations = append(locations, due[i])
+			}
+		}
+
+		if len(unfetched) == 0 || countErrors(errs) < len(due) {
+			break
+		}
+
+		log.Printf("All %d locations failed, fetching the %d that couldn't be fetched again in %v (%d/%d)", len(due), len(unfetched), cfg.Sensor.CycleRetryDelay, retry, cfg.Sensor.CycleRetries)
+		clock.Sleep(cfg.Sensor.CycleRetryDelay)
+
+		for j, err := range processLocations(ctx, cfg, locations) {
+			errs[unfetched[j]] = err
+		}
+	}
+
+	var first error
+
+	for _, err := range errs {
 		if err != nil {
-			return res, err
+			first = err
+			break
 		}
+	}
 
-		return res, nil
+	// Written whether or not the locations went through
+	if cfg.Heartbeat.Enabled {
+		writeHeartbeat(ctx, cfg)
 	}
 
-	return res, errors.New(fmt.Sprintf("Request failed with status: %d", resp.StatusCode))
+	if collected != nil {
+		if err := collected.flush(ctx, cfg); err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return first
 }
 
-func writeWeather(weather WeatherResponse, location string) error {
-	client := influxdb2.NewClientWithOptions(k.String("influxdb.hostname"), k.String("influxdb.token"), influxdb2.DefaultOptions().SetBatchSize(20))
+// processLocations processes the locations in turn, returning the error of
+// each of them. Those after the first error are left nil when aborting.
+func processLocations(ctx context.Context, cfg *Config, locations []Location) []error {
+	errs := make([]error, len(locations))
 
-	defer client.Close()
+	for i, location := range locations {
+		// Spread the calls over the cycle rather than making them in a burst
+		if i > 0 && cfg.Sensor.InterLocationDelay > 0 {
+			clock.Sleep(cfg.Sensor.InterLocationDelay)
+		}
 
-	var pressure float32
+		current.Store(location.Tag())
 
-	// We're interested in knowing the atmospheric pressure in the location
-	if weather.Main.GroundLevel == 0 {
-		pressure = weather.Main.Pressure
-	} else {
-		pressure = weather.Main.GroundLevel
+		if errs[i] = processLocation(ctx, cfg, location); errs[i] != nil && cfg.Sensor.ErrorPolicy == "abort" {
+			log.Printf("Aborting cycle on first error")
+			break
+		}
 	}
 
-	writer := client.WriteAPI(k.String("influxdb.org"), k.String("influxdb.bucket"))
+	return errs
+}
 
-	p := influxdb2.NewPointWithMeasurement(k.String("influxdb.measurement")).
-		AddTag("location", location).
-		AddTag("city", weather.Name).
-		AddTag("country", weather.Sys.Country).
-		AddField("visibility", weather.Visibility).
-		AddField("clouds", weather.Clouds.All).
-		AddField("wind_speed", weather.Wind.Speed).
-		AddField("wind_bearing", weather.Wind.Degree).
-		AddField("wind_gusts", weather.Wind.Gust).
-		AddField("rain_1h", weather.Rain.LastHour).
-		AddField("rain_3h", weather.Rain.Last3Hours).
-		AddField("snow_1h", weather.Snow.LastHour).
-		AddField("snow_3h", weather.Snow.Last3Hours).
-		AddField("humidity", weather.Main.Humidity).
-		AddField("temperature", weather.Main.Temp).
-		AddField("temperature_max", weather.Main.TempMax).
-		AddField("temperature_min", weather.Main.TempMin).
-		AddField("pressure", pressure)
+// countErrors counts how many of the errors are set
+func countErrors(errs []error) int {
+	n := 0
 
-	writer.WritePoint(p)
-	writer.Flush()
+	for _, err := range errs {
+		if err != nil {
+			n++
+		}
+	}
 
-	return nil
+	return n
+}
+
+// Run before exiting, unless the exit is forced
+var cleanups []func()
+
+func exit(code int) {
+	for _, cleanup := range cleanups {
+		cleanup()
+	}
+
+	os.Exit(code)
+}
+
+// reload re-reads the config, keeping the current one if anything is wrong
+// with the new one
+func reload(cfg *Config) *Config {
+	reloaded, err := loadConfig("config.toml")
+
+	if err != nil {
+		log.Printf("Error reloading config, keeping the current one: %v", err)
+		return cfg
+	}
+
+	// An empty location list is much more likely a bad edit than a wish to
+	// stop collecting altogether
+	if len(reloaded.Locations) < 1 {
+		log.Printf("Reloaded config has no locations, keeping the current %d", len(cfg.Locations))
+		reloaded.Locations = cfg.Locations
+	}
+
+	client, err := newHTTPClient(reloaded.WeatherAPI)
+
+	if err != nil {
+		log.Printf("Error creating the weather API client, keeping the current config: %v", err)
+		return cfg
+	}
+
+	// Looked up with the current client, only new zip codes need it
+	if err := geocodeZips(context.Background(), reloaded, 0); err != nil {
+		log.Printf("%v, keeping the current config", err)
+		return cfg
+	}
+
+	if !*dryRunFlag {
+		s, err := newSink(reloaded)
+
+		if err == nil {
+			s, err = withWAL(s, reloaded.Debug)
+		}
+
+		if err != nil {
+			log.Printf("Error creating the sink, keeping the current config: %v", err)
+			return cfg
+		}
+
+		// Writes still going to the old sink finish before it's closed
+		replaceSink(s)
+	}
+
+	httpClient = client
+	provider = newProvider(reloaded.WeatherAPI)
+
+	log.Printf("Config reloaded with %d locations", len(reloaded.Locations))
+
+	return reloaded
 }
 
 func main() {
-	if err := k.Load(file.Provider("config.toml"), toml.Parser()); err != nil {
-		log.Fatalf("Error loading config: %v", err)
+	flag.Parse()
+
+	// Commands that don't need a config
+	if flag.Arg(0) == "config-schema" {
+		if err := printSchema(); err != nil {
+			log.Fatalf("Error printing config schema: %v", err)
+		}
+
+		return
 	}
 
-	log.Printf("Starting weather virtual sensor reporting each %d seconds...", k.Int("sensor.interval"))
+	if flag.Arg(0) == "migrate" {
+		if err := migrateConfig("config.toml"); err != nil {
+			log.Fatalf("Error migrating config: %v", err)
+		}
+
+		return
+	}
 
-	locations := k.Strings("weather_api.locations")
+	cfg, err := loadConfig("config.toml")
 
-	if len(locations) < 1 {
-		log.Fatal("Weather locations are empty! Aborting...")
+	if err != nil {
+		fatal(exitConfig, "Error loading config: %v", err)
 	}
 
-	sigs := make(chan os.Signal)
-	ticks := make(chan bool)
+	switch flag.Arg(0) {
+	case "":
+	case "print-config":
+		if err := printConfig(cfg); err != nil {
+			log.Fatalf("Error printing config: %v", err)
+		}
 
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+		return
+	case "test-sinks":
+		if err := testSinks(cfg); err != nil {
+			log.Fatalf("%v", err)
+		}
 
-	go func() {
-		for {
-			time.Sleep(time.Duration(k.Int("sensor.interval")) * time.Second)
-			ticks <- true
+		return
+	case "bench":
+		if err := bench(cfg, *locationFlag, *requestsFlag, *concurrencyFlag, *rateFlag); err != nil {
+			log.Fatalf("Error benchmarking: %v", err)
 		}
-	}()
 
-	for {
-		for _, location := range locations {
-			weather, err := fetchWeather(location)
+		return
+	case "tail":
+		if err := tail(cfg, *onceFlag); err != nil {
+			log.Fatalf("Error tailing readings: %v", err)
+		}
 
-			if err != nil {
-				log.Printf("Error fetching the weather: %v\n", err)
-			} else {
-				log.Printf("Weather fetched for location '%s'", location)
-				writeWeather(weather, location)
-			}
+		return
+	case "dashboard":
+		if err := dashboard(cfg); err != nil {
+			log.Fatalf("Error generating the dashboard: %v", err)
 		}
 
+		return
+	case "replay":
+		if err := replay(cfg, flag.Arg(1), *locationFlag); err != nil {
+			log.Fatalf("Error replaying the reading: %v", err)
+		}
+
+		return
+	default:
+		log.Fatalf("Unknown command '%s'", flag.Arg(0))
+	}
+
+	if len(cfg.Locations) < 1 {
+		fatal(exitConfig, "Weather locations are empty! Aborting...")
+	}
+
+	httpClient, err = newHTTPClient(cfg.WeatherAPI)
+
+	if err != nil {
+		fatal(exitConfig, "Error creating the weather API client: %v", err)
+	}
+
+	// Only zip codes the geocoder rejects are fatal, others are looked up
+	// again every cycle until they resolve
+	if err := geocodeZips(context.Background(), cfg, geocodeRetries); err != nil {
+		fatal(exitConfig, "%v", err)
+	}
+
+	provider = newProvider(cfg.WeatherAPI)
+
+	if !*dryRunFlag {
+		s, err := newSink(cfg)
+
+		if err != nil {
+			fatal(exitConfig, "Error creating the sink: %v", err)
+		}
+
+		if err := waitForInfluxSinks(s, cfg.InfluxDB.StartupRetries); err != nil {
+			fatal(exitSinks, "%v Aborting...", err)
+		}
+
+		if s, err = withWAL(s, cfg.Debug); err != nil {
+			fatal(exitConfig, "Error opening the write log: %v", err)
+		}
+
+		setSink(s)
+
+		if cfg.Pipeline.BufferSize > 0 {
+			writes = startPipeline(cfg.Pipeline)
+			timeout := time.Duration(cfg.Shutdown.TimeoutSeconds) * time.Second
+			cleanups = append(cleanups, func() { writes.drain(timeout) })
+		}
+	}
+
+	if cfg.StatsD.Address != "" {
+		if statsd, err = newStatsdClient(cfg.StatsD); err != nil {
+			fatal(exitConfig, "Error setting up StatsD: %v", err)
+		}
+	}
+
+	if cfg.OTel.Endpoint != "" {
+		flushSpans, err := setupTracing(cfg.OTel)
+
+		if err != nil {
+			log.Fatalf("Error setting up tracing: %v", err)
+		}
+
+		cleanups = append(cleanups, flushSpans)
+	}
+
+	stats.started = clock.Now()
+	cleanups = append(cleanups, logStats)
+
+	log.Printf("Starting weather virtual sensor reporting each %d seconds...", cfg.Sensor.Interval)
+
+	go logDailyCalls()
+
+	if window := cfg.Log.ThrottleWindow; window > 0 {
+		go runThrottle(window)
+		cleanups = append(cleanups, flushThrottled)
+	}
+
+	if cfg.Metrics.Listen != "" {
+		go serveMetrics(cfg.Metrics)
+	}
+
+	sigs := make(chan os.Signal, 1)
+	hups := make(chan os.Signal, 1)
+	usr1s := make(chan os.Signal, 1)
+
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(hups, syscall.SIGHUP)
+	signal.Notify(usr1s, syscall.SIGUSR1)
+
+	// Last time each location was fetched, for those with their own interval
+	fetched := map[string]time.Time{}
+
+	if *onceFlag {
+		err := runCycle(cfg, fetched)
+
 		select {
-		case sig := <-sigs:
-			log.Printf("Signal %v captured, exiting...", sig)
-			os.Exit(0)
-		case <-ticks:
-			continue
+		case code := <-giveUp:
+			exit(code)
+		default:
+		}
+
+		if err != nil && cfg.Sensor.ErrorPolicy == "abort" {
+			exit(exitFailure)
 		}
+
+		exit(0)
+	}
+
+	if cfg.Watchdog.TimeoutSeconds > 0 {
+		beat()
+		go runWatchdog(cfg.Watchdog)
+	}
+
+	l := &loop{
+		clock: clock,
+		cycle: func(cfg *Config) { runCycle(cfg, fetched) },
+		reload: reload,
+		stop: sigs,
+		hup: hups,
+		pause: usr1s,
+		giveUp: giveUp,
 	}
+
+	code, forced := l.run(cfg)
+
+	if forced {
+		os.Exit(code)
+	}
+
+	exit(code)
 }
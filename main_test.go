@@ -0,0 +1,961 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	"github.com/knadh/koanf"
+	"github.com/knadh/koanf/parsers/toml"
+	"github.com/knadh/koanf/providers/rawbytes"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// Settings every test config starts from
+const baseConfig = `
+[sensor]
+interval = 300
+
+[weather_api]
+appid = "test"
+units = "metric"
+locations = [ "Lisbon" ]
+
+[influxdb]
+hostname = "http://influx:8086/"
+measurement = "weather"
+`
+
+// testConfig loads a config made of the base settings with the given ones
+// on top
+func testConfig(t *testing.T, contents string) *Config {
+	t.Helper()
+
+	k := koanf.New(".")
+
+	for _, c := range []string{baseConfig, contents} {
+		if err := k.Load(rawbytes.Provider([]byte(c)), toml.Parser()); err != nil {
+			t.Fatalf("Error parsing test config: %v", err)
+		}
+	}
+
+	merged, err := k.Marshal(toml.Parser())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "config.toml")
+
+	if err := os.WriteFile(path, merged, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := loadConfig(path)
+
+	if err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+
+	return cfg
+}
+
+// rerouteTransport sends every request to the test server, whatever host
+// it's meant for
+type rerouteTransport struct {
+	host string
+	base http.RoundTripper
+}
+
+func (t rerouteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Host = t.host
+
+	return t.base.RoundTrip(req)
+}
+
+// useAPI points the weather API client at a test server for the length of
+// the test
+func useAPI(t *testing.T, handler http.Handler) *httptest.Server {
+	ts := httptest.NewTLSServer(handler)
+
+	previous := httpClient
+	httpClient = &http.Client{Transport: rerouteTransport{ts.Listener.Addr().String(), ts.Client().Transport}}
+
+	t.Cleanup(func() {
+		httpClient = previous
+		ts.Close()
+	})
+
+	return ts
+}
+
+// fakeProvider hands out canned readings, failing for the locations it's
+// told to
+type fakeProvider struct {
+	mutex sync.Mutex
+	fail map[string]error
+	fetches map[string]int
+}
+
+func newFakeProvider() *fakeProvider {
+	return &fakeProvider{fail: map[string]error{}, fetches: map[string]int{}}
+}
+
+func (p *fakeProvider) Name() string {
+	return "fake"
+}
+
+func (p *fakeProvider) Fetch(ctx context.Context, location Location) (WeatherResponse, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.fetches[location.Tag()]++
+
+	if err := p.fail[location.Tag()]; err != nil {
+		return WeatherResponse{}, err
+	}
+
+	return testReading(location.Tag()), nil
+}
+
+func (p *fakeProvider) setFailing(location string, err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.fail[location] = err
+}
+
+func (p *fakeProvider) fetchCount(location string) int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.fetches[location]
+}
+
+func testReading(name string) WeatherResponse {
+	var w WeatherResponse
+
+	w.Name = name
+	w.Sys.Country = "PT"
+	w.Main.Temp = 18.5
+	w.Main.TempMin = 17
+	w.Main.TempMax = 20
+	w.Main.Humidity = 70
+	w.Main.Pressure = 1015
+	w.Wind.Speed = 3.5
+	w.Wind.Degree = 270
+	w.Clouds.All = 40
+	w.Weather = []WeatherSpec{{Id: 500, Main: "Rain", Description: "light rain", Icon: "10d"}}
+
+	return w
+}
+
+// memorySink keeps every point written to it, failing while told to or for
+// writes with points of the location it refuses
+type memorySink struct {
+	mutex sync.Mutex
+	points []*write.Point
+	fail error
+	refuse string
+	writes int
+	closed bool
+}
+
+func (s *memorySink) Write(ctx context.Context, points []*write.Point) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.writes++
+
+	if s.fail != nil {
+		return s.fail
+	}
+
+	for _, p := range points {
+		for _, tag := range p.TagList() {
+			if tag.Key == "location" && tag.Value == s.refuse {
+				return fmt.Errorf("partial write: field type conflict")
+			}
+		}
+	}
+
+	s.points = append(s.points, points...)
+
+	return nil
+}
+
+func (s *memorySink) Health(ctx context.Context) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.fail
+}
+
+func (s *memorySink) Close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.closed = true
+}
+
+func (s *memorySink) isClosed() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.closed
+}
+
+func (s *memorySink) setFailing(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.fail = err
+}
+
+// written lists the points written for a location
+func (s *memorySink) written(location string) []*write.Point {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var points []*write.Point
+
+	for _, p := range s.points {
+		for _, tag := range p.TagList() {
+			if tag.Key == "location" && tag.Value == location {
+				points = append(points, p)
+			}
+		}
+	}
+
+	return points
+}
+
+// useFakes points the sensor at a fake provider and an in-memory sink for
+// the length of the test
+func useFakes(t *testing.T) (*fakeProvider, *memorySink) {
+	p := newFakeProvider()
+	s := &memorySink{}
+
+	previousProvider, previousSink := provider, currentSink()
+	provider = p
+	setSink(s)
+
+	t.Cleanup(func() {
+		provider = previousProvider
+		setSink(previousSink)
+	})
+
+	return p, s
+}
+
+// fieldValue returns the value of a point's field, and whether it has it
+func fieldValue(p *write.Point, key string) (interface{}, bool) {
+	for _, f := range p.FieldList() {
+		if f.Key == key {
+			return f.Value, true
+		}
+	}
+
+	return nil, false
+}
+
+// pointTag returns the value of a point's tag, and whether it has it
+func pointTag(p *write.Point, key string) (string, bool) {
+	for _, tag := range p.TagList() {
+		if tag.Key == key {
+			return tag.Value, true
+		}
+	}
+
+	return "", false
+}
+
+func TestRunCycleRetriesOnlyUnfetched(t *testing.T) {
+	tests := []struct {
+		name string
+		// Locations whose fetch keeps failing
+		unfetched []string
+		// Whether the sink is down for the first attempt
+		sinkDown bool
+		wantFetches map[string]int
+		wantWritten map[string]int
+	}{
+		{
+			name: "one location down",
+			unfetched: []string{"Lisbon"},
+			wantFetches: map[string]int{"Lisbon": 1, "Porto": 1},
+			wantWritten: map[string]int{"Lisbon": 0, "Porto": 1},
+		},
+		{
+			name: "every location down",
+			unfetched: []string{"Lisbon", "Porto"},
+			wantFetches: map[string]int{"Lisbon": 3, "Porto": 3},
+			wantWritten: map[string]int{"Lisbon": 0, "Porto": 0},
+		},
+		{
+			name: "sink down",
+			sinkDown: true,
+			wantFetches: map[string]int{"Lisbon": 1, "Porto": 1},
+			wantWritten: map[string]int{"Lisbon": 0, "Porto": 0},
+		},
+		{
+			name: "sink down for the only fetched location",
+			unfetched: []string{"Lisbon"},
+			sinkDown: true,
+			wantFetches: map[string]int{"Lisbon": 3, "Porto": 1},
+			wantWritten: map[string]int{"Lisbon": 0, "Porto": 0},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := testConfig(t, "[weather_api]\nlocations = [ \"Lisbon\", \"Porto\" ]\n[sensor]\ncycle_retries = 2\n")
+			cfg.Sensor.CycleRetryDelay = 0
+
+			p, s := useFakes(t)
+
+			for _, location := range test.unfetched {
+				p.setFailing(location, fmt.Errorf("no route to host"))
+			}
+
+			if test.sinkDown {
+				s.setFailing(fmt.Errorf("connection refused"))
+			}
+
+			if err := runCycle(cfg, map[string]time.Time{}); err == nil {
+				t.Errorf("Cycle with failures returned no error")
+			}
+
+			for location, want := range test.wantFetches {
+				if got := p.fetchCount(location); got != want {
+					t.Errorf("Fetched '%s' %d times, want %d", location, got, want)
+				}
+			}
+
+			for location, want := range test.wantWritten {
+				if got := len(s.written(location)); got != want {
+					t.Errorf("Wrote %d points for '%s', want %d", got, location, want)
+				}
+			}
+		})
+	}
+}
+
+// panickingProvider panics on every fetch
+type panickingProvider struct{}
+
+func (panickingProvider) Name() string {
+	return "panicking"
+}
+
+func (panickingProvider) Fetch(ctx context.Context, location Location) (WeatherResponse, error) {
+	var reading *WeatherResponse
+	return *reading, nil
+}
+
+func TestProcessLocationRecoversPanics(t *testing.T) {
+	cfg := testConfig(t, "")
+	useFakes(t)
+	provider = panickingProvider{}
+
+	before := testutil.ToFloat64(panicsRecovered.WithLabelValues("Lisbon"))
+	beforeTotal := atomic.LoadInt64(&panics)
+
+	// Locations are processed concurrently, so panics are too
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := processLocation(context.Background(), cfg, cfg.Locations[0]); err == nil || !strings.Contains(err.Error(), "Panic") {
+				t.Errorf("Processing a panicking location returned %v, want the panic", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := testutil.ToFloat64(panicsRecovered.WithLabelValues("Lisbon")) - before; got != 2 {
+		t.Errorf("Counted %v panics for the location, want 2", got)
+	}
+
+	if got := atomic.LoadInt64(&panics) - beforeTotal; got != 2 {
+		t.Errorf("Counted %d panics in total, want 2", got)
+	}
+}
+
+// fieldKeys lists the fields of a point, sorted
+func fieldKeys(p *write.Point) []string {
+	var keys []string
+
+	for _, f := range p.FieldList() {
+		keys = append(keys, f.Key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// writeDryThenRain writes a dry reading and then a rainy one on the next day,
+// which closes a daily summary and starts raining. The points written for
+// the second reading are returned by measurement.
+func writeDryThenRain(t *testing.T, cfg *Config, location string) map[string][]*write.Point {
+	t.Helper()
+
+	_, s := useFakes(t)
+
+	dry := testReading(location)
+	dry.Timestamp = 1654084800
+	rainy := dry
+	rainy.Timestamp += 24 * 3600
+	rainy.Rain.LastHour = 1.5
+
+	if err := writeWeather(context.Background(), cfg, dry, location); err != nil {
+		t.Fatalf("writeWeather returned %v", err)
+	}
+
+	before := len(s.written(location))
+
+	if err := writeWeather(context.Background(), cfg, rainy, location); err != nil {
+		t.Fatalf("writeWeather returned %v", err)
+	}
+
+	written := s.written(location)[before:]
+
+	byMeasurement := map[string][]*write.Point{}
+
+	for _, p := range written {
+		byMeasurement[p.Name()] = append(byMeasurement[p.Name()], p)
+	}
+
+	return byMeasurement
+}
+
+func TestWriteWeatherFields(t *testing.T) {
+	const summaries = "[daily]\nenabled = true\n[events]\nenabled = true\n"
+
+	tests := []struct {
+		name string
+		config string
+		wantSkipped bool
+		// Fields of the second reading, nil for all of them
+		wantReading []string
+	}{
+		{"all fields", "", false, nil},
+		{"minimal profile", "[influxdb]\nprofile = \"minimal\"\n", false, []string{"humidity", "pressure", "temperature"}},
+		{"field whitelist", "[influxdb]\nfields = [ \"temperature\", \"wind_speed\" ]\n", false, []string{"temperature", "wind_speed"}},
+		{"reading without a significant change", "[influxdb]\nprofile = \"minimal\"\n[change_filter]\nenabled = true\n[change_filter.deltas]\ntemperature = 0.2\n", true, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := testConfig(t, summaries + test.config)
+			location := "Lisbon " + test.name
+
+			points := writeDryThenRain(t, cfg, location)
+
+			want := 1
+
+			if test.wantSkipped {
+				want = 0
+			}
+
+			if len(points["weather"]) != want {
+				t.Fatalf("Wrote %d readings, want %d", len(points["weather"]), want)
+			}
+
+			if test.wantReading != nil {
+				if got := fieldKeys(points["weather"][0]); strings.Join(got, ",") != strings.Join(test.wantReading, ",") {
+					t.Errorf("Reading has fields %v, want %v", got, test.wantReading)
+				}
+			}
+
+			// Summaries and events keep fields of their own, whatever the
+			// reading is trimmed to
+			if len(points["weather_daily"]) != 1 {
+				t.Fatalf("Wrote %d daily summaries, want 1", len(points["weather_daily"]))
+			}
+
+			if _, ok := fieldValue(points["weather_daily"][0], "wind_speed_max"); !ok {
+				t.Errorf("Daily summary has fields %v, want wind_speed_max among them", fieldKeys(points["weather_daily"][0]))
+			}
+
+			if len(points["weather_events"]) != 1 {
+				t.Fatalf("Wrote %d events, want 1", len(points["weather_events"]))
+			}
+
+			if event, _ := fieldValue(points["weather_events"][0], "event"); event != "rain_onset" {
+				t.Errorf("Event has fields %v, want event 'rain_onset'", fieldKeys(points["weather_events"][0]))
+			}
+		})
+	}
+}
+
+func TestWriteWeatherFieldMap(t *testing.T) {
+	const summaries = "[daily]\nenabled = true\n[events]\nenabled = true\n"
+	const fieldMap = "[influxdb.field_map]\ntemperature = \"temp\"\nevent = \"kind\"\n"
+
+	tests := []struct {
+		name string
+		config string
+		wantReading []string
+	}{
+		{"all fields", "", []string{"temp", "humidity", "pressure", "wind_speed"}},
+		{"minimal profile", "[influxdb]\nprofile = \"minimal\"\n", []string{"humidity", "pressure", "temp"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := testConfig(t, summaries + test.config + fieldMap)
+			points := writeDryThenRain(t, cfg, "Lisbon " + test.name)
+
+			if len(points["weather"]) != 1 || len(points["weather_daily"]) != 1 || len(points["weather_events"]) != 1 {
+				t.Fatalf("Wrote %d readings, %d daily summaries and %d events, want one each", len(points["weather"]), len(points["weather_daily"]), len(points["weather_events"]))
+			}
+
+			reading := points["weather"][0]
+
+			for _, key := range test.wantReading {
+				if _, ok := fieldValue(reading, key); !ok {
+					t.Errorf("Reading has fields %v, want %s among them", fieldKeys(reading), key)
+				}
+			}
+
+			if _, ok := fieldValue(reading, "temperature"); ok {
+				t.Errorf("Reading still has a temperature field")
+			}
+
+			// Only the reading's fields are renamed
+			if _, ok := fieldValue(points["weather_daily"][0], "temperature_max"); !ok {
+				t.Errorf("Daily summary has fields %v, want temperature_max among them", fieldKeys(points["weather_daily"][0]))
+			}
+
+			if _, ok := fieldValue(points["weather_events"][0], "event"); !ok {
+				t.Errorf("Event has fields %v, want event among them", fieldKeys(points["weather_events"][0]))
+			}
+		})
+	}
+}
+
+func TestWeatherPointsRoundTime(t *testing.T) {
+	tests := []struct {
+		name string
+		roundTime string
+		timestamp int
+		want time.Time
+	}{
+		{"not rounded", "0s", 1654084823, time.Time{}},
+		{"down to the minute", "1m", 1654084823, time.Unix(1654084800, 0)},
+		{"up to five minutes", "5m", 1654084960, time.Unix(1654085100, 0)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := testConfig(t, "[influxdb]\nround_time = \"" + test.roundTime + "\"\n")
+			weather := testReading("Lisbon")
+			weather.Timestamp = test.timestamp
+
+			if got := weatherPoints(cfg, weather, "Lisbon")[0].Time(); !got.Equal(test.want) {
+				t.Errorf("Point is stamped %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestWeatherPointsCountryAndTimezone(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		wantCountry string
+		wantOffset int64
+	}{
+		{"east of UTC", `{"name": "Tokyo", "sys": {"country": "JP"}, "timezone": 32400}`, "JP", 32400},
+		{"west of UTC", `{"name": "New York", "sys": {"country": "US"}, "timezone": -14400}`, "US", -14400},
+		{"UTC", `{"name": "Reykjavik", "sys": {"country": "IS"}, "timezone": 0}`, "IS", 0},
+	}
+
+	cfg := testConfig(t, "")
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var weather WeatherResponse
+
+			if err := decodeJSON([]byte(test.body), &weather); err != nil {
+				t.Fatalf("Error decoding the reading: %v", err)
+			}
+
+			p := weatherPoints(cfg, weather, weather.Name)[0]
+
+			if country, _ := pointTag(p, "country"); country != test.wantCountry {
+				t.Errorf("Tagged with country '%s', want '%s'", country, test.wantCountry)
+			}
+
+			if offset, _ := fieldValue(p, "timezone_offset"); offset != test.wantOffset {
+				t.Errorf("Stored timezone_offset %v, want %d", offset, test.wantOffset)
+			}
+		})
+	}
+}
+
+func TestProcessLocationsErrorPolicy(t *testing.T) {
+	tests := []struct {
+		policy string
+		wantFetches map[string]int
+		wantErrors int
+	}{
+		{"continue", map[string]int{"Lisbon": 1, "Porto": 1, "Faro": 1}, 1},
+		// Faro comes after the first error
+		{"abort", map[string]int{"Lisbon": 1, "Porto": 1, "Faro": 0}, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.policy, func(t *testing.T) {
+			cfg := testConfig(t, "[weather_api]\nlocations = [ \"Lisbon\", \"Porto\", \"Faro\" ]\n[sensor]\nerror_policy = \"" + test.policy + "\"\n")
+			p, _ := useFakes(t)
+			p.setFailing("Porto", fmt.Errorf("no route to host"))
+
+			errs := processLocations(context.Background(), cfg, cfg.Locations)
+
+			if got := countErrors(errs); got != test.wantErrors {
+				t.Errorf("Got %d errors, want %d", got, test.wantErrors)
+			}
+
+			for location, want := range test.wantFetches {
+				if got := p.fetchCount(location); got != want {
+					t.Errorf("Fetched '%s' %d times, want %d", location, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestWeatherPointsOmitAbsentGust(t *testing.T) {
+	tests := []struct {
+		name string
+		omit bool
+		body string
+		wantGust bool
+	}{
+		{"gust reported", true, `{"wind": {"speed": 3.5, "gust": 7.2}}`, true},
+		{"zero gust reported", true, `{"wind": {"speed": 0, "gust": 0}}`, true},
+		{"no gust reported", true, `{"wind": {"speed": 0}}`, false},
+		// Stored as zero unless told otherwise
+		{"no gust reported, not omitted", false, `{"wind": {"speed": 0}}`, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := testConfig(t, fmt.Sprintf("[influxdb]\nomit_absent_gust = %v\n", test.omit))
+
+			var weather WeatherResponse
+
+			if err := decodeJSON([]byte(test.body), &weather); err != nil {
+				t.Fatalf("Error decoding the reading: %v", err)
+			}
+
+			if _, ok := fieldValue(weatherPoints(cfg, weather, "Lisbon")[0], "wind_gusts"); ok != test.wantGust {
+				t.Errorf("Stored wind_gusts: %v, want %v", ok, test.wantGust)
+			}
+		})
+	}
+}
+
+func TestWeatherPointsStationTag(t *testing.T) {
+	tests := []struct {
+		name string
+		stationTag bool
+		id int
+		want string
+	}{
+		{"station reported", true, 6901, "6901"},
+		{"no station reported", true, 0, ""},
+		{"not tagged", false, 6901, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := testConfig(t, fmt.Sprintf("[influxdb]\nstation_tag = %v\n", test.stationTag))
+			weather := testReading("Lisbon")
+			weather.Sys.Id = test.id
+
+			got, ok := pointTag(weatherPoints(cfg, weather, "Lisbon")[0], "station_id")
+
+			if ok != (test.want != "") || got != test.want {
+				t.Errorf("Tagged with station_id '%s', want '%s'", got, test.want)
+			}
+		})
+	}
+}
+
+func TestReloadLocations(t *testing.T) {
+	tests := []struct {
+		name string
+		locations string
+		want []string
+	}{
+		{"new locations", "[ \"Porto\", \"Faro\" ]", []string{"Porto", "Faro"}},
+		// Most likely a bad edit, collecting carries on where it was
+		{"no locations", "[]", []string{"Lisbon"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := testConfig(t, "")
+			useFakes(t)
+
+			previousClient, previousDryRun := httpClient, *dryRunFlag
+			*dryRunFlag = true
+			t.Cleanup(func() { httpClient, *dryRunFlag = previousClient, previousDryRun })
+
+			dir := t.TempDir()
+			config := strings.Replace(baseConfig, "locations = [ \"Lisbon\" ]", "locations = " + test.locations, 1)
+
+			if err := os.WriteFile(filepath.Join(dir, "config.toml"), []byte(config), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			wd, err := os.Getwd()
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := os.Chdir(dir); err != nil {
+				t.Fatal(err)
+			}
+
+			defer os.Chdir(wd)
+
+			var got []string
+
+			for _, location := range reload(cfg).Locations {
+				got = append(got, location.Tag())
+			}
+
+			if strings.Join(got, ",") != strings.Join(test.want, ",") {
+				t.Errorf("Reloaded with locations %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestWeatherPointsIconURL(t *testing.T) {
+	tests := []struct {
+		name string
+		iconURL bool
+		weather []WeatherSpec
+		want string
+	}{
+		{"icon reported", true, []WeatherSpec{{Main: "Rain", Icon: "10d"}}, "https://openweathermap.org/img/wn/10d@2x.png"},
+		{"first condition's icon", true, []WeatherSpec{{Main: "Rain", Icon: "10n"}, {Main: "Mist", Icon: "50n"}}, "https://openweathermap.org/img/wn/10n@2x.png"},
+		{"no icon", true, []WeatherSpec{{Main: "Rain"}}, ""},
+		{"no conditions", true, nil, ""},
+		{"not stored", false, []WeatherSpec{{Main: "Rain", Icon: "10d"}}, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := testConfig(t, fmt.Sprintf("[influxdb]\nicon_url = %v\n", test.iconURL))
+			weather := testReading("Lisbon")
+			weather.Weather = test.weather
+
+			got, ok := fieldValue(weatherPoints(cfg, weather, "Lisbon")[0], "icon_url")
+
+			if ok != (test.want != "") || ok && got != test.want {
+				t.Errorf("Stored icon_url %v, want '%s'", got, test.want)
+			}
+		})
+	}
+}
+
+func TestWeatherPointsIngestLag(t *testing.T) {
+	tests := []struct {
+		name string
+		ingestLag bool
+		// Seconds since the reading was observed, at the fake clock's now
+		age int64
+		noTimestamp bool
+		want interface{}
+	}{
+		{"fresh reading", true, 0, false, int64(0)},
+		{"ten minutes old", true, 600, false, int64(600)},
+		{"no observation time", true, 0, true, nil},
+		{"not stored", false, 600, false, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := useSelfAdvancingClock(t)
+			cfg := testConfig(t, fmt.Sprintf("[influxdb]\ningest_lag = %v\n", test.ingestLag))
+
+			weather := testReading("Lisbon")
+			weather.Timestamp = int(c.Now().Unix() - test.age)
+
+			if test.noTimestamp {
+				weather.Timestamp = 0
+			}
+
+			got, _ := fieldValue(weatherPoints(cfg, weather, "Lisbon")[0], "ingest_lag_seconds")
+
+			if got != test.want {
+				t.Errorf("Stored ingest_lag_seconds %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestWeatherPointsAllUnits(t *testing.T) {
+	tests := []struct {
+		units string
+		temperature float32
+		windSpeed float32
+	}{
+		{"metric", 18.5, 4.4704},
+		{"imperial", 65.3, 10},
+		{"standard", 291.65, 4.4704},
+	}
+
+	// The same temperature and wind speed, whatever units they came in
+	want := map[string]float64{
+		"temperature_c": 18.5,
+		"temperature_f": 65.3,
+		"temperature_k": 291.65,
+		"wind_speed_ms": 4.4704,
+		"wind_speed_mph": 10,
+	}
+
+	for _, test := range tests {
+		t.Run(test.units, func(t *testing.T) {
+			cfg := testConfig(t, "[weather_api]\nunits = \"" + test.units + "\"\n[derived]\nall_units = true\n")
+			weather := testReading("Lisbon")
+			weather.Main.Temp = test.temperature
+			weather.Wind.Speed = test.windSpeed
+
+			p := weatherPoints(cfg, weather, "Lisbon")[0]
+
+			for key, value := range want {
+				got, ok := fieldValue(p, key)
+
+				if !ok {
+					t.Errorf("No %s field", key)
+					continue
+				}
+
+				if math.Abs(got.(float64) - value) > 0.01 {
+					t.Errorf("Stored %s %v, want %v", key, got, value)
+				}
+			}
+		})
+	}
+}
+
+func TestWeatherPointsWindBearingRadians(t *testing.T) {
+	tests := []struct {
+		name string
+		radians bool
+		bearing float32
+		want interface{}
+	}{
+		{"north", true, 0, 0.0},
+		{"east", true, 90, math.Pi / 2},
+		{"west", true, 270, 3 * math.Pi / 2},
+		{"not stored", false, 270, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := testConfig(t, fmt.Sprintf("[derived]\nwind_bearing_radians = %v\n", test.radians))
+			weather := testReading("Lisbon")
+			weather.Wind.Degree = test.bearing
+
+			p := weatherPoints(cfg, weather, "Lisbon")[0]
+			got, ok := fieldValue(p, "wind_bearing_rad")
+
+			if ok != (test.want != nil) || ok && math.Abs(got.(float64) - test.want.(float64)) > 1e-9 {
+				t.Errorf("Stored wind_bearing_rad %v, want %v", got, test.want)
+			}
+
+			// Degrees are stored either way
+			if degrees, _ := fieldValue(p, "wind_bearing"); degrees != float64(test.bearing) {
+				t.Errorf("Stored wind_bearing %v, want %v", degrees, test.bearing)
+			}
+		})
+	}
+}
+
+func TestProcessLocationsDelay(t *testing.T) {
+	tests := []struct {
+		name string
+		delay string
+		locations string
+		want time.Duration
+	}{
+		{"no delay", "0s", "[ \"Lisbon\", \"Porto\", \"Faro\" ]", 0},
+		// Only between locations, not before the first
+		{"between each", "2s", "[ \"Lisbon\", \"Porto\", \"Faro\" ]", 4 * time.Second},
+		{"single location", "2s", "[ \"Lisbon\" ]", 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := useSelfAdvancingClock(t)
+			cfg := testConfig(t, "[weather_api]\nlocations = " + test.locations + "\n[sensor]\ninter_location_delay = \"" + test.delay + "\"\n")
+			useFakes(t)
+
+			start := c.Now()
+
+			if errs := processLocations(context.Background(), cfg, cfg.Locations); countErrors(errs) > 0 {
+				t.Fatalf("processLocations returned %v", errs)
+			}
+
+			if got := c.Now().Sub(start); got != test.want {
+				t.Errorf("Cycle took %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestWeatherPointsConditions(t *testing.T) {
+	tests := []struct {
+		name string
+		conditions bool
+		weather []WeatherSpec
+		wantCount interface{}
+		wantConditions interface{}
+	}{
+		{"one condition", true, []WeatherSpec{{Main: "Rain"}}, int64(1), "Rain"},
+		{"several conditions", true, []WeatherSpec{{Main: "Rain"}, {Main: "Mist"}}, int64(2), "Rain,Mist"},
+		{"no conditions", true, nil, int64(0), nil},
+		{"not stored", false, []WeatherSpec{{Main: "Rain"}}, nil, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := testConfig(t, fmt.Sprintf("[influxdb]\nconditions = %v\n", test.conditions))
+			weather := testReading("Lisbon")
+			weather.Weather = test.weather
+
+			p := weatherPoints(cfg, weather, "Lisbon")[0]
+
+			if got, _ := fieldValue(p, "conditions_count"); got != test.wantCount {
+				t.Errorf("Stored conditions_count %v, want %v", got, test.wantCount)
+			}
+
+			if got, _ := fieldValue(p, "conditions"); got != test.wantConditions {
+				t.Errorf("Stored conditions %v, want %v", got, test.wantConditions)
+			}
+		})
+	}
+}
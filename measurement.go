@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Values a measurement template can refer to as {name}
+var measurementVars = map[string]func(cfg *Config, weather WeatherResponse, location string) string{
+	"location": func(cfg *Config, weather WeatherResponse, location string) string {
+		return location
+	},
+	"city": func(cfg *Config, weather WeatherResponse, location string) string {
+		return weather.Name
+	},
+	"country": func(cfg *Config, weather WeatherResponse, location string) string {
+		return weather.Sys.Country
+	},
+	"provider": func(cfg *Config, weather WeatherResponse, location string) string {
+		if weather.Source != "" {
+			return weather.Source
+		}
+
+		return cfg.WeatherAPI.Provider
+	},
+	"units": func(cfg *Config, weather WeatherResponse, location string) string {
+		return cfg.WeatherAPI.Units
+	},
+}
+
+var measurementVar = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// Characters a measurement is limited to, anything else a value renders
+// to is replaced with an underscore
+var measurementUnsafe = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// checkMeasurement makes sure a measurement template only refers to known
+// values and is a valid measurement name once they're filled in
+func checkMeasurement(template string) error {
+	for _, match := range measurementVar.FindAllStringSubmatch(template, -1) {
+		if _, ok := measurementVars[match[1]]; !ok {
+			return fmt.Errorf("Unknown variable '{%s}' in influxdb.measurement", match[1])
+		}
+	}
+
+	literal := measurementVar.ReplaceAllString(template, "x")
+
+	if literal == "" || strings.HasPrefix(literal, "_") || measurementUnsafe.MatchString(literal) {
+		return fmt.Errorf("Invalid influxdb.measurement '%s'", template)
+	}
+
+	return nil
+}
+
+// measurementName renders the measurement template for a reading. Values
+// that are empty, such as the country of a reading that has none, render
+// as "unknown".
+func measurementName(cfg *Config, weather WeatherResponse, location string) string {
+	template := cfg.InfluxDB.Measurement
+
+	if !strings.Contains(template, "{") {
+		return template
+	}
+
+	return measurementVar.ReplaceAllStringFunc(template, func(match string) string {
+		value := measurementVars[match[1:len(match) - 1]](cfg, weather, location)
+
+		if value == "" {
+			return "unknown"
+		}
+
+		return measurementUnsafe.ReplaceAllString(strings.ToLower(value), "_")
+	})
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCheckMeasurement(t *testing.T) {
+	tests := []struct {
+		template string
+		wantErr bool
+	}{
+		{"weather", false},
+		{"weather_{country}", false},
+		{"{provider}.{units}", false},
+		{"{location}", false},
+		{"weather_{region}", true},
+		{"", true},
+		{"_weather", true},
+		{"weather data", true},
+		{"weather_{country", true},
+	}
+
+	for _, test := range tests {
+		if err := checkMeasurement(test.template); (err != nil) != test.wantErr {
+			t.Errorf("checkMeasurement(%q) returned %v, want an error: %v", test.template, err, test.wantErr)
+		}
+	}
+}
+
+func TestMeasurementName(t *testing.T) {
+	tests := []struct {
+		template string
+		source string
+		country string
+		want string
+	}{
+		{"weather", "", "PT", "weather"},
+		{"weather_{country}", "", "PT", "weather_pt"},
+		{"weather_{country}", "", "", "weather_unknown"},
+		{"weather_{location}", "", "PT", "weather_s_o_paulo_centro"},
+		{"{provider}_{units}", "", "PT", "openweathermap_metric"},
+		{"{provider}", "open-meteo", "PT", "open-meteo"},
+	}
+
+	for _, test := range tests {
+		cfg := &Config{}
+		cfg.InfluxDB.Measurement = test.template
+		cfg.WeatherAPI.Provider = "openweathermap"
+		cfg.WeatherAPI.Units = "metric"
+
+		weather := testReading("São Paulo")
+		weather.Sys.Country = test.country
+		weather.Source = test.source
+
+		if got := measurementName(cfg, weather, "São Paulo centro"); got != test.want {
+			t.Errorf("Template '%s' renders as '%s', want '%s'", test.template, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var apiCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "weather_sensor_api_calls_total",
+	Help: "Number of calls made to the weather API, by API key and endpoint.",
+}, []string{"key", "endpoint"})
+
+var fetchResults = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "weather_sensor_fetches_total",
+	Help: "Number of locations fetched, by whether it worked or where it failed.",
+}, []string{"result"})
+
+var fetchDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name: "weather_sensor_fetch_duration_seconds",
+	Help: "How long fetching a location took, by whether it worked or where it failed.",
+	Buckets: prometheus.DefBuckets,
+}, []string{"result"})
+
+var panicsRecovered = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "weather_sensor_panics_total",
+	Help: "Number of panics recovered from while processing a location, by location.",
+}, []string{"location"})
+
+var rejectedReadings = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "weather_sensor_rejected_readings_total",
+	Help: "Number of readings rejected as implausible, by location and the value that was off.",
+}, []string{"location", "reason"})
+
+// observeFetch records how long a fetch took. When it's traced the trace ID
+// is attached as an exemplar, so a slow fetch leads straight to its trace.
+func observeFetch(ctx context.Context, result string, d time.Duration) {
+	observer := fetchDuration.WithLabelValues(result)
+
+	if sc := trace.SpanContextFromContext(ctx); sc.IsSampled() {
+		observer.(prometheus.ExemplarObserver).ObserveWithExemplar(d.Seconds(), prometheus.Labels{"trace_id": sc.TraceID().String()})
+		return
+	}
+
+	observer.Observe(d.Seconds())
+}
+
+// API calls made since the last daily summary, keyed by endpoint
+var dailyCalls = map[string]int{}
+var dailyCallsMutex sync.Mutex
+
+// keyLabel identifies an API key without exposing it
+func keyLabel(appid string) string {
+	if len(appid) <= 4 {
+		return "****"
+	}
+
+	return "****" + appid[len(appid) - 4:]
+}
+
+// countAPICall records a call made with an API key to one of the weather API
+// endpoints
+func countAPICall(appid string, endpoint string) {
+	apiCalls.WithLabelValues(keyLabel(appid), endpoint).Inc()
+
+	dailyCallsMutex.Lock()
+	dailyCalls[endpoint]++
+	dailyCallsMutex.Unlock()
+}
+
+// logDailyCalls logs how many API calls were made each day so they can be
+// reconciled against the quota on the OpenWeatherMap dashboard
+func logDailyCalls() {
+	for range clock.NewTicker(24 * time.Hour).C() {
+		dailyCallsMutex.Lock()
+
+		total := 0
+
+		for _, calls := range dailyCalls {
+			total += calls
+		}
+
+		log.Printf("Made %d weather API calls in the last 24 hours: %v", total, dailyCalls)
+
+		dailyCalls = map[string]int{}
+		dailyCallsMutex.Unlock()
+	}
+}
+
+// healthz reports whether every sink is healthy
+func healthz(w http.ResponseWriter, r *http.Request) {
+	s, release := acquireSink()
+	defer release()
+
+	// Nothing is written on dry runs
+	if s == nil {
+		fmt.Fprintln(w, "ok")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10 * time.Second)
+	defer cancel()
+
+	if err := s.Health(ctx); err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, err)
+		return
+	}
+
+	fmt.Fprintln(w, "ok")
+}
+
+// requireAuth turns away requests without the configured bearer token or
+// basic auth credentials. Nothing is required when neither is configured.
+func requireAuth(cfg AuthConfig, next http.Handler) http.Handler {
+	if cfg.Token == "" && cfg.Username == "" {
+		return next
+	}
+
+	equal := func(a string, b string) bool {
+		return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cfg.Token != "" && equal(r.Header.Get("Authorization"), "Bearer " + cfg.Token) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if username, password, ok := r.BasicAuth(); ok && cfg.Username != "" && equal(username, cfg.Username) && equal(password, cfg.Password) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if cfg.Username != "" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="weather-sensor"`)
+		}
+
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	})
+}
+
+// evicting drops readings older than the TTL before they're served, unless
+// the TTL is 0
+func evicting(ttl time.Duration, next http.Handler) http.Handler {
+	if ttl <= 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		evictReadings(ttl)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// serveMetrics exposes the Prometheus metrics and health check on the
+// configured address
+func serveMetrics(cfg MetricsConfig) {
+	// Exemplars only make it into the OpenMetrics format
+	handler := promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true}))
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", evicting(cfg.ReadingTTL, handler))
+	mux.Handle("/metrics/", evicting(cfg.ReadingTTL, http.HandlerFunc(locationMetrics)))
+	mux.HandleFunc("/healthz", healthz)
+
+	log.Printf("Serving metrics on %s", cfg.Listen)
+
+	if err := http.ListenAndServe(cfg.Listen, requireAuth(cfg.Auth, mux)); err != nil {
+		log.Fatalf("Error serving metrics: %v", err)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// fetchExemplar returns the trace ID on the latest exemplar of the fetch
+// durations with the result, if any
+func fetchExemplar(t *testing.T, result string) string {
+	t.Helper()
+
+	var m dto.Metric
+
+	if err := fetchDuration.WithLabelValues(result).(prometheus.Metric).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+
+	var latest *dto.Exemplar
+
+	for _, b := range m.GetHistogram().GetBucket() {
+		if e := b.GetExemplar(); e != nil && (latest == nil || e.GetTimestamp().AsTime().After(latest.GetTimestamp().AsTime())) {
+			latest = e
+		}
+	}
+
+	for _, l := range latest.GetLabel() {
+		if l.GetName() == "trace_id" {
+			return l.GetValue()
+		}
+	}
+
+	return ""
+}
+
+func TestObserveFetch(t *testing.T) {
+	tp := sdktrace.NewTracerProvider()
+	defer tp.Shutdown(context.Background())
+
+	unsampled := sdktrace.NewTracerProvider(sdktrace.WithSampler(sdktrace.NeverSample()))
+	defer unsampled.Shutdown(context.Background())
+
+	tests := []struct {
+		name string
+		tracer trace.TracerProvider
+		wantExemplar bool
+	}{
+		{"traced", tp, true},
+		{"not traced", nil, false},
+		{"not sampled", unsampled, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			result := "test_" + test.name
+
+			if test.tracer != nil {
+				var span trace.Span
+				ctx, span = test.tracer.Tracer("test").Start(ctx, "cycle")
+				defer span.End()
+			}
+
+			observeFetch(ctx, result, 300 * time.Millisecond)
+
+			want := ""
+
+			if test.wantExemplar {
+				want = trace.SpanContextFromContext(ctx).TraceID().String()
+			}
+
+			if got := fetchExemplar(t, result); got != want {
+				t.Errorf("Exemplar has trace ID '%s', want '%s'", got, want)
+			}
+		})
+	}
+}
+
+func TestProcessLocationFetchExemplar(t *testing.T) {
+	cfg := testConfig(t, "")
+	useFakes(t)
+
+	tp := sdktrace.NewTracerProvider()
+	defer tp.Shutdown(context.Background())
+
+	ctx, span := tp.Tracer("test").Start(context.Background(), "cycle")
+	defer span.End()
+
+	if err := processLocation(ctx, cfg, cfg.Locations[0]); err != nil {
+		t.Fatalf("Processing the location returned %v", err)
+	}
+
+	if got, want := fetchExemplar(t, "success"), span.SpanContext().TraceID().String(); got != want {
+		t.Errorf("Fetch duration exemplar has trace ID '%s', want the cycle's '%s'", got, want)
+	}
+}
+
+func TestKeyLabel(t *testing.T) {
+	tests := []struct {
+		appid string
+		want string
+	}{
+		{"0123456789abcdef", "****cdef"},
+		{"abcde", "****bcde"},
+		// Too short to show any of it
+		{"abcd", "****"},
+		{"", "****"},
+	}
+
+	for _, test := range tests {
+		if got := keyLabel(test.appid); got != test.want {
+			t.Errorf("keyLabel(%q) is '%s', want '%s'", test.appid, got, test.want)
+		}
+	}
+}
+
+func TestCountAPICall(t *testing.T) {
+	dailyCalls = map[string]int{}
+
+	tests := []struct {
+		appid string
+		endpoint string
+		calls int
+	}{
+		{"0123456789abcdef", "current", 3},
+		{"0123456789abcdef", "onecall", 1},
+		{"fedcba9876543210", "current", 2},
+	}
+
+	before := map[int]float64{}
+
+	for i, test := range tests {
+		before[i] = testutil.ToFloat64(apiCalls.WithLabelValues(keyLabel(test.appid), test.endpoint))
+
+		for n := 0; n < test.calls; n++ {
+			countAPICall(test.appid, test.endpoint)
+		}
+	}
+
+	for i, test := range tests {
+		if got := testutil.ToFloat64(apiCalls.WithLabelValues(keyLabel(test.appid), test.endpoint)) - before[i]; got != float64(test.calls) {
+			t.Errorf("Counted %v %s calls with %s, want %d", got, test.endpoint, keyLabel(test.appid), test.calls)
+		}
+	}
+
+	// The daily count adds up every key
+	if dailyCalls["current"] != 5 || dailyCalls["onecall"] != 1 {
+		t.Errorf("Counted %v calls today, want 5 current and 1 onecall", dailyCalls)
+	}
+}
+
+func TestRequireAuth(t *testing.T) {
+	bearer := AuthConfig{Token: "secret"}
+	basic := AuthConfig{Username: "prometheus", Password: "hunter2"}
+	both := AuthConfig{Token: "secret", Username: "prometheus", Password: "hunter2"}
+
+	tests := []struct {
+		name string
+		cfg AuthConfig
+		// Sets the request's credentials, if any
+		auth func(r *http.Request)
+		want int
+	}{
+		{"no auth configured", AuthConfig{}, func(r *http.Request) {}, http.StatusOK},
+		{"token", bearer, func(r *http.Request) { r.Header.Set("Authorization", "Bearer secret") }, http.StatusOK},
+		{"wrong token", bearer, func(r *http.Request) { r.Header.Set("Authorization", "Bearer guess") }, http.StatusUnauthorized},
+		{"no token", bearer, func(r *http.Request) {}, http.StatusUnauthorized},
+		{"credentials", basic, func(r *http.Request) { r.SetBasicAuth("prometheus", "hunter2") }, http.StatusOK},
+		{"wrong password", basic, func(r *http.Request) { r.SetBasicAuth("prometheus", "guess") }, http.StatusUnauthorized},
+		{"empty password", basic, func(r *http.Request) { r.SetBasicAuth("prometheus", "") }, http.StatusUnauthorized},
+		{"token where credentials are wanted", basic, func(r *http.Request) { r.Header.Set("Authorization", "Bearer hunter2") }, http.StatusUnauthorized},
+		{"either works, token", both, func(r *http.Request) { r.Header.Set("Authorization", "Bearer secret") }, http.StatusOK},
+		{"either works, credentials", both, func(r *http.Request) { r.SetBasicAuth("prometheus", "hunter2") }, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler := requireAuth(test.cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintln(w, "ok")
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			test.auth(r)
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, r)
+
+			if w.Code != test.want {
+				t.Errorf("Answered %d, want %d", w.Code, test.want)
+			}
+
+			// Browsers only ask for credentials when told to
+			if challenge := w.Header().Get("WWW-Authenticate"); w.Code == http.StatusUnauthorized && (challenge != "") != (test.cfg.Username != "") {
+				t.Errorf("Answered with challenge '%s'", challenge)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const openMeteoURL = "https://api.open-meteo.com/v1/forecast"
+
+// Current conditions as requested from the Open-Meteo forecast API
+type openMeteoResponse struct {
+	Latitude float32 `json:"latitude"`
+	Longitude float32 `json:"longitude"`
+	UTCOffset int `json:"utc_offset_seconds"`
+	Current struct {
+		Time int `json:"time"`
+		Temperature float32 `json:"temperature_2m"`
+		ApparentTemperature float32 `json:"apparent_temperature"`
+		Humidity float32 `json:"relative_humidity_2m"`
+		Pressure float32 `json:"pressure_msl"`
+		SurfacePressure float32 `json:"surface_pressure"`
+		CloudCover int `json:"cloud_cover"`
+		Visibility float64 `json:"visibility"`
+		WindSpeed float32 `json:"wind_speed_10m"`
+		WindDirection float32 `json:"wind_direction_10m"`
+		WindGusts float32 `json:"wind_gusts_10m"`
+		Rain float32 `json:"rain"`
+		Snowfall float32 `json:"snowfall"`
+	} `json:"current"`
+}
+
+// openMeteoProvider fetches from Open-Meteo, which needs no key but only
+// knows locations by their coordinates
+type openMeteoProvider struct {
+	cfg WeatherAPIConfig
+}
+
+func (p openMeteoProvider) Name() string {
+	return "open-meteo"
+}
+
+func (p openMeteoProvider) Fetch(ctx context.Context, location Location) (WeatherResponse, error) {
+	var res openMeteoResponse
+
+	if !location.HasCoordinates {
+		return WeatherResponse{}, fmt.Errorf("Open-Meteo needs coordinates for location '%s'", location.Tag())
+	}
+
+	ctx, span := tracer.Start(ctx, "fetch", trace.WithAttributes(attribute.String("location", location.Tag())))
+	defer span.End()
+
+	params := url.Values{}
+	params.Add("latitude", strconv.FormatFloat(location.Latitude, 'f', -1, 64))
+	params.Add("longitude", strconv.FormatFloat(location.Longitude, 'f', -1, 64))
+	params.Add("current", "temperature_2m,apparent_temperature,relative_humidity_2m,pressure_msl,surface_pressure,cloud_cover,visibility,wind_speed_10m,wind_direction_10m,wind_gusts_10m,rain,snowfall")
+	params.Add("timeformat", "unixtime")
+	params.Add("timezone", "auto")
+
+	// Open-Meteo has no kelvin, standard units are converted below
+	if p.cfg.Units == "imperial" {
+		params.Add("temperature_unit", "fahrenheit")
+		params.Add("wind_speed_unit", "mph")
+	} else {
+		params.Add("temperature_unit", "celsius")
+		params.Add("wind_speed_unit", "ms")
+	}
+
+	// Its current conditions are timed like any other current weather call
+	ctx, cancel := context.WithTimeout(ctx, p.cfg.timeout("current"))
+	defer cancel()
+
+	if err := getJSON(ctx, openMeteoURL + "?" + params.Encode(), p.cfg.MaxBodyBytes, &res); err != nil {
+		return WeatherResponse{}, err
+	}
+
+	return res.weatherResponse(location, p.cfg.Units), nil
+}
+
+// weatherResponse maps Open-Meteo's current conditions onto the fields of an
+// OpenWeatherMap reading. Precipitation is over the interval preceding the
+// reading and is stored as the last hour's.
+func (res openMeteoResponse) weatherResponse(location Location, units string) WeatherResponse {
+	c := res.Current
+	temperature, feelsLike := c.Temperature, c.ApparentTemperature
+
+	if units != "metric" && units != "imperial" {
+		temperature += 273.15
+		feelsLike += 273.15
+	}
+
+	return WeatherResponse{
+		Coordinates: PointSpec{Longitude: res.Longitude, Latitude: res.Latitude},
+		Main: MainSpec{
+			Temp: temperature,
+			FeelsLike: feelsLike,
+			TempMin: temperature,
+			TempMax: temperature,
+			Pressure: c.Pressure,
+			Humidity: c.Humidity,
+			SeaLevel: c.Pressure,
+			GroundLevel: c.SurfacePressure,
+			HasSeaLevel: true,
+			HasGroundLevel: true,
+		},
+		Visibility: int(c.Visibility),
+		HasVisibility: true,
+		Wind: WindSpec{Speed: c.WindSpeed, Degree: c.WindDirection, Gust: c.WindGusts, HasGust: true},
+		Clouds: CloudSpec{All: c.CloudCover},
+		Rain: RainSpec{LastHour: c.Rain},
+		// Snowfall comes in centimetres
+		Snow: SnowSpec{LastHour: c.Snowfall * 10},
+		Timestamp: c.Time,
+		Timezone: res.UTCOffset,
+		Name: location.Name,
+	}
+}
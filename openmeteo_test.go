@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestOpenMeteoFetch(t *testing.T) {
+	tests := []struct {
+		units string
+		wantTemperatureUnit string
+		wantWindSpeedUnit string
+		wantTemp float32
+	}{
+		{"metric", "celsius", "ms", 18.5},
+		{"imperial", "fahrenheit", "mph", 18.5},
+		// Kelvin converted from celsius
+		{"standard", "celsius", "ms", 18.5 + 273.15},
+	}
+
+	for _, test := range tests {
+		t.Run(test.units, func(t *testing.T) {
+			var query url.Values
+
+			useAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				query = r.URL.Query()
+				fmt.Fprint(w, `{"latitude": 38.7, "longitude": -9.14, "utc_offset_seconds": 3600, "current": {"time": 1654084800, "temperature_2m": 18.5, "relative_humidity_2m": 70, "pressure_msl": 1015, "surface_pressure": 1003, "cloud_cover": 40, "visibility": 24140.5, "wind_speed_10m": 3.5, "wind_gusts_10m": 7, "rain": 0.4, "snowfall": 0.2}}`)
+			}))
+
+			p := openMeteoProvider{WeatherAPIConfig{Units: test.units, Timeout: 30, MaxBodyBytes: 1 << 20}}
+			res, err := p.Fetch(context.Background(), Location{Name: "Lisbon", Latitude: 38.7, Longitude: -9.14, HasCoordinates: true})
+
+			if err != nil {
+				t.Fatalf("Fetch returned %v", err)
+			}
+
+			if got := query.Get("temperature_unit"); got != test.wantTemperatureUnit {
+				t.Errorf("Asked for temperatures in %s, want %s", got, test.wantTemperatureUnit)
+			}
+
+			if got := query.Get("wind_speed_unit"); got != test.wantWindSpeedUnit {
+				t.Errorf("Asked for wind speeds in %s, want %s", got, test.wantWindSpeedUnit)
+			}
+
+			if query.Get("latitude") != "38.7" || query.Get("longitude") != "-9.14" {
+				t.Errorf("Asked for %s,%s, want 38.7,-9.14", query.Get("latitude"), query.Get("longitude"))
+			}
+
+			if res.Main.Temp != test.wantTemp {
+				t.Errorf("Temperature is %v, want %v", res.Main.Temp, test.wantTemp)
+			}
+
+			if res.Name != "Lisbon" || res.Timestamp != 1654084800 || res.Timezone != 3600 {
+				t.Errorf("Reading is for '%s' at %d%+d, want Lisbon at 1654084800+3600", res.Name, res.Timestamp, res.Timezone)
+			}
+
+			if res.Main.GroundLevel != 1003 || !res.Main.HasGroundLevel || res.Visibility != 24140 || res.Wind.Gust != 7 {
+				t.Errorf("Mapped %+v, want the ground level, visibility and gusts", res)
+			}
+
+			if res.Snow.LastHour != 2 {
+				t.Errorf("Snow is %vmm, want 2mm from 0.2cm", res.Snow.LastHour)
+			}
+		})
+	}
+}
+
+func TestOpenMeteoNeedsCoordinates(t *testing.T) {
+	p := openMeteoProvider{WeatherAPIConfig{Units: "metric", Timeout: 30}}
+
+	if _, err := p.Fetch(context.Background(), Location{Name: "Lisbon"}); err == nil {
+		t.Errorf("Fetched a location without coordinates")
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// OpenWeatherMap API hosts for each subscription plan
+var apiHosts = map[string]string{
+	"free": "api.openweathermap.org",
+	"pro": "pro.openweathermap.org",
+}
+
+// Paths of the OpenWeatherMap endpoints by the name calls are counted and
+// timeouts configured under
+var endpoints = map[string]string{
+	"current": "/data/2.5/weather",
+	"find": "/data/2.5/find",
+	// The 4 day hourly forecast, only on paid plans
+	"hourly": "/data/2.5/forecast/hourly",
+	"onecall": "/data/3.0/onecall",
+	"zip": "/geo/1.0/zip",
+}
+
+// Endpoints served from the plan's own host. One Call and geocoding are
+// served from the free host whatever the plan.
+var planEndpoints = map[string]bool{
+	"current": true,
+	"find": true,
+	"hourly": true,
+}
+
+// apiHost is the host serving an endpoint under a plan
+func apiHost(plan string, endpoint string) string {
+	if planEndpoints[endpoint] {
+		return apiHosts[plan]
+	}
+
+	return apiHosts["free"]
+}
+
+// queryParams addresses a location by coordinates if it has them, by zip
+// code or name otherwise
+func queryParams(cfg WeatherAPIConfig, location Location) url.Values {
+	params := url.Values{}
+
+	if location.HasCoordinates {
+		params.Add("lat", strconv.FormatFloat(location.Latitude, 'f', -1, 64))
+		params.Add("lon", strconv.FormatFloat(location.Longitude, 'f', -1, 64))
+	} else if location.Zip != "" {
+		// Not geocoded yet, e.g. when benchmarking
+		params.Add("zip", location.Zip)
+	} else {
+		params.Add("q", location.Name)
+	}
+
+	params.Add("appid", cfg.AppID)
+	params.Add("units", cfg.Units)
+
+	return params
+}
+
+// apiGet requests one of the weather API endpoints, counting the call under
+// its name, and decodes the response into out
+func apiGet(ctx context.Context, cfg WeatherAPIConfig, name string, params url.Values, out interface{}) error {
+	baseUrl, err := url.Parse("https://" + apiHost(cfg.Plan, name) + endpoints[name])
+
+	if err != nil {
+		return err
+	}
+
+	baseUrl.RawQuery = params.Encode()
+
+	countAPICall(cfg.AppID, name)
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.timeout(name))
+	defer cancel()
+
+	return getJSON(ctx, baseUrl.String(), cfg.MaxBodyBytes, out)
+}
+
+// owmProvider fetches from the OpenWeatherMap API, the default provider
+type owmProvider struct {
+	cfg WeatherAPIConfig
+}
+
+func (p owmProvider) Name() string {
+	return "openweathermap"
+}
+
+func (p owmProvider) Fetch(ctx context.Context, location Location) (WeatherResponse, error) {
+	var res WeatherResponse
+
+	ctx, span := tracer.Start(ctx, "fetch", trace.WithAttributes(attribute.String("location", location.Tag())))
+	defer span.End()
+
+	err := apiGet(ctx, p.cfg, "current", queryParams(p.cfg, location), &res)
+
+	return res, err
+}
+
+// FetchNearby fetches the readings of the stations closest to a location
+func (p owmProvider) FetchNearby(ctx context.Context, location Location) ([]WeatherResponse, error) {
+	var res FindResponse
+
+	ctx, span := tracer.Start(ctx, "fetch", trace.WithAttributes(attribute.String("location", location.Tag())))
+	defer span.End()
+
+	params := queryParams(p.cfg, location)
+	params.Add("cnt", strconv.Itoa(location.Nearby))
+
+	err := apiGet(ctx, p.cfg, "find", params, &res)
+
+	return res.List, err
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestQueryParams(t *testing.T) {
+	cfg := WeatherAPIConfig{AppID: "test", Units: "metric"}
+
+	tests := []struct {
+		name string
+		location Location
+		want string
+	}{
+		{"by name", Location{Name: "Lisbon,PT"}, "appid=test&q=Lisbon%2CPT&units=metric"},
+		{"by zip code", Location{Name: "1000-001,PT", Zip: "1000-001,PT"}, "appid=test&units=metric&zip=1000-001%2CPT"},
+		{"by coordinates", Location{Name: "Lisbon", Latitude: 38.7167, Longitude: -9.1333, HasCoordinates: true}, "appid=test&lat=38.7167&lon=-9.1333&units=metric"},
+		// A geocoded zip code goes by its coordinates
+		{"geocoded zip code", Location{Name: "1000-001,PT", Zip: "1000-001,PT", Latitude: 38.7167, Longitude: -9.1333, HasCoordinates: true}, "appid=test&lat=38.7167&lon=-9.1333&units=metric"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := queryParams(cfg, test.location).Encode(); got != test.want {
+				t.Errorf("Got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestAPIHost(t *testing.T) {
+	tests := []struct {
+		plan string
+		endpoint string
+		want string
+	}{
+		{"free", "current", "api.openweathermap.org"},
+		{"free", "find", "api.openweathermap.org"},
+		{"free", "onecall", "api.openweathermap.org"},
+		{"free", "zip", "api.openweathermap.org"},
+		{"pro", "current", "pro.openweathermap.org"},
+		{"pro", "find", "pro.openweathermap.org"},
+		{"pro", "hourly", "pro.openweathermap.org"},
+		// Served from the same host whatever the plan
+		{"pro", "onecall", "api.openweathermap.org"},
+		{"pro", "zip", "api.openweathermap.org"},
+	}
+
+	for _, test := range tests {
+		if got := apiHost(test.plan, test.endpoint); got != test.want {
+			t.Errorf("Plan %s sends %s to %s, want %s", test.plan, test.endpoint, got, test.want)
+		}
+	}
+}
+
+func TestAPIGetHost(t *testing.T) {
+	tests := []struct {
+		plan string
+		endpoint string
+		wantHost string
+	}{
+		{"free", "current", "api.openweathermap.org"},
+		{"pro", "current", "pro.openweathermap.org"},
+		{"pro", "zip", "api.openweathermap.org"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.plan + " " + test.endpoint, func(t *testing.T) {
+			var host string
+
+			ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, "{}")
+			}))
+			defer ts.Close()
+
+			previous := httpClient
+			httpClient = &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				host = req.URL.Host
+				return rerouteTransport{ts.Listener.Addr().String(), ts.Client().Transport}.RoundTrip(req)
+			})}
+			defer func() { httpClient = previous }()
+
+			var out struct{}
+
+			if err := apiGet(context.Background(), WeatherAPIConfig{Plan: test.plan, Timeout: 30, MaxBodyBytes: 1 << 20}, test.endpoint, url.Values{}, &out); err != nil {
+				t.Fatalf("apiGet returned %v", err)
+			}
+
+			if host != test.wantHost {
+				t.Errorf("Requested %s, want %s", host, test.wantHost)
+			}
+		})
+	}
+}
+
+// roundTripFunc lets a function stand in for a transport
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestProcessLocationNearby(t *testing.T) {
+	tests := []struct {
+		name string
+		nearby int
+		wantPath string
+		wantSeries []string
+	}{
+		{"location only", 0, "/data/2.5/weather", []string{"Lisbon"}},
+		{"nearby stations", 2, "/data/2.5/find", []string{"Lisbon/Belém", "Lisbon/Lisboa"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := testConfig(t, "")
+			_, s := useFakes(t)
+			provider = owmProvider{cfg.WeatherAPI}
+
+			var path, cnt string
+
+			useAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				path, cnt = r.URL.Path, r.URL.Query().Get("cnt")
+
+				if r.URL.Path == "/data/2.5/find" {
+					fmt.Fprint(w, `{"count": 2, "list": [{"name": "Belém", "main": {"temp": 18.5}}, {"name": "Lisboa", "main": {"temp": 19}}]}`)
+					return
+				}
+
+				fmt.Fprint(w, `{"name": "Lisboa", "main": {"temp": 18.5}}`)
+			}))
+
+			location := Location{Name: "Lisbon", Nearby: test.nearby}
+
+			if err := processLocation(context.Background(), cfg, location); err != nil {
+				t.Fatalf("processLocation returned %v", err)
+			}
+
+			if path != test.wantPath {
+				t.Errorf("Requested %s, want %s", path, test.wantPath)
+			}
+
+			if test.nearby > 0 && cnt != fmt.Sprint(test.nearby) {
+				t.Errorf("Asked for %s stations, want %d", cnt, test.nearby)
+			}
+
+			for _, series := range test.wantSeries {
+				if got := len(s.written(series)); got != 1 {
+					t.Errorf("Wrote %d points for '%s', want 1", got, series)
+				}
+			}
+		})
+	}
+}
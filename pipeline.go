@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+type batch struct {
+	ctx context.Context
+	cfg SensorConfig
+	location string
+	points []*write.Point
+}
+
+// pipeline hands points over to dedicated writers so a slow sink doesn't
+// hold up fetching the next locations, and the other way around
+type pipeline struct {
+	queue chan batch
+	dropOldest bool
+	dropped int64
+	done chan bool
+}
+
+// Set when writes go through the pipeline rather than straight to the sink
+var writes *pipeline
+
+func startPipeline(cfg PipelineConfig) *pipeline {
+	p := &pipeline{
+		queue: make(chan batch, cfg.BufferSize),
+		dropOldest: cfg.WhenFull == "drop_oldest",
+		done: make(chan bool),
+	}
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < cfg.Workers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			p.run()
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(p.done)
+	}()
+
+	return p
+}
+
+// run writes queued batches until the queue is closed, alongside the other
+// writers
+func (p *pipeline) run() {
+	for b := range p.queue {
+		s, release := acquireSink()
+		start := clock.Now()
+		err := s.Write(b.ctx, b.points)
+		release()
+		checkSinks(b.cfg, err)
+		recordWrite(start, len(b.points), err)
+
+		if err != nil {
+			logThrottled("Error writing the weather for location '%s': %v", b.location, err)
+			continue
+		}
+
+		atomic.AddInt64(&stats.points, int64(len(b.points)))
+	}
+}
+
+// enqueue queues points for writing. With a full buffer it either waits for
+// room or throws away the oldest queued points to make some.
+func (p *pipeline) enqueue(ctx context.Context, cfg SensorConfig, location string, points []*write.Point) {
+	b := batch{ctx, cfg, location, points}
+
+	if !p.dropOldest {
+		p.queue <- b
+		return
+	}
+
+	for {
+		select {
+		case p.queue <- b:
+			return
+		default:
+		}
+
+		select {
+		case old := <-p.queue:
+			log.Printf("Write buffer full, dropped points for location '%s' (%d so far)", old.location, atomic.AddInt64(&p.dropped, 1))
+		default:
+		}
+	}
+}
+
+// drain writes whatever is still queued, giving up after the timeout
+func (p *pipeline) drain(timeout time.Duration) {
+	close(p.queue)
+
+	select {
+	case <-p.done:
+	case <-clock.After(timeout):
+		log.Printf("Gave up on %d queued writes after %v", len(p.queue), timeout)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+// writtenLocations lists the locations a sink got points for, in order
+func writtenLocations(s *memorySink) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var locations []string
+
+	for _, p := range s.points {
+		for _, tag := range p.TagList() {
+			if tag.Key == "location" {
+				locations = append(locations, tag.Value)
+			}
+		}
+	}
+
+	return strings.Join(locations, " ")
+}
+
+func TestPipelineWhenFull(t *testing.T) {
+	tests := []struct {
+		whenFull string
+		// Whether enqueueing waits for room
+		wantBlocked bool
+		want string
+	}{
+		{"block", true, "1 2 3 4"},
+		{"drop_oldest", false, "1 3 4"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.whenFull, func(t *testing.T) {
+			s := &gatedSink{started: make(chan bool, 10), gate: make(chan bool)}
+
+			previous := currentSink()
+			setSink(s)
+			defer setSink(previous)
+
+			p := startPipeline(PipelineConfig{BufferSize: 2, WhenFull: test.whenFull, Workers: 1})
+
+			enqueue := func(location string) {
+				p.enqueue(context.Background(), SensorConfig{}, location, []*write.Point{locationPoint(location)})
+			}
+
+			// The writer is stuck on the first batch, the next two fill the buffer
+			enqueue("1")
+			<-s.started
+			enqueue("2")
+			enqueue("3")
+
+			enqueued := make(chan bool)
+
+			go func() {
+				enqueue("4")
+				close(enqueued)
+			}()
+
+			select {
+			case <-enqueued:
+				if test.wantBlocked {
+					t.Errorf("Enqueued into a full buffer without waiting")
+				}
+			case <-time.After(50 * time.Millisecond):
+				if !test.wantBlocked {
+					t.Errorf("Waited for room in a full buffer")
+				}
+			}
+
+			close(s.gate)
+			<-enqueued
+			p.drain(time.Second)
+
+			if got := writtenLocations(&s.memorySink); got != test.want {
+				t.Errorf("Wrote batches %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPipelineDrainTimeout(t *testing.T) {
+	s := &gatedSink{started: make(chan bool, 10), gate: make(chan bool)}
+	defer close(s.gate)
+
+	previous := currentSink()
+	setSink(s)
+	defer setSink(previous)
+
+	p := startPipeline(PipelineConfig{BufferSize: 10, WhenFull: "block", Workers: 1})
+	p.enqueue(context.Background(), SensorConfig{}, "Lisbon", []*write.Point{locationPoint("Lisbon")})
+	<-s.started
+
+	done := make(chan bool)
+
+	go func() {
+		p.drain(10 * time.Millisecond)
+		close(done)
+	}()
+
+	// A write that never finishes doesn't hold shutdown up
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Draining waited past its timeout")
+	}
+}
+
+func TestPipelineWorkers(t *testing.T) {
+	for _, workers := range []int{1, 3} {
+		s := &gatedSink{started: make(chan bool, 10), gate: make(chan bool)}
+
+		previous := currentSink()
+		setSink(s)
+
+		p := startPipeline(PipelineConfig{BufferSize: 10, WhenFull: "block", Workers: workers})
+
+		for _, location := range []string{"Lisbon", "Porto", "Faro", "Braga"} {
+			p.enqueue(context.Background(), SensorConfig{}, location, []*write.Point{locationPoint(location)})
+		}
+
+		// Each worker takes a batch and gets stuck on it
+		for i := 0; i < workers; i++ {
+			<-s.started
+		}
+
+		select {
+		case <-s.started:
+			t.Errorf("%d workers: more writes than workers at once", workers)
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		close(s.gate)
+		p.drain(time.Second)
+		setSink(previous)
+
+		if got := len(strings.Fields(writtenLocations(&s.memorySink))); got != 4 {
+			t.Errorf("%d workers: wrote %d of 4 batches", workers, got)
+		}
+	}
+}
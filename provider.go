@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+// Provider is a source of weather readings
+type Provider interface {
+	Name() string
+	Fetch(ctx context.Context, location Location) (WeatherResponse, error)
+}
+
+// NearbyProvider is a provider that can also list readings from the
+// stations around a location
+type NearbyProvider interface {
+	FetchNearby(ctx context.Context, location Location) ([]WeatherResponse, error)
+}
+
+// Provider readings are fetched from, swapped on reload
+var provider Provider
+
+// fetchNearby fetches the stations around a location, if the provider can
+func fetchNearby(ctx context.Context, p Provider, location Location) ([]WeatherResponse, error) {
+	np, ok := p.(NearbyProvider)
+
+	if !ok {
+		return nil, fmt.Errorf("%s can't fetch nearby stations for location '%s'", p.Name(), location.Tag())
+	}
+
+	return np.FetchNearby(ctx, location)
+}
+
+// fallbackProvider turns to the secondary provider for locations the primary
+// has failed a number of times in a row. The primary is still tried first
+// every time, so it takes over again as soon as it recovers.
+type fallbackProvider struct {
+	primary Provider
+	secondary Provider
+	after int
+	failures map[string]int
+}
+
+func (p *fallbackProvider) Name() string {
+	return p.primary.Name()
+}
+
+func (p *fallbackProvider) Fetch(ctx context.Context, location Location) (WeatherResponse, error) {
+	res, err := p.primary.Fetch(ctx, location)
+
+	if err == nil {
+		p.failures[location.Tag()] = 0
+		res.Source = p.primary.Name()
+		return res, nil
+	}
+
+	p.failures[location.Tag()]++
+
+	if p.failures[location.Tag()] < p.after {
+		return res, err
+	}
+
+	log.Printf("Error fetching from %s for location '%s' (%d in a row), falling back to %s: %v",
+		p.primary.Name(), location.Tag(), p.failures[location.Tag()], p.secondary.Name(), err)
+
+	res, err = p.secondary.Fetch(ctx, location)
+	res.Source = p.secondary.Name()
+
+	return res, err
+}
+
+// FetchNearby goes to the primary, there's no falling back for the stations
+// around a location
+func (p *fallbackProvider) FetchNearby(ctx context.Context, location Location) ([]WeatherResponse, error) {
+	return fetchNearby(ctx, p.primary, location)
+}
+
+// Every provider by the name it is selected with in the config
+var providers = map[string]func(cfg WeatherAPIConfig) Provider{
+	"openweathermap": func(cfg WeatherAPIConfig) Provider { return owmProvider{cfg} },
+	"open-meteo": func(cfg WeatherAPIConfig) Provider { return openMeteoProvider{cfg} },
+}
+
+// newProvider creates the provider selected by the config
+func newProvider(cfg WeatherAPIConfig) Provider {
+	primary := providers[cfg.Provider](cfg)
+
+	if cfg.Fallback == "" {
+		return primary
+	}
+
+	return &fallbackProvider{
+		primary: primary,
+		secondary: providers[cfg.Fallback](cfg),
+		after: cfg.FallbackAfter,
+		failures: map[string]int{},
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+// namedProvider is a fake provider going by another name
+type namedProvider struct {
+	*fakeProvider
+	name string
+}
+
+func (p namedProvider) Name() string {
+	return p.name
+}
+
+func TestFallbackProvider(t *testing.T) {
+	down := fmt.Errorf("503 Service Unavailable")
+
+	tests := []struct {
+		name string
+		// Whether the primary is failing on each fetch
+		failing []bool
+		wantSources []string
+	}{
+		{"primary up", []bool{false, false}, []string{"primary", "primary"}},
+		{"failing less than the limit", []bool{true, true}, []string{"", ""}},
+		{"failing up to the limit", []bool{true, true, true, true}, []string{"", "", "secondary", "secondary"}},
+		{"primary recovering", []bool{true, true, true, false, true}, []string{"", "", "secondary", "primary", ""}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			primary := namedProvider{newFakeProvider(), "primary"}
+			secondary := namedProvider{newFakeProvider(), "secondary"}
+			p := &fallbackProvider{primary: primary, secondary: secondary, after: 3, failures: map[string]int{}}
+			location := Location{Name: "Lisbon"}
+
+			for i, failing := range test.failing {
+				if failing {
+					primary.setFailing("Lisbon", down)
+				} else {
+					primary.setFailing("Lisbon", nil)
+				}
+
+				res, err := p.Fetch(context.Background(), location)
+
+				if (err != nil) != (test.wantSources[i] == "") {
+					t.Errorf("Fetch %d returned %v", i + 1, err)
+				}
+
+				if err == nil && res.Source != test.wantSources[i] {
+					t.Errorf("Fetch %d came from '%s', want '%s'", i + 1, res.Source, test.wantSources[i])
+				}
+			}
+
+			if got := primary.fetchCount("Lisbon"); got != len(test.failing) {
+				t.Errorf("Tried the primary %d times, want every time", got)
+			}
+		})
+	}
+}
+
+func TestFetchNearbyUnsupported(t *testing.T) {
+	if _, err := fetchNearby(context.Background(), newFakeProvider(), Location{Name: "Lisbon"}); err == nil {
+		t.Errorf("Fetched nearby stations from a provider that can't")
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// jsonSchema describes a config type as JSON Schema, keyed like the config
+// file and with the defaults filled in
+func jsonSchema(t reflect.Type, prefix string) map[string]interface{} {
+	schema := map[string]interface{}{}
+
+	if d, ok := defaults[prefix]; ok {
+		schema["default"] = d
+	}
+
+	if t == reflect.TypeOf(time.Duration(0)) {
+		schema["type"] = "string"
+		schema["description"] = "Duration such as \"90s\" or \"5m\""
+		return schema
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return jsonSchema(t.Elem(), prefix)
+	case reflect.Struct:
+		properties := map[string]interface{}{}
+
+		for i := 0; i < t.NumField(); i++ {
+			key := t.Field(i).Tag.Get("koanf")
+
+			if key == "" || key == "-" {
+				continue
+			}
+
+			properties[key] = jsonSchema(t.Field(i).Type, strings.TrimPrefix(prefix + "." + key, "."))
+		}
+
+		schema["type"] = "object"
+		schema["properties"] = properties
+		schema["additionalProperties"] = false
+	case reflect.Slice:
+		schema["type"] = "array"
+		schema["items"] = jsonSchema(t.Elem(), prefix)
+	case reflect.Map:
+		schema["type"] = "object"
+		schema["additionalProperties"] = jsonSchema(t.Elem(), prefix)
+	case reflect.String:
+		schema["type"] = "string"
+	case reflect.Bool:
+		schema["type"] = "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		schema["type"] = "integer"
+	case reflect.Float32, reflect.Float64:
+		schema["type"] = "number"
+	}
+
+	return schema
+}
+
+// printSchema writes the JSON Schema of the config file
+func printSchema() error {
+	schema := jsonSchema(reflect.TypeOf(Config{}), "")
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["title"] = "weather-sensor config"
+
+	out, err := json.MarshalIndent(schema, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(string(out))
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJSONSchema(t *testing.T) {
+	schema := jsonSchema(reflect.TypeOf(Config{}), "")
+
+	tests := []struct {
+		// Dotted path through the schema, "[]" for array items and "{}" for
+		// map values
+		path string
+		wantType string
+		wantDefault interface{}
+	}{
+		{"sensor.min_interval", "integer", 60},
+		{"sensor.cycle_retry_delay", "string", "30s"},
+		{"derived.ema_alpha", "number", 0.3},
+		{"pipeline.when_full", "string", "block"},
+		{"influxdb.hostname", "string", nil},
+		{"weather_api.location", "array", nil},
+		{"weather_api.location.[]", "object", nil},
+		{"statsd.tags.{}", "string", nil},
+		{"kafka.brokers", "array", nil},
+		{"kafka.enabled", "boolean", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			node := schema
+
+			for _, key := range strings.Split(test.path, ".") {
+				var next interface{}
+
+				switch key {
+				case "[]":
+					next = node["items"]
+				case "{}":
+					next = node["additionalProperties"]
+				default:
+					properties, _ := node["properties"].(map[string]interface{})
+					next = properties[key]
+				}
+
+				var ok bool
+
+				if node, ok = next.(map[string]interface{}); !ok {
+					t.Fatalf("No '%s' in the schema", key)
+				}
+			}
+
+			if node["type"] != test.wantType {
+				t.Errorf("Type is %v, want %s", node["type"], test.wantType)
+			}
+
+			if d := node["default"]; !reflect.DeepEqual(d, test.wantDefault) {
+				t.Errorf("Default is %v, want %v", d, test.wantDefault)
+			}
+		})
+	}
+
+	if schema["additionalProperties"] != false {
+		t.Errorf("Schema allows unknown top-level keys")
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+// Sink is a destination readings are written to
+type Sink interface {
+	Write(ctx context.Context, points []*write.Point) error
+	// Health checks the destination can be reached
+	Health(ctx context.Context) error
+	Close()
+}
+
+// heldSink counts whoever is using a sink, so a sink swapped out on reload is
+// only closed once they're done with it
+type heldSink struct {
+	Sink
+	users sync.WaitGroup
+}
+
+// Sink all points are written to, swapped on reload
+var sink = &heldSink{}
+var sinkMutex sync.RWMutex
+
+func currentSink() Sink {
+	sinkMutex.RLock()
+	defer sinkMutex.RUnlock()
+
+	return sink.Sink
+}
+
+// acquireSink returns the current sink along with a function to call once
+// done with it. The sink isn't closed before then, even if it's replaced.
+func acquireSink() (Sink, func()) {
+	sinkMutex.RLock()
+	defer sinkMutex.RUnlock()
+
+	sink.users.Add(1)
+
+	return sink.Sink, sink.users.Done
+}
+
+func setSink(s Sink) {
+	sinkMutex.Lock()
+	defer sinkMutex.Unlock()
+
+	sink = &heldSink{Sink: s}
+}
+
+// replaceSink swaps in a new sink and closes the old one once every write and
+// health check still using it is done. The returned channel is closed then.
+func replaceSink(s Sink) <-chan bool {
+	sinkMutex.Lock()
+	old := sink
+	sink = &heldSink{Sink: s}
+	sinkMutex.Unlock()
+
+	closed := make(chan bool)
+
+	go func() {
+		defer close(closed)
+		old.users.Wait()
+
+		if old.Sink != nil {
+			old.Close()
+		}
+	}()
+
+	return closed
+}
+
+// sinkList flattens a sink into the individual sinks it writes to
+func sinkList(s Sink) []Sink {
+	if m, ok := s.(*multiSink); ok {
+		return m.sinks
+	}
+
+	return []Sink{s}
+}
+
+// newSink creates the sinks selected by the config, Kafka getting every
+// point on top of the InfluxDB sink
+func newSink(cfg *Config) (Sink, error) {
+	s, err := newInfluxDBSink(cfg.InfluxDB)
+
+	if err != nil || !cfg.Kafka.Enabled {
+		return s, err
+	}
+
+	return &multiSink{append(sinkList(s), newKafkaSink(cfg.Kafka))}, nil
+}
+
+// newInfluxDBSink creates the sink the influxdb section selects
+func newInfluxDBSink(cfg InfluxDBConfig) (Sink, error) {
+	if cfg.Stdout {
+		return newStdoutSink(), nil
+	}
+
+	if cfg.UDP.Enabled {
+		return newUDPSink(cfg.UDP.Address)
+	}
+
+	if len(cfg.Instances) == 0 {
+		return newInfluxSink(InfluxInstanceConfig{
+			Hostname: cfg.Hostname,
+			Token: cfg.Token,
+			Org: cfg.Org,
+			Bucket: cfg.Bucket,
+			UseGZip: cfg.UseGZip,
+			MaxInFlight: cfg.MaxInFlight,
+		}), nil
+	}
+
+	multi := &multiSink{}
+
+	for _, instance := range cfg.Instances {
+		instance.UseGZip = cfg.UseGZip
+
+		if instance.MaxInFlight == 0 {
+			instance.MaxInFlight = cfg.MaxInFlight
+		}
+
+		multi.sinks = append(multi.sinks, newInfluxSink(instance))
+	}
+
+	return multi, nil
+}
+
+// multiSink fans writes out to several sinks at once, so one of them being
+// slow or down doesn't hold back the others
+type multiSink struct {
+	sinks []Sink
+}
+
+func (m *multiSink) Write(ctx context.Context, points []*write.Point) error {
+	var wg sync.WaitGroup
+
+	errs := make([]error, len(m.sinks))
+	failed, _ := ctx.Value(failedSinksKey{}).([]error)
+
+	for i, s := range m.sinks {
+		// Already written to by the write being retried
+		if len(failed) == len(m.sinks) && failed[i] == nil {
+			continue
+		}
+
+		wg.Add(1)
+
+		go func(i int, s Sink) {
+			defer wg.Done()
+			errs[i] = s.Write(ctx, points)
+		}(i, s)
+	}
+
+	wg.Wait()
+
+	if countErrors(errs) > 0 {
+		return sinksFailed{errs}
+	}
+
+	return nil
+}
+
+// sinksFailed tells which of the sinks behind a multiSink failed a write,
+// the errors of those that didn't are nil
+type sinksFailed struct {
+	errs []error
+}
+
+func (e sinksFailed) Error() string {
+	var failed []string
+
+	for i, err := range e.errs {
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("sink %d: %v", i + 1, err))
+		}
+	}
+
+	return fmt.Sprintf("%d of %d sinks failed: %s", len(failed), len(e.errs), strings.Join(failed, "; "))
+}
+
+// partial tells whether the points still made it to some of the sinks
+func (e sinksFailed) partial() bool {
+	return countErrors(e.errs) < len(e.errs)
+}
+
+type failedSinksKey struct{}
+
+// onlyFailedSinks limits writes made with the returned context to the sinks
+// that failed with err, so retrying a write doesn't write the points twice
+// to those that took them
+func onlyFailedSinks(ctx context.Context, err error) context.Context {
+	var serr sinksFailed
+
+	if !errors.As(err, &serr) {
+		return ctx
+	}
+
+	return context.WithValue(ctx, failedSinksKey{}, serr.errs)
+}
+
+func (m *multiSink) Health(ctx context.Context) error {
+	var failed []string
+
+	for i, s := range m.sinks {
+		if err := s.Health(ctx); err != nil {
+			failed = append(failed, fmt.Sprintf("sink %d: %v", i + 1, err))
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d sinks unhealthy: %s", len(failed), len(m.sinks), strings.Join(failed, "; "))
+	}
+
+	return nil
+}
+
+func (m *multiSink) String() string {
+	return fmt.Sprintf("%d sinks", len(m.sinks))
+}
+
+func (m *multiSink) Close() {
+	for _, s := range m.sinks {
+		s.Close()
+	}
+}
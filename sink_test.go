@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+// gatedSink holds every write until it's let through
+type gatedSink struct {
+	memorySink
+	started chan bool
+	gate chan bool
+}
+
+func (s *gatedSink) Write(ctx context.Context, points []*write.Point) error {
+	s.started <- true
+	<-s.gate
+
+	return s.memorySink.Write(ctx, points)
+}
+
+func TestReplaceSink(t *testing.T) {
+	tests := []struct {
+		name string
+		// Whether a write is still going to the old sink when it's replaced
+		writing bool
+	}{
+		{"idle", false},
+		{"write in flight", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			old := &gatedSink{started: make(chan bool), gate: make(chan bool)}
+			replacement := &memorySink{}
+
+			previous := currentSink()
+			setSink(old)
+			defer setSink(previous)
+
+			p := startPipeline(PipelineConfig{BufferSize: 10, Workers: 1})
+
+			if test.writing {
+				p.enqueue(context.Background(), SensorConfig{}, "Lisbon", []*write.Point{locationPoint("Lisbon")})
+				<-old.started
+			}
+
+			closed := replaceSink(replacement)
+
+			if test.writing {
+				select {
+				case <-closed:
+					t.Fatalf("Closed the old sink with a write still going to it")
+				case <-time.After(50 * time.Millisecond):
+				}
+
+				close(old.gate)
+			}
+
+			select {
+			case <-closed:
+			case <-time.After(time.Second):
+				t.Fatalf("Didn't close the old sink once it was unused")
+			}
+
+			if !old.isClosed() {
+				t.Errorf("Old sink isn't closed")
+			}
+
+			if test.writing && len(old.written("Lisbon")) != 1 {
+				t.Errorf("Write in flight didn't make it to the old sink")
+			}
+
+			p.enqueue(context.Background(), SensorConfig{}, "Porto", []*write.Point{locationPoint("Porto")})
+			p.drain(time.Second)
+
+			if len(replacement.written("Porto")) != 1 {
+				t.Errorf("Write after the swap didn't go to the new sink")
+			}
+		})
+	}
+}
+
+func TestNewSinkKafka(t *testing.T) {
+	const kafka = "[kafka]\nenabled = true\nbrokers = [ \"kafka:9092\" ]\ntopic = \"weather\"\n"
+
+	tests := []struct {
+		name string
+		config string
+		want []string
+	}{
+		{"InfluxDB only", "", []string{"*main.influxSink"}},
+		{"InfluxDB and Kafka", kafka, []string{"*main.influxSink", "*main.kafkaSink"}},
+		{"instances and Kafka", kafka + "[[influxdb.instances]]\nhostname = \"http://influx-a:8086/\"\n[[influxdb.instances]]\nhostname = \"http://influx-b:8086/\"\n", []string{"*main.influxSink", "*main.influxSink", "*main.kafkaSink"}},
+		{"stdout and Kafka", kafka + "[influxdb]\nstdout = true\n", []string{"*main.stdoutSink", "*main.kafkaSink"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := newSink(testConfig(t, test.config))
+
+			if err != nil {
+				t.Fatalf("newSink returned %v", err)
+			}
+
+			defer s.Close()
+
+			var got []string
+
+			for _, s := range sinkList(s) {
+				got = append(got, fmt.Sprintf("%T", s))
+			}
+
+			if strings.Join(got, " ") != strings.Join(test.want, " ") {
+				t.Errorf("Got the sinks %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMultiSinkHealth(t *testing.T) {
+	down := fmt.Errorf("connection refused")
+
+	tests := []struct {
+		name string
+		// Health of each sink
+		fail []error
+		want string
+	}{
+		{"all up", []error{nil, nil}, ""},
+		{"one down", []error{nil, down}, "1 of 2 sinks unhealthy: sink 2: connection refused"},
+		{"all down", []error{down, down}, "2 of 2 sinks unhealthy: sink 1: connection refused; sink 2: connection refused"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := &multiSink{}
+
+			for _, fail := range test.fail {
+				s := &memorySink{}
+				s.setFailing(fail)
+				m.sinks = append(m.sinks, s)
+			}
+
+			err := m.Health(context.Background())
+
+			if test.want == "" && err != nil || test.want != "" && (err == nil || err.Error() != test.want) {
+				t.Errorf("Health returned %v, want '%s'", err, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var readingAge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "weather_sensor_reading_age_seconds",
+	Help: "How old the latest reading of each location was when it was fetched.",
+}, []string{"location"})
+
+// stale tells whether a reading's observation time is further behind than
+// the threshold, meaning the API stopped updating the location rather than
+// us not polling it
+func stale(cfg StalenessConfig, location string, weather WeatherResponse) bool {
+	if weather.Timestamp == 0 {
+		return false
+	}
+
+	age := clock.Now().Sub(time.Unix(int64(weather.Timestamp), 0))
+	readingAge.WithLabelValues(location).Set(age.Seconds())
+
+	if cfg.ThresholdSeconds <= 0 || age <= time.Duration(cfg.ThresholdSeconds) * time.Second {
+		return false
+	}
+
+	logThrottled("Reading for location '%s' was observed at %s, over %ds ago", location, time.Unix(int64(weather.Timestamp), 0).UTC().Format(time.RFC3339), cfg.ThresholdSeconds)
+
+	return true
+}
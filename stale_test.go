@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestStale(t *testing.T) {
+	tests := []struct {
+		name string
+		threshold int
+		age time.Duration
+		want bool
+	}{
+		{"fresh", 3600, 10 * time.Minute, false},
+		{"just within", 3600, time.Hour, false},
+		{"behind", 3600, 2 * time.Hour, true},
+		{"no threshold", 0, 48 * time.Hour, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := useSelfAdvancingClock(t)
+
+			weather := testReading("Lisbon")
+			weather.Timestamp = int(c.Now().Add(-test.age).Unix())
+
+			if got := stale(StalenessConfig{ThresholdSeconds: test.threshold}, "Lisbon stale", weather); got != test.want {
+				t.Errorf("Reading %v old is stale: %v, want %v", test.age, got, test.want)
+			}
+
+			if got := testutil.ToFloat64(readingAge.WithLabelValues("Lisbon stale")); got != test.age.Seconds() {
+				t.Errorf("Reading age is %vs, want %vs", got, test.age.Seconds())
+			}
+		})
+	}
+}
+
+func TestStaleWithoutTimestamp(t *testing.T) {
+	useSelfAdvancingClock(t)
+
+	// Nothing to tell the age from
+	if stale(StalenessConfig{ThresholdSeconds: 60}, "Lisbon", WeatherResponse{}) {
+		t.Errorf("Reading without an observation time is stale")
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// Counters for the whole session, summarised on shutdown
+var stats struct {
+	started time.Time
+	cycles int64
+	fetches int64
+	failedFetches int64
+	points int64
+}
+
+func logStats() {
+	log.Printf("Session summary: %d cycles, %d successful and %d failed fetches, %d points written, up %v",
+		atomic.LoadInt64(&stats.cycles),
+		atomic.LoadInt64(&stats.fetches),
+		atomic.LoadInt64(&stats.failedFetches),
+		atomic.LoadInt64(&stats.points),
+		clock.Now().Sub(stats.started).Round(time.Second))
+}
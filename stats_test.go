@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionStats(t *testing.T) {
+	tests := []struct {
+		name string
+		cycles int
+		unfetched []string
+		wantFetches int64
+		wantFailed int64
+	}{
+		{"every location up", 2, nil, 4, 0},
+		{"one location down", 3, []string{"Lisbon"}, 3, 3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := useSelfAdvancingClock(t)
+			logged := captureLog(t)
+			p, s := useFakes(t)
+
+			for _, location := range test.unfetched {
+				p.setFailing(location, fmt.Errorf("no route to host"))
+			}
+
+			stats.started = c.Now()
+			stats.cycles, stats.fetches, stats.failedFetches, stats.points = 0, 0, 0, 0
+
+			cfg := testConfig(t, "[weather_api]\nlocations = [ \"Lisbon\", \"Porto\" ]\n")
+
+			for i := 0; i < test.cycles; i++ {
+				runCycle(cfg, map[string]time.Time{})
+				c.Advance(5 * time.Minute)
+			}
+
+			points := int64(len(s.written("Lisbon")) + len(s.written("Porto")))
+
+			if stats.cycles != int64(test.cycles) || stats.fetches != test.wantFetches || stats.failedFetches != test.wantFailed || stats.points != points {
+				t.Errorf("Counted %d cycles, %d and %d failed fetches and %d points, want %d, %d and %d, %d",
+					stats.cycles, stats.fetches, stats.failedFetches, stats.points, test.cycles, test.wantFetches, test.wantFailed, points)
+			}
+
+			logStats()
+
+			want := fmt.Sprintf("Session summary: %d cycles, %d successful and %d failed fetches, %d points written, up %v",
+				test.cycles, test.wantFetches, test.wantFailed, points, time.Duration(test.cycles) * 5 * time.Minute)
+
+			if !strings.Contains(logged.String(), want) {
+				t.Errorf("Logged:\n%s\nwant '%s'", logged, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// statsdClient sends the sensor's own metrics to a StatsD server, with tags
+// in the DogStatsD format. Packets are fire and forget, like StatsD means
+// them to be.
+type statsdClient struct {
+	conn net.Conn
+	prefix string
+	tags string
+}
+
+// StatsD client, nil when not configured. Its methods do nothing then.
+var statsd *statsdClient
+
+func newStatsdClient(cfg StatsDConfig) (*statsdClient, error) {
+	conn, err := net.Dial("udp", cfg.Address)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var tags []string
+
+	for k, v := range cfg.Tags {
+		tags = append(tags, k + ":" + v)
+	}
+
+	sort.Strings(tags)
+
+	return &statsdClient{conn: conn, prefix: cfg.Prefix, tags: strings.Join(tags, ",")}, nil
+}
+
+func (c *statsdClient) send(name string, value string, kind string, tags []string) {
+	if c == nil {
+		return
+	}
+
+	if c.tags != "" {
+		tags = append([]string{c.tags}, tags...)
+	}
+
+	packet := fmt.Sprintf("%s%s:%s|%s", c.prefix, name, value, kind)
+
+	if len(tags) > 0 {
+		packet += "|#" + strings.Join(tags, ",")
+	}
+
+	if _, err := c.conn.Write([]byte(packet)); err != nil {
+		log.Printf("Error sending to StatsD: %v", err)
+	}
+}
+
+func (c *statsdClient) count(name string, n int64, tags ...string) {
+	c.send(name, fmt.Sprint(n), "c", tags)
+}
+
+func (c *statsdClient) timing(name string, d time.Duration, tags ...string) {
+	c.send(name, fmt.Sprint(d.Milliseconds()), "ms", tags)
+}
+
+// result tags a metric with whether the operation worked
+func result(err error) string {
+	if err != nil {
+		return "result:error"
+	}
+
+	return "result:ok"
+}
+
+// recordWrite sends the outcome of a write to StatsD
+func recordWrite(start time.Time, points int, err error) {
+	statsd.count("writes", 1, result(err))
+	statsd.timing("write_duration", clock.Now().Sub(start), result(err))
+
+	if err == nil {
+		statsd.count("points", int64(points))
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestStatsdClient(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Close()
+
+	tests := []struct {
+		name string
+		tags map[string]string
+		send func(c *statsdClient)
+		want string
+	}{
+		{"count", nil, func(c *statsdClient) { c.count("fetches", 1) }, "weather_sensor.fetches:1|c"},
+		{"count with tags", nil, func(c *statsdClient) { c.count("fetches", 2, "result:success") }, "weather_sensor.fetches:2|c|#result:success"},
+		{"timing", nil, func(c *statsdClient) { c.timing("fetch_duration", 1500 * time.Millisecond, "result:success") }, "weather_sensor.fetch_duration:1500|ms|#result:success"},
+		{"global tags first", map[string]string{"host": "attic", "env": "home"}, func(c *statsdClient) { c.count("panics", 1, "location:Lisbon") }, "weather_sensor.panics:1|c|#env:home,host:attic,location:Lisbon"},
+		{"only global tags", map[string]string{"env": "home"}, func(c *statsdClient) { c.count("points", 7) }, "weather_sensor.points:7|c|#env:home"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, err := newStatsdClient(StatsDConfig{Address: server.LocalAddr().String(), Prefix: "weather_sensor.", Tags: test.tags})
+
+			if err != nil {
+				t.Fatalf("Error creating the client: %v", err)
+			}
+
+			defer c.conn.Close()
+
+			test.send(c)
+
+			buf := make([]byte, 512)
+			server.SetReadDeadline(time.Now().Add(5 * time.Second))
+			n, _, err := server.ReadFrom(buf)
+
+			if err != nil {
+				t.Fatalf("No packet received: %v", err)
+			}
+
+			if got := string(buf[:n]); got != test.want {
+				t.Errorf("Sent '%s', want '%s'", got, test.want)
+			}
+		})
+	}
+}
+
+func TestStatsdClientNil(t *testing.T) {
+	var c *statsdClient
+
+	// Without StatsD configured metrics go nowhere, without failing
+	c.count("fetches", 1, "result:success")
+	c.timing("fetch_duration", time.Second)
+}
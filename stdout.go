@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+// stdoutSink prints line protocol, one point per line, for piping into
+// telegraf or the influx CLI. Logs go to stderr so they stay out of it.
+type stdoutSink struct {
+	mutex sync.Mutex
+	out io.Writer
+	// Error of the last write, e.g. once whatever reads stdout has exited
+	failed error
+}
+
+func newStdoutSink() *stdoutSink {
+	return &stdoutSink{out: os.Stdout}
+}
+
+func (s *stdoutSink) Write(ctx context.Context, points []*write.Point) error {
+	lines, err := lineProtocol(points, time.Nanosecond)
+
+	if err != nil {
+		return err
+	}
+
+	// Points written by the pipeline and a reload can't interleave
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, err = io.WriteString(s.out, lines)
+	s.failed = err
+
+	return err
+}
+
+// Health reports the last write failing, there's no way to tell whether
+// anything reads stdout before writing to it
+func (s *stdoutSink) Health(ctx context.Context) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.failed != nil {
+		return fmt.Errorf("Writing to stdout failed: %v", s.failed)
+	}
+
+	return nil
+}
+
+func (s *stdoutSink) String() string {
+	return "line protocol on stdout"
+}
+
+func (s *stdoutSink) Close() {
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+func TestStdoutSink(t *testing.T) {
+	var out bytes.Buffer
+	s := &stdoutSink{out: &out}
+	at := time.Unix(1654084800, 0)
+
+	if err := s.Write(context.Background(), []*write.Point{locationPoint("Lisbon").SetTime(at), locationPoint("Porto").SetTime(at)}); err != nil {
+		t.Fatalf("Write returned %v", err)
+	}
+
+	want := "weather,location=Lisbon temperature=18.5 1654084800000000000\nweather,location=Porto temperature=18.5 1654084800000000000\n"
+
+	if out.String() != want {
+		t.Errorf("Printed\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestStdoutSinkConcurrentWrites(t *testing.T) {
+	var out bytes.Buffer
+	s := &stdoutSink{out: &out}
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			s.Write(context.Background(), []*write.Point{locationPoint("Lisbon"), locationPoint("Lisbon")})
+		}()
+	}
+
+	wg.Wait()
+
+	// Whole lines only, never one write's points split by another's
+	lines := bytes.Split(bytes.TrimSuffix(out.Bytes(), []byte("\n")), []byte("\n"))
+
+	if len(lines) != 40 {
+		t.Fatalf("Printed %d lines, want 40", len(lines))
+	}
+
+	for i, line := range lines {
+		if string(line) != "weather,location=Lisbon temperature=18.5" {
+			t.Errorf("Line %d is '%s'", i + 1, line)
+		}
+	}
+}
+
+// brokenPipe fails every write while set
+type brokenPipe struct {
+	out bytes.Buffer
+	err error
+}
+
+func (b *brokenPipe) Write(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+
+	return b.out.Write(p)
+}
+
+func TestStdoutSinkHealth(t *testing.T) {
+	closed := fmt.Errorf("write /dev/stdout: broken pipe")
+
+	tests := []struct {
+		name string
+		// Error of each write, in order
+		writes []error
+		wantErr bool
+	}{
+		{"nothing written yet", nil, false},
+		{"writing", []error{nil}, false},
+		{"reader gone", []error{nil, closed}, true},
+		{"reader back", []error{closed, nil}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out := &brokenPipe{}
+			s := &stdoutSink{out: out}
+
+			for _, err := range test.writes {
+				out.err = err
+				s.Write(context.Background(), []*write.Point{locationPoint("Lisbon")})
+			}
+
+			if err := s.Health(context.Background()); (err != nil) != test.wantErr {
+				t.Errorf("Health returned %v, want an error: %v", err, test.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// Tags derived from the local time of a reading, by the name they are
+// enabled with in derived.tags
+var derivedTags = map[string]func(local time.Time, latitude float32) string{
+	"month": func(local time.Time, latitude float32) string {
+		return strings.ToLower(local.Month().String())
+	},
+	"season": season,
+	"daypart": daypart,
+}
+
+// localTime is when a reading was observed, in the location's own time. A
+// configured time zone is preferred over the API's offset, which is only
+// as current as OpenWeatherMap's idea of daylight saving time.
+func localTime(weather WeatherResponse) time.Time {
+	observed := time.Unix(int64(weather.Timestamp), 0)
+
+	if weather.Zone != nil {
+		return observed.In(weather.Zone)
+	}
+
+	return observed.In(time.FixedZone("", weather.Timezone))
+}
+
+// season is the meteorological season, which starts on the first of the
+// month rather than at the solstice or equinox. Southern latitudes get the
+// opposite season.
+func season(local time.Time, latitude float32) string {
+	seasons := []string{"winter", "spring", "summer", "autumn"}
+	i := int(local.Month()) % 12 / 3
+
+	if latitude < 0 {
+		i = (i + 2) % 4
+	}
+
+	return seasons[i]
+}
+
+func daypart(local time.Time, latitude float32) string {
+	switch h := local.Hour(); {
+	case h < 6:
+		return "night"
+	case h < 12:
+		return "morning"
+	case h < 18:
+		return "afternoon"
+	default:
+		return "evening"
+	}
+}
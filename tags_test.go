@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDerivedTags(t *testing.T) {
+	tests := []struct {
+		at time.Time
+		latitude float32
+		month, season, daypart string
+	}{
+		{time.Date(2022, 1, 15, 3, 0, 0, 0, time.UTC), 38.7, "january", "winter", "night"},
+		{time.Date(2022, 3, 1, 6, 0, 0, 0, time.UTC), 38.7, "march", "spring", "morning"},
+		{time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC), 38.7, "june", "summer", "afternoon"},
+		{time.Date(2022, 11, 30, 18, 0, 0, 0, time.UTC), 38.7, "november", "autumn", "evening"},
+		{time.Date(2022, 12, 1, 23, 59, 0, 0, time.UTC), 38.7, "december", "winter", "evening"},
+		// Seasons are the other way around south of the equator
+		{time.Date(2022, 1, 15, 12, 0, 0, 0, time.UTC), -33.9, "january", "summer", "afternoon"},
+		{time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC), -33.9, "june", "winter", "afternoon"},
+		{time.Date(2022, 4, 1, 12, 0, 0, 0, time.UTC), -33.9, "april", "autumn", "afternoon"},
+	}
+
+	for _, test := range tests {
+		got := [3]string{derivedTags["month"](test.at, test.latitude), derivedTags["season"](test.at, test.latitude), derivedTags["daypart"](test.at, test.latitude)}
+
+		if want := [3]string{test.month, test.season, test.daypart}; got != want {
+			t.Errorf("%v at latitude %v is tagged %v, want %v", test.at, test.latitude, got, want)
+		}
+	}
+}
+
+func TestLocalTime(t *testing.T) {
+	lisbon, err := time.LoadLocation("Europe/Lisbon")
+
+	if err != nil {
+		t.Skipf("No time zone database: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		zone *time.Location
+		offset int
+		want string
+	}{
+		{"API offset", nil, 3600, "2022-06-01T13:00:00+01:00"},
+		{"API offset gone stale", nil, 0, "2022-06-01T12:00:00Z"},
+		// The configured zone knows about daylight saving time itself
+		{"configured zone", lisbon, 0, "2022-06-01T13:00:00+01:00"},
+	}
+
+	for _, test := range tests {
+		weather := WeatherResponse{Timestamp: 1654084800, Timezone: test.offset, Zone: test.zone}
+
+		if got := localTime(weather).Format(time.RFC3339); got != test.want {
+			t.Errorf("%s: local time is %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestLoadLocationsTimeZone(t *testing.T) {
+	zone := "Europe/Lisbon"
+	locations, err := loadLocations(WeatherAPIConfig{Location: []LocationConfig{{Name: "Lisbon", TimeZone: zone}}})
+
+	if err != nil {
+		t.Skipf("No time zone database: %v", err)
+	}
+
+	if locations[0].TimeZone == nil || locations[0].TimeZone.String() != zone {
+		t.Errorf("Location has time zone %v, want %s", locations[0].TimeZone, zone)
+	}
+
+	if _, err := loadLocations(WeatherAPIConfig{Location: []LocationConfig{{Name: "Lisbon", TimeZone: "Europe/Atlantis"}}}); err == nil {
+		t.Errorf("Loaded a location with an unknown time zone")
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// ANSI colors for the temperature column, from cold to hot in Celsius
+const (
+	colorBlue = "\033[34m"
+	colorCyan = "\033[36m"
+	colorGreen = "\033[32m"
+	colorYellow = "\033[33m"
+	colorRed = "\033[31m"
+	colorReset = "\033[0m"
+)
+
+// temperatureColor picks a color for a temperature in Celsius
+func temperatureColor(celsius float64) string {
+	switch {
+	case celsius < 0:
+		return colorBlue
+	case celsius < 10:
+		return colorCyan
+	case celsius < 20:
+		return colorGreen
+	case celsius < 30:
+		return colorYellow
+	default:
+		return colorRed
+	}
+}
+
+// isTerminal tells whether the output is a terminal rather than a file or a
+// pipe, which get neither colors nor screen clearing
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+
+	return err == nil && info.Mode() & os.ModeCharDevice != 0 && os.Getenv("NO_COLOR") == ""
+}
+
+type tailRow struct {
+	location string
+	weather WeatherResponse
+	err error
+}
+
+// fetchAll fetches the current weather of every location
+func fetchAll(cfg *Config) []tailRow {
+	var rows []tailRow
+
+	for _, location := range cfg.Locations {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.WeatherAPI.timeout("current"))
+
+		if location.Nearby > 0 {
+			readings, err := fetchNearby(ctx, provider, location)
+
+			if err != nil {
+				rows = append(rows, tailRow{location: location.Tag(), err: err})
+			}
+
+			for _, weather := range readings {
+				weather.Zone = location.TimeZone
+				rows = append(rows, tailRow{location: location.Tag() + "/" + weather.Name, weather: weather})
+			}
+		} else {
+			weather, err := provider.Fetch(ctx, location)
+			weather.Zone = location.TimeZone
+			rows = append(rows, tailRow{location: location.Tag(), weather: weather, err: err})
+		}
+
+		cancel()
+	}
+
+	return rows
+}
+
+// printSnapshot writes a table of the current conditions of every location
+func printSnapshot(w io.Writer, units string, rows []tailRow, color bool) error {
+	units = unitsOrDefault(units)
+
+	temperature := map[string]string{"standard": "K", "metric": "°C", "imperial": "°F"}[units]
+	speed := map[string]string{"standard": "m/s", "metric": "m/s", "imperial": "mph"}[units]
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "LOCATION\tTEMP\tFEELS LIKE\tHUMIDITY\tPRESSURE\tWIND\tCONDITIONS\tOBSERVED")
+
+	for _, row := range rows {
+		if row.err != nil {
+			missing := "-"
+
+			// Colored like the other rows so the columns stay aligned
+			if color {
+				missing = colorRed + missing + colorReset
+			}
+
+			fmt.Fprintf(tw, "%s\t%s\t\t\t\t\t%v\t\n", row.location, missing, row.err)
+			continue
+		}
+
+		weather := row.weather
+		temp := fmt.Sprintf("%.1f%s", weather.Main.Temp, temperature)
+
+		if color {
+			temp = temperatureColor(toCelsius(float64(weather.Main.Temp), units)) + temp + colorReset
+		}
+
+		var conditions []string
+
+		for _, w := range weather.Weather {
+			conditions = append(conditions, w.Description)
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%.1f%s\t%.0f%%\t%.0f hPa\t%.1f %s %03.0f°\t%s\t%s\n",
+			row.location,
+			temp,
+			weather.Main.FeelsLike, temperature,
+			weather.Main.Humidity,
+			weather.Main.Pressure,
+			weather.Wind.Speed, speed, weather.Wind.Degree,
+			strings.Join(conditions, ", "),
+			localTime(weather).Format("15:04"))
+	}
+
+	return tw.Flush()
+}
+
+// tail prints the current conditions of every location each interval, or
+// once with -once, without writing them anywhere
+func tail(cfg *Config, once bool) error {
+	var err error
+
+	if httpClient, err = newHTTPClient(cfg.WeatherAPI); err != nil {
+		return err
+	}
+
+	provider = newProvider(cfg.WeatherAPI)
+
+	if err := geocodeZips(context.Background(), cfg, geocodeRetries); err != nil {
+		return err
+	}
+
+	terminal := isTerminal(os.Stdout)
+
+	for {
+		rows := fetchAll(cfg)
+
+		// Redraw in place rather than scrolling
+		if terminal && !once {
+			fmt.Print("\033[H\033[2J")
+		}
+
+		if err := printSnapshot(os.Stdout, cfg.WeatherAPI.Units, rows, terminal); err != nil {
+			return err
+		}
+
+		if once {
+			return nil
+		}
+
+		fmt.Printf("\nUpdated %s, every %ds\n", clock.Now().Format("15:04:05"), cfg.Sensor.Interval)
+
+		clock.Sleep(time.Duration(cfg.Sensor.Interval) * time.Second)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPrintSnapshot(t *testing.T) {
+	lisbon := testReading("Lisbon")
+	lisbon.Timestamp = 1654084800
+
+	tests := []struct {
+		name string
+		units string
+		temp float32
+		color bool
+		// Substrings the Lisbon row should have
+		want []string
+	}{
+		{"metric", "metric", 18.5, false, []string{"18.5°C", "3.5 m/s 270°", "70%", "1015 hPa", "light rain", "12:00"}},
+		{"imperial", "imperial", 65.3, false, []string{"65.3°F", "3.5 mph"}},
+		{"standard", "standard", 291.65, false, []string{"291.6K"}},
+		{"default units", "", 291.65, false, []string{"291.6K"}},
+		{"mild in color", "metric", 18.5, true, []string{colorGreen + "18.5°C" + colorReset}},
+		{"freezing in color", "imperial", 14, true, []string{colorBlue + "14.0°F" + colorReset}},
+		{"hot in color", "standard", 308.15, true, []string{colorRed + "308.1K" + colorReset}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			weather := lisbon
+			weather.Main.Temp = test.temp
+
+			var out strings.Builder
+
+			rows := []tailRow{{location: "Lisbon", weather: weather}, {location: "Porto", err: errors.New("503 Service Unavailable")}}
+
+			if err := printSnapshot(&out, test.units, rows, test.color); err != nil {
+				t.Fatalf("printSnapshot returned %v", err)
+			}
+
+			lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+
+			if len(lines) != 3 || !strings.HasPrefix(lines[0], "LOCATION") {
+				t.Fatalf("Got:\n%s\nwant a header and two rows", out.String())
+			}
+
+			for _, want := range test.want {
+				if !strings.Contains(lines[1], want) {
+					t.Errorf("Lisbon row '%s' is missing '%s'", lines[1], want)
+				}
+			}
+
+			if !strings.HasPrefix(lines[2], "Porto") || !strings.Contains(lines[2], "503 Service Unavailable") {
+				t.Errorf("Porto row is '%s', want its error", lines[2])
+			}
+
+			if strings.Contains(out.String(), "\033") != test.color {
+				t.Errorf("Colored: %v, want %v", !test.color, test.color)
+			}
+		})
+	}
+}
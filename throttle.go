@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// A message seen within the current window, and how often it came again
+type repeated struct {
+	since time.Time
+	count int
+}
+
+// Repeats of identical messages are held back during the throttle window,
+// then summarised, so an outage doesn't bury everything else in the logs.
+// A zero window logs every message.
+var throttle = struct {
+	sync.Mutex
+	window time.Duration
+	seen map[string]*repeated
+}{seen: map[string]*repeated{}}
+
+// logThrottled logs a message unless it was already logged within the
+// window, in which case it is only counted
+func logThrottled(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+
+	throttle.Lock()
+	defer throttle.Unlock()
+
+	if throttle.window <= 0 {
+		log.Print(msg)
+		return
+	}
+
+	if r, ok := throttle.seen[msg]; ok {
+		r.count++
+		return
+	}
+
+	throttle.seen[msg] = &repeated{since: clock.Now()}
+	log.Print(msg)
+}
+
+// flushThrottled summarises the messages whose window is over
+func flushThrottled() {
+	throttle.Lock()
+	defer throttle.Unlock()
+
+	for msg, r := range throttle.seen {
+		elapsed := clock.Now().Sub(r.since)
+
+		if elapsed < throttle.window {
+			continue
+		}
+
+		if r.count > 0 {
+			log.Printf("Repeated %d more times in the last %v: %s", r.count, elapsed.Round(time.Second), msg)
+		}
+
+		delete(throttle.seen, msg)
+	}
+}
+
+func runThrottle(window time.Duration) {
+	throttle.Lock()
+	throttle.window = window
+	throttle.Unlock()
+
+	ticker := clock.NewTicker(window / 4)
+
+	for range ticker.C() {
+		flushThrottled()
+	}
+}
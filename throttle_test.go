@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// captureLog collects what's logged for the length of the test
+func captureLog(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	return &buf
+}
+
+func TestLogThrottled(t *testing.T) {
+	c := useSelfAdvancingClock(t)
+	logged := captureLog(t)
+
+	throttle.Lock()
+	throttle.window = time.Minute
+	throttle.seen = map[string]*repeated{}
+	throttle.Unlock()
+
+	defer func() {
+		throttle.Lock()
+		throttle.window = 0
+		throttle.Unlock()
+	}()
+
+	for i := 0; i < 5; i++ {
+		logThrottled("Error fetching the weather: %s", "timeout")
+	}
+
+	logThrottled("Error writing the weather")
+
+	if n := strings.Count(logged.String(), "Error fetching the weather: timeout"); n != 1 {
+		t.Errorf("Logged a repeated message %d times within the window, want once", n)
+	}
+
+	if !strings.Contains(logged.String(), "Error writing the weather") {
+		t.Errorf("Held back a different message")
+	}
+
+	// Not summarised before the window is over
+	c.Advance(30 * time.Second)
+	flushThrottled()
+
+	if strings.Contains(logged.String(), "Repeated") {
+		t.Errorf("Summarised before the window was over:\n%s", logged)
+	}
+
+	c.Advance(30 * time.Second)
+	flushThrottled()
+
+	if !strings.Contains(logged.String(), "Repeated 4 more times in the last 1m0s: Error fetching the weather: timeout") {
+		t.Errorf("Repeats not summarised once the window was over:\n%s", logged)
+	}
+
+	// A message logged only once has nothing to summarise
+	if strings.Contains(logged.String(), "more times in the last 1m0s: Error writing") {
+		t.Errorf("Summarised a message that wasn't repeated")
+	}
+
+	// Logged again once the window is over, after the first time and the
+	// summary
+	logThrottled("Error fetching the weather: %s", "timeout")
+
+	if n := strings.Count(logged.String(), "Error fetching the weather: timeout\n"); n != 3 {
+		t.Errorf("Didn't log the message again after the window:\n%s", logged)
+	}
+}
+
+func TestLogThrottledWithoutWindow(t *testing.T) {
+	logged := captureLog(t)
+
+	for i := 0; i < 3; i++ {
+		logThrottled("Error writing the weather")
+	}
+
+	if n := strings.Count(logged.String(), "Error writing the weather"); n != 3 {
+		t.Errorf("Logged %d of 3 messages without a window", n)
+	}
+}
+
+func TestFetchErrorsThrottledPerLocation(t *testing.T) {
+	c := useSelfAdvancingClock(t)
+	logged := captureLog(t)
+	p, _ := useFakes(t)
+
+	throttle.Lock()
+	throttle.window = time.Minute
+	throttle.seen = map[string]*repeated{}
+	throttle.Unlock()
+
+	defer func() {
+		throttle.Lock()
+		throttle.window = 0
+		throttle.Unlock()
+	}()
+
+	cfg := testConfig(t, "[weather_api]\nlocations = [ \"Lisbon\", \"Porto\" ]\n")
+
+	tests := []struct {
+		location string
+		fetches int
+	}{
+		{"Lisbon", 3},
+		{"Porto", 2},
+	}
+
+	for _, test := range tests {
+		p.setFailing(test.location, fmt.Errorf("no route to host"))
+
+		for i := 0; i < test.fetches; i++ {
+			processLocation(context.Background(), cfg, Location{Name: test.location})
+		}
+	}
+
+	c.Advance(time.Minute)
+	flushThrottled()
+
+	for _, test := range tests {
+		msg := fmt.Sprintf("Error fetching the weather for location '%s' (error): no route to host", test.location)
+
+		// Summaries end with the message too
+		if n := strings.Count(logged.String(), msg + "\n") - strings.Count(logged.String(), ": " + msg + "\n"); n != 1 {
+			t.Errorf("Logged the error for '%s' %d times, want once:\n%s", test.location, n, logged)
+		}
+
+		if summary := fmt.Sprintf("Repeated %d more times in the last 1m0s: %s", test.fetches - 1, msg); !strings.Contains(logged.String(), summary) {
+			t.Errorf("No summary '%s':\n%s", summary, logged)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// useSpanRecorder keeps the spans the sensor makes in memory for the length
+// of the test
+func useSpanRecorder(t *testing.T) *tracetest.SpanRecorder {
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+
+	previous := tracer
+	tracer = tp.Tracer("weather-sensor")
+
+	t.Cleanup(func() {
+		tracer = previous
+		tp.Shutdown(context.Background())
+	})
+
+	return sr
+}
+
+// spanAttribute returns the value of an attribute of a span
+func spanAttribute(span sdktrace.ReadOnlySpan, key string) (attribute.Value, bool) {
+	for _, kv := range span.Attributes() {
+		if string(kv.Key) == key {
+			return kv.Value, true
+		}
+	}
+
+	return attribute.Value{}, false
+}
+
+func TestCycleSpans(t *testing.T) {
+	sr := useSpanRecorder(t)
+
+	useAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("q") != "Lisbon" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		fmt.Fprint(w, `{"main": {"temp": 18.5, "humidity": 70, "pressure": 1015}, "name": "Lisbon", "dt": 1654084800, "cod": 200}`)
+	}))
+
+	cfg := testConfig(t, "[weather_api]\nlocations = [ \"Lisbon\", \"Porto\" ]\n")
+	_, s := useFakes(t)
+	provider = newProvider(cfg.WeatherAPI)
+
+	runCycle(cfg, map[string]time.Time{})
+
+	spans := map[string][]sdktrace.ReadOnlySpan{}
+
+	for _, span := range sr.Ended() {
+		spans[span.Name()] = append(spans[span.Name()], span)
+	}
+
+	if len(spans["cycle"]) != 1 || len(spans["fetch"]) != 2 || len(spans["write"]) != 1 {
+		t.Fatalf("Got %d cycle, %d fetch and %d write spans, want 1, 2 and 1", len(spans["cycle"]), len(spans["fetch"]), len(spans["write"]))
+	}
+
+	cycle := spans["cycle"][0].SpanContext()
+
+	for _, span := range append(spans["fetch"], spans["write"]...) {
+		if span.Parent().SpanID() != cycle.SpanID() {
+			t.Errorf("%s span isn't part of the cycle", span.Name())
+		}
+	}
+
+	statuses := map[string]int64{}
+
+	for _, span := range spans["fetch"] {
+		location, _ := spanAttribute(span, "location")
+		status, _ := spanAttribute(span, "http.status_code")
+		statuses[location.AsString()] = status.AsInt64()
+	}
+
+	if statuses["Lisbon"] != http.StatusOK || statuses["Porto"] != http.StatusServiceUnavailable {
+		t.Errorf("Fetch spans have statuses %v, want 200 for Lisbon and 503 for Porto", statuses)
+	}
+
+	if location, _ := spanAttribute(spans["write"][0], "location"); location.AsString() != "Lisbon" {
+		t.Errorf("Write span is for location '%s', want Lisbon", location.AsString())
+	}
+
+	if points, _ := spanAttribute(spans["write"][0], "points"); points.AsInt64() != int64(len(s.written("Lisbon"))) {
+		t.Errorf("Write span has %d points, but %d were written", points.AsInt64(), len(s.written("Lisbon")))
+	}
+}
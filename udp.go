@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+// udpSink writes line protocol to an InfluxDB 1.x UDP listener, one point
+// per datagram. UDP has no delivery guarantee: points are silently lost if
+// the listener is down or the network drops them.
+type udpSink struct {
+	addr string
+	conn net.Conn
+}
+
+func newUDPSink(addr string) (*udpSink, error) {
+	conn, err := net.Dial("udp", addr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &udpSink{addr: addr, conn: conn}, nil
+}
+
+func (s *udpSink) Write(ctx context.Context, points []*write.Point) error {
+	for _, p := range points {
+		line, err := lineProtocol([]*write.Point{p}, time.Nanosecond)
+
+		if err != nil {
+			return err
+		}
+
+		if _, err := s.conn.Write([]byte(line)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Health can only check the listener's address still resolves, whether
+// anything is listening can't be known over UDP
+func (s *udpSink) Health(ctx context.Context) error {
+	host, _, err := net.SplitHostPort(s.addr)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = net.DefaultResolver.LookupHost(ctx, host)
+
+	return err
+}
+
+func (s *udpSink) String() string {
+	return "InfluxDB UDP listener at " + s.addr
+}
+
+func (s *udpSink) Close() {
+	s.conn.Close()
+}
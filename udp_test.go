@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+func TestUDPSink(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer listener.Close()
+
+	s, err := newUDPSink(listener.LocalAddr().String())
+
+	if err != nil {
+		t.Fatalf("Error creating the sink: %v", err)
+	}
+
+	defer s.Close()
+
+	at := time.Unix(1654084800, 0)
+	points := []*write.Point{locationPoint("Lisbon").SetTime(at), locationPoint("Porto").SetTime(at)}
+
+	if err := s.Write(context.Background(), points); err != nil {
+		t.Fatalf("Write returned %v", err)
+	}
+
+	// One point per datagram
+	for _, want := range []string{
+		"weather,location=Lisbon temperature=18.5 1654084800000000000\n",
+		"weather,location=Porto temperature=18.5 1654084800000000000\n",
+	} {
+		buf := make([]byte, 512)
+		listener.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := listener.ReadFrom(buf)
+
+		if err != nil {
+			t.Fatalf("No datagram received: %v", err)
+		}
+
+		if got := string(buf[:n]); got != want {
+			t.Errorf("Received '%s', want '%s'", got, want)
+		}
+	}
+
+	if err := s.Health(context.Background()); err != nil {
+		t.Errorf("Health returned %v", err)
+	}
+}
+
+func TestUDPSinkHealth(t *testing.T) {
+	tests := []struct {
+		name string
+		addr string
+		wantErr bool
+	}{
+		{"address", "127.0.0.1:8089", false},
+		{"host not resolving", "influx.invalid:8089", true},
+		{"no port", "127.0.0.1", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5 * time.Second)
+			defer cancel()
+
+			// Without dialing, newUDPSink won't take an address that doesn't resolve
+			s := &udpSink{addr: test.addr}
+
+			if err := s.Health(ctx); (err != nil) != test.wantErr {
+				t.Errorf("Health returned %v, want an error: %v", err, test.wantErr)
+			}
+		})
+	}
+}
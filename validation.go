@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// toUnits converts a temperature in Celsius into the configured units
+func toUnits(celsius float64, units string) float64 {
+	switch units {
+	case "imperial":
+		return celsius * 9 / 5 + 32
+	case "metric":
+		return celsius
+	default:
+		return celsius + 273.15
+	}
+}
+
+// implausible is returned for a reading with a value outside its plausible
+// range. The reason is the value that was off, e.g. "temperature".
+type implausible struct {
+	reason string
+	value float64
+	min float64
+	max float64
+}
+
+func (e implausible) Error() string {
+	return fmt.Sprintf("%s %.2f outside of plausible range [%.2f, %.2f]", strings.ToUpper(e.reason[:1]) + e.reason[1:], e.value, e.min, e.max)
+}
+
+// rejectReason labels a reading rejected by validateWeather with the value
+// that was off
+func rejectReason(err error) string {
+	var ierr implausible
+
+	if errors.As(err, &ierr) {
+		return ierr.reason
+	}
+
+	return "other"
+}
+
+// validateWeather rejects readings outside the configured plausible ranges.
+// Temperature bounds are configured in Celsius regardless of the units the
+// API is queried with.
+func validateWeather(cfg ValidationConfig, units string, weather WeatherResponse) error {
+	tmin := toUnits(cfg.TemperatureMin, units)
+	tmax := toUnits(cfg.TemperatureMax, units)
+
+	if t := float64(weather.Main.Temp); t < tmin || t > tmax {
+		return implausible{"temperature", t, tmin, tmax}
+	}
+
+	hmin := cfg.HumidityMin
+	hmax := cfg.HumidityMax
+
+	if h := float64(weather.Main.Humidity); h < hmin || h > hmax {
+		return implausible{"humidity", h, hmin, hmax}
+	}
+
+	return nil
+}
+
+// Temperatures above this can't be Celsius or Fahrenheit on Earth, but are
+// everyday values in Kelvin
+const kelvinThreshold = 200
+
+// checkKelvin looks for temperatures that came back in Kelvin although the
+// API was asked for Celsius or Fahrenheit. Depending on the mode these are
+// only warned about or converted into the requested units.
+func checkKelvin(mode string, units string, location string, weather *WeatherResponse) {
+	if mode == "off" || (units != "metric" && units != "imperial") || weather.Main.Temp <= kelvinThreshold {
+		return
+	}
+
+	if mode == "warn" {
+		log.Printf("WARNING: temperature %.2f for location '%s' looks like Kelvin, but %s units were requested", weather.Main.Temp, location, units)
+		return
+	}
+
+	log.Printf("Temperature %.2f for location '%s' looks like Kelvin, converting to %s units", weather.Main.Temp, location, units)
+
+	for _, t := range []*float32{&weather.Main.Temp, &weather.Main.FeelsLike, &weather.Main.TempMin, &weather.Main.TempMax} {
+		*t = float32(toUnits(float64(*t) - 273.15, units))
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"math"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestValidateWeather(t *testing.T) {
+	cfg := ValidationConfig{TemperatureMin: -60, TemperatureMax: 60, HumidityMin: 0, HumidityMax: 100}
+
+	tests := []struct {
+		units string
+		temp float32
+		humidity float32
+		// Reason for rejecting the reading, empty for none
+		want string
+	}{
+		{"metric", 18.5, 50, ""},
+		{"metric", 61, 50, "temperature"},
+		{"metric", -61, 50, "temperature"},
+		{"imperial", 100, 50, ""},
+		{"imperial", 150, 50, "temperature"},
+		{"standard", 291.65, 50, ""},
+		{"standard", 18.5, 50, "temperature"},
+		{"metric", 18.5, 101, "humidity"},
+		{"metric", 61, 101, "temperature"},
+	}
+
+	for _, test := range tests {
+		var weather WeatherResponse
+		weather.Main.Temp = test.temp
+		weather.Main.Humidity = test.humidity
+
+		err := validateWeather(cfg, test.units, weather)
+
+		if test.want == "" && err != nil || test.want != "" && (err == nil || rejectReason(err) != test.want) {
+			t.Errorf("%v %s with %v%% humidity: validateWeather returned %v, want reason '%s'", test.temp, test.units, test.humidity, err, test.want)
+		}
+	}
+}
+
+func TestProcessLocationCountsRejected(t *testing.T) {
+	cfg := testConfig(t, "[validation]\nenabled = true\ntemperature_max = 10.0\n")
+	_, s := useFakes(t)
+
+	before := testutil.ToFloat64(rejectedReadings.WithLabelValues("Lisbon", "temperature"))
+	beforeTotal := atomic.LoadInt64(&rejected)
+
+	if err := processLocation(context.Background(), cfg, cfg.Locations[0]); err != nil {
+		t.Fatalf("Processing the location returned %v", err)
+	}
+
+	if got := testutil.ToFloat64(rejectedReadings.WithLabelValues("Lisbon", "temperature")) - before; got != 1 {
+		t.Errorf("Counted %v rejected readings, want 1", got)
+	}
+
+	if got := atomic.LoadInt64(&rejected) - beforeTotal; got != 1 {
+		t.Errorf("Counted %d rejected readings in total, want 1", got)
+	}
+
+	if got := len(s.written("Lisbon")); got != 0 {
+		t.Errorf("Wrote %d points for a rejected reading", got)
+	}
+}
+
+func TestCheckKelvin(t *testing.T) {
+	tests := []struct {
+		name string
+		mode string
+		units string
+		temp float32
+		want float32
+		wantWarning bool
+	}{
+		{"celsius", "correct", "metric", 18.5, 18.5, false},
+		{"kelvin corrected to celsius", "correct", "metric", 291.65, 18.5, true},
+		{"kelvin corrected to fahrenheit", "correct", "imperial", 291.65, 65.3, true},
+		{"kelvin only warned about", "warn", "metric", 291.65, 291.65, true},
+		{"check off", "off", "metric", 291.65, 291.65, false},
+		// Kelvin is what was asked for
+		{"standard units", "correct", "standard", 291.65, 291.65, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logged := captureLog(t)
+
+			var weather WeatherResponse
+			weather.Main.Temp = test.temp
+			weather.Main.FeelsLike = test.temp
+			weather.Main.TempMin = test.temp
+			weather.Main.TempMax = test.temp
+
+			checkKelvin(test.mode, test.units, "Lisbon", &weather)
+
+			for name, got := range map[string]float32{"temp": weather.Main.Temp, "feels_like": weather.Main.FeelsLike, "temp_min": weather.Main.TempMin, "temp_max": weather.Main.TempMax} {
+				if math.Abs(float64(got - test.want)) > 0.01 {
+					t.Errorf("%s is %v, want %v", name, got, test.want)
+				}
+			}
+
+			if warned := strings.Contains(logged.String(), "looks like Kelvin"); warned != test.wantWarning {
+				t.Errorf("Logged '%s', want a warning: %v", logged.String(), test.wantWarning)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+// walSink logs every point written through it, with the time and the result
+// of the write, to help track down data that never showed up. Once the log
+// grows past the size limit it's moved aside to a .1 file and started over.
+type walSink struct {
+	Sink
+	path string
+	maxBytes int64
+
+	mutex sync.Mutex
+	file *os.File
+	size int64
+}
+
+// withWAL wraps a sink in a write log if one is configured
+func withWAL(s Sink, cfg DebugConfig) (Sink, error) {
+	if cfg.WALPath == "" {
+		return s, nil
+	}
+
+	w := &walSink{Sink: s, path: cfg.WALPath, maxBytes: cfg.WALMaxBytes}
+
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+func (w *walSink) open() error {
+	f, err := os.OpenFile(w.path, os.O_WRONLY | os.O_APPEND | os.O_CREATE, 0644)
+
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	w.file, w.size = f, info.Size()
+
+	return nil
+}
+
+func (w *walSink) rotate() error {
+	w.file.Close()
+
+	if err := os.Rename(w.path, w.path + ".1"); err != nil {
+		return err
+	}
+
+	return w.open()
+}
+
+func (w *walSink) Write(ctx context.Context, points []*write.Point) error {
+	err := w.Sink.Write(ctx, points)
+
+	result := "ok"
+
+	if err != nil {
+		result = fmt.Sprintf("error %q", err.Error())
+	}
+
+	var sb strings.Builder
+
+	for _, p := range points {
+		line, lerr := lineProtocol([]*write.Point{p}, time.Second)
+
+		if lerr != nil {
+			line = fmt.Sprintf("unencodable point %q\n", p.Name())
+		}
+
+		fmt.Fprintf(&sb, "%s %s %s", clock.Now().UTC().Format(time.RFC3339), result, line)
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	// Problems with the log itself are only logged, they shouldn't fail the
+	// writes it's there to record
+	if w.file != nil && w.size > 0 && w.size + int64(sb.Len()) > w.maxBytes {
+		if err := w.rotate(); err != nil {
+			log.Printf("Error rotating the write log, it's off until the next reload: %v", err)
+			w.file = nil
+		}
+	}
+
+	if w.file != nil {
+		n, _ := w.file.WriteString(sb.String())
+		w.size += int64(n)
+	}
+
+	return err
+}
+
+func (w *walSink) Close() {
+	w.Sink.Close()
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.file != nil {
+		w.file.Close()
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+func TestWALSink(t *testing.T) {
+	useSelfAdvancingClock(t)
+
+	path := filepath.Join(t.TempDir(), "writes.log")
+	s := &memorySink{}
+
+	w, err := withWAL(s, DebugConfig{WALPath: path, WALMaxBytes: 1 << 20})
+
+	if err != nil {
+		t.Fatalf("withWAL returned %v", err)
+	}
+
+	defer w.Close()
+
+	if err := w.Write(context.Background(), []*write.Point{locationPoint("Lisbon"), locationPoint("Porto")}); err != nil {
+		t.Fatalf("Write returned %v", err)
+	}
+
+	s.setFailing(fmt.Errorf("connection refused"))
+
+	if err := w.Write(context.Background(), []*write.Point{locationPoint("Faro")}); err == nil {
+		t.Fatalf("Write to a failing sink returned no error")
+	}
+
+	logged, _ := os.ReadFile(path)
+
+	want := []string{
+		"2022-06-01T12:00:00Z ok weather,location=Lisbon temperature=18.5",
+		"2022-06-01T12:00:00Z ok weather,location=Porto temperature=18.5",
+		"2022-06-01T12:00:00Z error \"connection refused\" weather,location=Faro temperature=18.5",
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(logged), "\n"), "\n")
+
+	if len(lines) != len(want) {
+		t.Fatalf("Write log has %d lines, want %d:\n%s", len(lines), len(want), logged)
+	}
+
+	for i, line := range lines {
+		if !strings.HasPrefix(line, want[i]) {
+			t.Errorf("Line %d is '%s', want it to start with '%s'", i + 1, line, want[i])
+		}
+	}
+}
+
+func TestWALSinkRotates(t *testing.T) {
+	useSelfAdvancingClock(t)
+
+	path := filepath.Join(t.TempDir(), "writes.log")
+
+	// Room for about two lines
+	w, err := withWAL(&memorySink{}, DebugConfig{WALPath: path, WALMaxBytes: 150})
+
+	if err != nil {
+		t.Fatalf("withWAL returned %v", err)
+	}
+
+	defer w.Close()
+
+	for _, location := range []string{"Lisbon", "Porto", "Faro"} {
+		if err := w.Write(context.Background(), []*write.Point{locationPoint(location)}); err != nil {
+			t.Fatalf("Write returned %v", err)
+		}
+	}
+
+	current, _ := os.ReadFile(path)
+	previous, _ := os.ReadFile(path + ".1")
+
+	if !strings.Contains(string(current), "Faro") || strings.Contains(string(current), "Lisbon") {
+		t.Errorf("Write log after rotating is:\n%s", current)
+	}
+
+	if !strings.Contains(string(previous), "Lisbon") || !strings.Contains(string(previous), "Porto") {
+		t.Errorf("Rotated write log is:\n%s", previous)
+	}
+}
+
+func TestWithoutWAL(t *testing.T) {
+	s := &memorySink{}
+
+	if w, err := withWAL(s, DebugConfig{}); err != nil || w != Sink(s) {
+		t.Errorf("withWAL without a path returned %v, %v, want the sink itself", w, err)
+	}
+}
+
+func TestWALSinkHealth(t *testing.T) {
+	tests := []struct {
+		name string
+		fail error
+		wantErr bool
+	}{
+		{"sink up", nil, false},
+		{"sink down", fmt.Errorf("connection refused"), true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := &memorySink{}
+			s.setFailing(test.fail)
+
+			w, err := withWAL(s, DebugConfig{WALPath: filepath.Join(t.TempDir(), "writes.log"), WALMaxBytes: 1 << 20})
+
+			if err != nil {
+				t.Fatalf("withWAL returned %v", err)
+			}
+
+			defer w.Close()
+
+			// The health of the sink the log wraps
+			if err := w.Health(context.Background()); (err != nil) != test.wantErr {
+				t.Errorf("Health returned %v, want an error: %v", err, test.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"log"
+	"os"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// When the last cycle finished, in Unix nanoseconds
+var heartbeat int64
+
+// beat tells the watchdog the loop is still making progress
+func beat() {
+	atomic.StoreInt64(&heartbeat, clock.Now().UnixNano())
+}
+
+// runWatchdog checks that cycles keep finishing, every quarter of the timeout
+func runWatchdog(cfg WatchdogConfig) {
+	ticker := clock.NewTicker(time.Duration(cfg.TimeoutSeconds) * time.Second / 4)
+
+	for range ticker.C() {
+		checkHeartbeat(cfg)
+	}
+}
+
+// checkHeartbeat looks at when the last cycle finished. If none has within
+// the timeout, it logs the stacks of every goroutine to show where the loop
+// is stuck, and exits if configured to so a supervisor can restart the
+// sensor.
+func checkHeartbeat(cfg WatchdogConfig) {
+	timeout := time.Duration(cfg.TimeoutSeconds) * time.Second
+	since := clock.Now().Sub(time.Unix(0, atomic.LoadInt64(&heartbeat)))
+
+	if since < timeout {
+		return
+	}
+
+	stacks := make([]byte, 1 << 20)
+	stacks = stacks[:runtime.Stack(stacks, true)]
+
+	log.Printf("WATCHDOG: no cycle has finished in %v, the loop looks stuck. Goroutines:\n%s", since.Round(time.Second), stacks)
+
+	if cfg.Exit {
+		os.Exit(exitFailure)
+	}
+
+	// Only complain again once another timeout has gone by
+	beat()
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckHeartbeat(t *testing.T) {
+	tests := []struct {
+		name string
+		// Whether a cycle finishes before each check, every 15s
+		beats []bool
+		wantWarnings int
+	}{
+		{"cycles finishing", []bool{true, true, true, true, true, true}, 0},
+		{"slow cycle", []bool{false, false, false, true, false}, 0},
+		{"stuck", []bool{false, false, false, false}, 1},
+		// Only warns again once another timeout has gone by
+		{"stuck for longer", []bool{false, false, false, false, false, false, false, false}, 2},
+		{"stuck then recovering", []bool{false, false, false, false, true, true, true, true}, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := useSelfAdvancingClock(t)
+			logged := captureLog(t)
+			beat()
+
+			for _, beats := range test.beats {
+				if beats {
+					beat()
+				}
+
+				c.Advance(15 * time.Second)
+				checkHeartbeat(WatchdogConfig{TimeoutSeconds: 60})
+			}
+
+			if got := strings.Count(logged.String(), "WATCHDOG"); got != test.wantWarnings {
+				t.Errorf("Watchdog warned %d times, want %d", got, test.wantWarnings)
+			}
+		})
+	}
+}